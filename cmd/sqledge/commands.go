@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/zknill/sqledge/pkg/admin"
+	"github.com/zknill/sqledge/pkg/backup"
+	"github.com/zknill/sqledge/pkg/replicate"
+)
+
+func snapshotCmd(ctx context.Context, args []string) error {
+	f := newFlags("snapshot")
+	out := f.fs.String("out", "", "also export the local database as a snapshot artifact to this path, for other nodes to bootstrap from")
+
+	cfg, err := f.load(args)
+	if err != nil || cfg == nil {
+		return err
+	}
+
+	res, err := replicate.NewReplicator(cfg).Snapshot(ctx)
+	if err != nil {
+		return err
+	}
+
+	var artifact *replicate.Manifest
+
+	if *out != "" {
+		artifact, err = replicate.Export(ctx, cfg, *out)
+		if err != nil {
+			return fmt.Errorf("export: %w", err)
+		}
+	}
+
+	return f.print(struct {
+		*replicate.SnapshotResult
+		Artifact *replicate.Manifest `json:"artifact,omitempty"`
+	}{res, artifact}, func(w io.Writer) {
+		if !res.Copied {
+			fmt.Fprintf(w, "already copied at %s\n", res.LSN)
+		} else {
+			fmt.Fprintf(w, "copied %d tables at %s in %.1fs, slot %q\n", len(res.Tables), res.LSN, res.DurationSeconds, res.Slot)
+		}
+
+		if artifact != nil {
+			fmt.Fprintf(w, "exported %s at %s, sha256 %s\n", *out, artifact.LSN, artifact.SHA256)
+		}
+	})
+}
+
+func bootstrapCmd(ctx context.Context, args []string) error {
+	f := newFlags("bootstrap")
+
+	cfg, err := f.load(args)
+	if err != nil || cfg == nil {
+		return err
+	}
+
+	path := cfg.Local.Bootstrap
+	if f.fs.NArg() == 1 {
+		path = f.fs.Arg(0)
+	}
+
+	if path == "" || f.fs.NArg() > 1 {
+		fmt.Fprintln(os.Stderr, "usage: sqledge bootstrap [flags] <artifact>")
+		return errUsage
+	}
+
+	m, err := replicate.Bootstrap(ctx, cfg, path)
+	if err != nil {
+		return err
+	}
+
+	return f.print(m, func(w io.Writer) {
+		fmt.Fprintf(w, "bootstrapped %s at %s, slot %q\n", cfg.Local.Path, m.LSN, cfg.Replication.SlotName)
+	})
+}
+
+func statusCmd(ctx context.Context, args []string) error {
+	f := newFlags("status")
+
+	cfg, err := f.load(args)
+	if err != nil || cfg == nil {
+		return err
+	}
+
+	report, err := replicate.Inspect(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	return f.print(report, func(w io.Writer) {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		defer tw.Flush()
+
+		fmt.Fprintf(tw, "slot\t%s\n", report.Slot)
+		fmt.Fprintf(tw, "slot exists\t%t\n", report.SlotExists)
+		fmt.Fprintf(tw, "slot active\t%t\n", report.SlotActive)
+		fmt.Fprintf(tw, "publication\t%s\n", report.Publication)
+		fmt.Fprintf(tw, "local lsn\t%s\n", orNone(report.LocalLSN))
+		fmt.Fprintf(tw, "server lsn\t%s\n", report.ServerLSN)
+		fmt.Fprintf(tw, "confirmed flush lsn\t%s\n", orNone(report.ConfirmedFlushLSN))
+		fmt.Fprintf(tw, "lag\t%d bytes\n", report.LagBytes)
+		fmt.Fprintf(tw, "retained wal\t%d bytes\n", report.RetainedBytes)
+	})
+}
+
+func resyncCmd(ctx context.Context, args []string) error {
+	f := newFlags("resync")
+
+	cfg, err := f.load(args)
+	if err != nil || cfg == nil {
+		return err
+	}
+
+	if f.fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: sqledge resync [flags] <table>")
+		return errUsage
+	}
+
+	table := f.fs.Arg(0)
+
+	client := admin.NewClient(fmt.Sprintf("%s:%d", cfg.Admin.Address, cfg.Admin.Port), cfg.Admin.Token)
+	if err := client.Resync(ctx, table); err != nil {
+		return fmt.Errorf("resync %q: %w", table, err)
+	}
+
+	return f.print(struct {
+		Table string `json:"table"`
+	}{Table: table}, func(w io.Writer) {
+		fmt.Fprintf(w, "resynced %q\n", table)
+	})
+}
+
+func verifyCmd(ctx context.Context, args []string) error {
+	f := newFlags("verify")
+	repair := f.fs.Bool("repair", false, "resync the tables that differ")
+
+	cfg, err := f.load(args)
+	if err != nil || cfg == nil {
+		return err
+	}
+
+	client := admin.NewClient(fmt.Sprintf("%s:%d", cfg.Admin.Address, cfg.Admin.Port), cfg.Admin.Token)
+
+	report, err := client.Verify(ctx, *repair)
+	if err != nil {
+		return fmt.Errorf("verify: %w", err)
+	}
+
+	if err := f.print(report, func(w io.Writer) {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+		fmt.Fprintf(tw, "TABLE\tUPSTREAM\tLOCAL\tMISSING\tEXTRA\tDIFFERING\tMATCH\n")
+
+		for _, t := range report.Tables {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%t\n",
+				t.Table, t.UpstreamRows, t.LocalRows, t.Missing, t.Extra, t.Differing, t.Match)
+		}
+
+		tw.Flush()
+
+		fmt.Fprintf(w, "\nverified at %s in %.1fs\n", report.LSN, report.DurationSeconds)
+
+		for _, t := range report.Tables {
+			printKeys(w, t.Table, "missing", t.MissingKeys)
+			printKeys(w, t.Table, "extra", t.ExtraKeys)
+			printKeys(w, t.Table, "differing", t.DifferingKeys)
+
+			if t.Error != "" {
+				fmt.Fprintf(w, "%s: %s\n", t.Table, t.Error)
+			}
+		}
+
+		for _, table := range report.Repaired {
+			fmt.Fprintf(w, "repaired %q\n", table)
+		}
+	}); err != nil {
+		return err
+	}
+
+	differing := 0
+	for _, t := range report.Tables {
+		if !t.Match {
+			differing++
+		}
+	}
+
+	if differing > len(report.Repaired) {
+		return fmt.Errorf("local database doesn't match the upstream")
+	}
+
+	return nil
+}
+
+func printKeys(w io.Writer, table, kind string, keys []string) {
+	if len(keys) > 0 {
+		fmt.Fprintf(w, "%s %s: %s\n", table, kind, strings.Join(keys, "; "))
+	}
+}
+
+func backupCmd(ctx context.Context, args []string) error {
+	f := newFlags("backup")
+
+	cfg, err := f.load(args)
+	if err != nil || cfg == nil {
+		return err
+	}
+
+	res, err := backup.Run(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	return f.print(res, func(w io.Writer) {
+		fmt.Fprintf(w, "backed up at %s to %s, %d bytes\n", res.LSN, res.Path, res.Size)
+
+		for _, path := range res.Removed {
+			fmt.Fprintf(w, "removed %s\n", path)
+		}
+	})
+}
+
+func ddlCmd(ctx context.Context, args []string) error {
+	f := newFlags("ddl")
+	uninstall := f.fs.Bool("uninstall", false, "drop the event triggers")
+
+	cfg, err := f.load(args)
+	if err != nil || cfg == nil {
+		return err
+	}
+
+	install := replicate.InstallDDL
+	if *uninstall {
+		install = replicate.UninstallDDL
+	}
+
+	res, err := install(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	return f.print(res, func(w io.Writer) {
+		if !res.Installed {
+			fmt.Fprintf(w, "event triggers dropped\n")
+			return
+		}
+
+		fmt.Fprintf(w, "event triggers installed: %s\n", strings.Join(res.Triggers, ", "))
+	})
+}
+
+func resetCmd(ctx context.Context, args []string) error {
+	f := newFlags("reset")
+	yes := f.fs.Bool("yes", false, "don't ask for confirmation")
+
+	cfg, err := f.load(args)
+	if err != nil || cfg == nil {
+		return err
+	}
+
+	if !*yes {
+		if *f.json {
+			return fmt.Errorf("reset with -json needs -yes")
+		}
+
+		fmt.Fprintf(os.Stderr, "this drops slot %q and publication %q upstream, and removes %s\n",
+			cfg.Replication.SlotName, cfg.Replication.Publication, cfg.Local.Path)
+		fmt.Fprintf(os.Stderr, "type the slot name to confirm: ")
+
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if strings.TrimSpace(answer) != cfg.Replication.SlotName {
+			return fmt.Errorf("not confirmed")
+		}
+	}
+
+	res, err := replicate.Reset(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	return f.print(res, func(w io.Writer) {
+		fmt.Fprintf(w, "slot dropped: %t\n", res.SlotDropped)
+		fmt.Fprintf(w, "publication dropped: %t\n", res.PublicationDropped)
+
+		for _, path := range res.FilesRemoved {
+			fmt.Fprintf(w, "removed %s\n", path)
+		}
+	})
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+
+	return s
+}
@@ -2,34 +2,146 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/zknill/sqledge/pkg/config"
-	"github.com/zknill/sqledge/pkg/queryproxy"
-	"github.com/zknill/sqledge/pkg/replicate"
 )
 
+type command struct {
+	usage string
+	run   func(ctx context.Context, args []string) error
+}
+
+var commands = map[string]command{
+	"run":       {usage: "replicate and serve queries, the default", run: runCmd},
+	"snapshot":  {usage: "run the initial copy and exit, -out exports it as an artifact", run: snapshotCmd},
+	"bootstrap": {usage: "bootstrap <artifact>: start the local database from an exported artifact", run: bootstrapCmd},
+	"status":    {usage: "print the local position, the upstream slot and the lag", run: statusCmd},
+	"resync":    {usage: "resync <table>: resync a table in the running sqledge", run: resyncCmd},
+	"verify":    {usage: "compare the local database with the upstream", run: verifyCmd},
+	"backup":    {usage: "write a consistent copy of the local database, while sqledge runs", run: backupCmd},
+	"reset":     {usage: "drop the slot, the publication and the local database", run: resetCmd},
+	"ddl":       {usage: "install the event triggers that replicate DDL, -uninstall drops them", run: ddlCmd},
+}
+
+// errUsage is returned for bad arguments, the usage has been printed.
+var errUsage = errors.New("usage")
+
 func main() {
-	flag.Parse()
+	exitCode := 0
+	defer func() { os.Exit(exitCode) }()
+
 	zerolog.SetGlobalLevel(zerolog.DebugLevel)
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
-	ctx := context.Background()
 
-	cfg, err := config.Load()
+	name, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		usage()
+		exitCode = 2
+		return
+	}
+
+	if name != "run" {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := cmd.run(ctx, args); err != nil {
+		if errors.Is(err, errUsage) {
+			exitCode = 2
+			return
+		}
+
+		log.Error().Err(err).Msgf("sqledge %s failed", name)
+		exitCode = 1
+	}
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	fmt.Fprintf(os.Stderr, "usage: sqledge <command> [flags]\n\ncommands:\n")
+
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].usage)
+	}
+
+	fmt.Fprintf(os.Stderr, "\nrun sqledge <command> -h for the flags\n")
+}
+
+// flags are the flags shared by every command.
+type flags struct {
+	fs          *flag.FlagSet
+	loader      *config.Loader
+	json        *bool
+	printConfig *bool
+}
+
+func newFlags(name string) *flags {
+	fs := flag.NewFlagSet("sqledge "+name, flag.ContinueOnError)
+
+	return &flags{
+		fs:          fs,
+		loader:      config.NewLoader(fs),
+		json:        fs.Bool("json", false, "print the output as JSON"),
+		printConfig: fs.Bool("print-config", false, "print the effective config, with secrets redacted, and exit"),
+	}
+}
+
+// load parses the args and loads the config. It returns
+// a nil config if the config was printed instead.
+func (f *flags) load(args []string) (*config.Config, error) {
+	if err := f.fs.Parse(args); err != nil {
+		return nil, errUsage
+	}
+
+	cfg, err := f.loader.Load()
 	if err != nil {
-		log.Fatal().Err(err).Msg("failed to parse config")
+		return nil, fmt.Errorf("invalid config: %w", err)
 	}
 
-	if err := queryproxy.Run(ctx, cfg); err != nil {
-		log.Fatal().Err(err).Msg("failed to start sqledge")
+	if *f.printConfig {
+		fmt.Print(cfg.Redacted())
+		return nil, nil
 	}
 
-	if err := replicate.Run(ctx, cfg); err != nil {
-		log.Fatal().Err(err).Msg("failed in replicate")
+	return cfg, nil
+}
+
+// print writes v as JSON with -json, or as text.
+func (f *flags) print(v any, text func(w io.Writer)) error {
+	if !*f.json {
+		text(os.Stdout)
+		return nil
 	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(v)
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/zknill/sqledge/pkg/admin"
+	"github.com/zknill/sqledge/pkg/backup"
+	"github.com/zknill/sqledge/pkg/cdc"
+	"github.com/zknill/sqledge/pkg/hooks"
+	"github.com/zknill/sqledge/pkg/queryproxy"
+	"github.com/zknill/sqledge/pkg/relay"
+	"github.com/zknill/sqledge/pkg/replicate"
+	"github.com/zknill/sqledge/pkg/supervisor"
+	"github.com/zknill/sqledge/pkg/tracing"
+)
+
+func runCmd(ctx context.Context, args []string) error {
+	f := newFlags("run")
+
+	cfg, err := f.load(args)
+	if err != nil || cfg == nil {
+		return err
+	}
+
+	log.Debug().Msgf("effective config:\n%s", cfg.Redacted())
+
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Config{
+		Endpoint:    cfg.Tracing.Endpoint,
+		Insecure:    cfg.Tracing.Insecure,
+		SampleRatio: cfg.Tracing.SampleRatio,
+	})
+	if err != nil {
+		return fmt.Errorf("set up tracing: %w", err)
+	}
+
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := shutdownTracing(ctx); err != nil {
+			log.Warn().Err(err).Msg("flush traces")
+		}
+	}()
+
+	replicator := replicate.NewReplicator(cfg)
+	proxy := queryproxy.NewProxy(cfg, replicator.Feed())
+
+	components := []supervisor.Component{
+		{Name: "proxy", Run: proxy.Run},
+		{Name: "replicate", Run: func(ctx context.Context) error {
+			err := replicator.Run(ctx)
+			if errors.Is(err, replicate.ErrSlotLost) {
+				// restarting creates a new slot past the lost changes
+				return supervisor.Permanent(err)
+			}
+
+			return err
+		}},
+	}
+
+	if len(cfg.Hooks.Targets) > 0 {
+		components = append(components, supervisor.Component{
+			Name: "hooks",
+			Run:  func(ctx context.Context) error { return hooks.Run(ctx, cfg) },
+		})
+	}
+
+	if cfg.Verify.Interval > 0 {
+		components = append(components, supervisor.Component{Name: "verify", Run: replicator.RunVerify})
+	}
+
+	if cfg.Admin.Enabled {
+		server := admin.New(
+			admin.Config{
+				Address:     fmt.Sprintf("%s:%d", cfg.Admin.Address, cfg.Admin.Port),
+				ReadyMaxLag: cfg.Admin.ReadyMaxLag,
+				Token:       cfg.Admin.Token,
+				Backup: func(ctx context.Context) (*backup.Result, error) {
+					return backup.Run(ctx, cfg)
+				},
+				Relay: relay.Handler(replicator.Feed(), func(ctx context.Context, path string) (string, string, error) {
+					m, err := replicate.Export(ctx, cfg, path)
+					if err != nil {
+						return "", "", err
+					}
+
+					return m.LSN, m.SHA256, nil
+				}),
+				Changes: cdc.Handler(replicator.Feed()),
+			},
+			replicator,
+			proxy,
+		)
+
+		components = append(components, supervisor.Component{Name: "admin", Run: server.Run})
+	}
+
+	s, err := supervisor.New(
+		supervisor.Config{
+			Policy:          supervisor.Policy(cfg.Supervisor.RestartPolicy),
+			MaxRestarts:     cfg.Supervisor.MaxRestarts,
+			Backoff:         cfg.Supervisor.RestartBackoff,
+			ResetAfter:      cfg.Supervisor.ResetAfter,
+			ShutdownTimeout: cfg.Supervisor.ShutdownTimeout,
+		},
+		components...,
+	)
+	if err != nil {
+		return fmt.Errorf("start sqledge: %w", err)
+	}
+
+	return s.Run(ctx)
+}
@@ -0,0 +1,342 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/zknill/sqledge/pkg/backup"
+	"github.com/zknill/sqledge/pkg/metrics"
+	"github.com/zknill/sqledge/pkg/replicate"
+	"github.com/zknill/sqledge/pkg/verify"
+)
+
+type Replicator interface {
+	Status() replicate.Status
+	Pause()
+	Resume()
+	Resync(ctx context.Context, table string) error
+	Verify(ctx context.Context, repair bool) (*verify.Report, error)
+}
+
+type Proxy interface {
+	Conns() int
+}
+
+type Config struct {
+	Address     string
+	ReadyMaxLag time.Duration
+	Token       string
+
+	// Backup backs up the local database, the backup
+	// action is only served if it's set.
+	Backup func(ctx context.Context) (*backup.Result, error)
+
+	// Relay serves children replicating from this sqledge,
+	// it's served under /relay/ if it's set.
+	Relay http.Handler
+
+	// Changes streams the row changes to local
+	// applications, it's served on /changes if it's set.
+	Changes http.Handler
+}
+
+// Server is the HTTP admin and health API.
+type Server struct {
+	cfg        Config
+	replicator Replicator
+	proxy      Proxy
+}
+
+func New(cfg Config, replicator Replicator, proxy Proxy) *Server {
+	return &Server{
+		cfg:        cfg,
+		replicator: replicator,
+		proxy:      proxy,
+	}
+}
+
+// Run serves the API until the context is done.
+func (s *Server) Run(ctx context.Context) error {
+	lis, err := net.Listen("tcp", s.cfg.Address)
+	if err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+
+	srv := &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Warn().Err(err).Msg("admin shutdown")
+		}
+	}()
+
+	log.Debug().Msgf("admin listening on %s", s.cfg.Address)
+
+	if err := srv.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("serve: %w", err)
+	}
+
+	return ctx.Err()
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/healthz", get(s.healthz))
+	mux.HandleFunc("/readyz", get(s.readyz))
+	mux.HandleFunc("/status", get(s.status))
+	mux.HandleFunc("/replication/pause", s.admin(s.pause))
+	mux.HandleFunc("/replication/resume", s.admin(s.resume))
+	mux.HandleFunc("/tables/", s.admin(s.resync))
+	mux.HandleFunc("/verify", s.admin(s.verify))
+
+	if s.cfg.Backup != nil {
+		mux.HandleFunc("/backup", s.admin(s.backup))
+	}
+
+	if s.cfg.Relay != nil {
+		mux.HandleFunc("/relay/", get(s.authorized(s.cfg.Relay.ServeHTTP)))
+	}
+
+	if s.cfg.Changes != nil {
+		mux.HandleFunc("/changes", get(s.authorized(s.cfg.Changes.ServeHTTP)))
+	}
+	mux.HandleFunc("/metrics", get(s.authorized(metrics.Handler().ServeHTTP)))
+
+	return mux
+}
+
+type statusResponse struct {
+	Slot           string    `json:"slot"`
+	Publication    string    `json:"publication"`
+	Copied         bool      `json:"copied"`
+	Streaming      bool      `json:"streaming"`
+	Paused         bool      `json:"paused"`
+	CurrentLSN     string    `json:"current_lsn"`
+	ConfirmedLSN   string    `json:"confirmed_lsn"`
+	ServerLSN      string    `json:"server_lsn"`
+	LastCommitTime time.Time `json:"last_commit_time"`
+	LagSeconds     float64   `json:"lag_seconds"`
+	Tables         []string  `json:"tables"`
+	Connections    int       `json:"connections"`
+	Maintenance    bool      `json:"maintenance"`
+	Barrier        string    `json:"barrier,omitempty"`
+	BarrierLSN     string    `json:"barrier_lsn,omitempty"`
+}
+
+type readyResponse struct {
+	Ready  bool   `json:"ready"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// healthz is healthy while the process is serving requests.
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, struct {
+		Status string `json:"status"`
+	}{Status: "ok"})
+}
+
+// readyz is ready once the initial copy has completed, and
+// changes are being applied within the max lag.
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	st := s.replicator.Status()
+	lag := st.LagAt(time.Now())
+
+	reason := ""
+
+	switch {
+	case !st.Copied:
+		reason = "initial copy not complete"
+	case !st.Streaming:
+		reason = "not streaming"
+	case st.Paused:
+		reason = "paused"
+	case st.Maintenance:
+		reason = "maintenance"
+	case s.cfg.ReadyMaxLag > 0 && lag > s.cfg.ReadyMaxLag:
+		reason = fmt.Sprintf("lag %s over %s", lag.Round(time.Millisecond), s.cfg.ReadyMaxLag)
+	}
+
+	if reason != "" {
+		writeJSON(w, http.StatusServiceUnavailable, readyResponse{Reason: reason})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, readyResponse{Ready: true})
+}
+
+func (s *Server) status(w http.ResponseWriter, r *http.Request) {
+	st := s.replicator.Status()
+
+	res := statusResponse{
+		Slot:           st.Slot,
+		Publication:    st.Publication,
+		Copied:         st.Copied,
+		Streaming:      st.Streaming,
+		Paused:         st.Paused,
+		CurrentLSN:     st.CurrentLSN.String(),
+		ConfirmedLSN:   st.ConfirmedLSN.String(),
+		ServerLSN:      st.ServerLSN.String(),
+		LastCommitTime: st.LastCommitTime,
+		LagSeconds:     st.LagAt(time.Now()).Seconds(),
+		Tables:         st.Tables,
+		Connections:    s.proxy.Conns(),
+		Maintenance:    st.Maintenance,
+		Barrier:        st.Barrier,
+	}
+
+	if st.BarrierLSN != 0 {
+		res.BarrierLSN = st.BarrierLSN.String()
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) pause(w http.ResponseWriter, r *http.Request) {
+	s.replicator.Pause()
+	s.status(w, r)
+}
+
+func (s *Server) resume(w http.ResponseWriter, r *http.Request) {
+	s.replicator.Resume()
+	s.status(w, r)
+}
+
+// resync handles POST /tables/{table}/resync, and
+// returns once the table has been resynced.
+func (s *Server) resync(w http.ResponseWriter, r *http.Request) {
+	table := strings.TrimPrefix(r.URL.Path, "/tables/")
+
+	table, ok := strings.CutSuffix(table, "/resync")
+	if !ok || table == "" || strings.Contains(table, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	log.Info().Msgf("resync %q requested", table)
+
+	err := s.replicator.Resync(r.Context(), table)
+
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusOK, struct {
+			Table string `json:"table"`
+		}{Table: table})
+	case errors.Is(err, replicate.ErrResyncInProgress):
+		writeJSON(w, http.StatusConflict, errorResponse{Error: err.Error()})
+	case errors.Is(err, replicate.ErrNotStreaming):
+		writeJSON(w, http.StatusServiceUnavailable, errorResponse{Error: err.Error()})
+	default:
+		log.Error().Err(err).Msgf("resync %q", table)
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+	}
+}
+
+// verify handles POST /verify, with ?repair=true to resync the
+// tables that differ, and returns the report once it's done.
+func (s *Server) verify(w http.ResponseWriter, r *http.Request) {
+	repair := r.URL.Query().Get("repair") == "true"
+
+	log.Info().Msgf("verify requested, repair: %t", repair)
+
+	report, err := s.replicator.Verify(r.Context(), repair)
+
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusOK, report)
+	case errors.Is(err, replicate.ErrVerifyInProgress), errors.Is(err, replicate.ErrResyncInProgress):
+		writeJSON(w, http.StatusConflict, errorResponse{Error: err.Error()})
+	case errors.Is(err, replicate.ErrNotStreaming):
+		writeJSON(w, http.StatusServiceUnavailable, errorResponse{Error: err.Error()})
+	default:
+		log.Error().Err(err).Msg("verify")
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+	}
+}
+
+// backup handles POST /backup, and returns once the backup is written.
+func (s *Server) backup(w http.ResponseWriter, r *http.Request) {
+	log.Info().Msg("backup requested")
+
+	res, err := s.cfg.Backup(r.Context())
+	if err != nil {
+		log.Error().Err(err).Msg("backup")
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func get(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "method not allowed"})
+			return
+		}
+
+		h(w, r)
+	}
+}
+
+// admin only allows POST requests, with the token if one is set.
+func (s *Server) admin(h http.HandlerFunc) http.HandlerFunc {
+	authorized := s.authorized(h)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "method not allowed"})
+			return
+		}
+
+		authorized(w, r)
+	}
+}
+
+// authorized only allows requests with the token, if one is set.
+func (s *Server) authorized(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.cfg.Token != "" {
+			token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+			if subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.Token)) != 1 {
+				writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "unauthorized"})
+				return
+			}
+		}
+
+		h(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warn().Err(err).Msg("write response")
+	}
+}
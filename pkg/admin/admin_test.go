@@ -0,0 +1,271 @@
+package admin_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/stretchr/testify/assert"
+	"github.com/zknill/sqledge/pkg/admin"
+	"github.com/zknill/sqledge/pkg/backup"
+	"github.com/zknill/sqledge/pkg/replicate"
+	"github.com/zknill/sqledge/pkg/verify"
+)
+
+type fakeReplicator struct {
+	status   replicate.Status
+	resyncs  []string
+	verifies []bool
+	report   verify.Report
+	err      error
+}
+
+func (f *fakeReplicator) Status() replicate.Status { return f.status }
+func (f *fakeReplicator) Pause()                   { f.status.Paused = true }
+func (f *fakeReplicator) Resume()                  { f.status.Paused = false }
+
+func (f *fakeReplicator) Resync(ctx context.Context, table string) error {
+	f.resyncs = append(f.resyncs, table)
+	return f.err
+}
+
+func (f *fakeReplicator) Verify(ctx context.Context, repair bool) (*verify.Report, error) {
+	f.verifies = append(f.verifies, repair)
+	return &f.report, f.err
+}
+
+type fakeProxy int
+
+func (f fakeProxy) Conns() int { return int(f) }
+
+func do(h http.Handler, method, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name   string
+		status replicate.Status
+		code   int
+		body   string
+	}{
+		{
+			name:   "copying",
+			status: replicate.Status{Streaming: true},
+			code:   http.StatusServiceUnavailable,
+			body:   `{"ready":false,"reason":"initial copy not complete"}`,
+		},
+		{
+			name:   "lagging",
+			status: replicate.Status{Copied: true, Streaming: true, Lag: time.Minute},
+			code:   http.StatusServiceUnavailable,
+			body:   `{"ready":false,"reason":"lag 1m0s over 10s"}`,
+		},
+		{
+			name:   "paused",
+			status: replicate.Status{Copied: true, Streaming: true, Paused: true},
+			code:   http.StatusServiceUnavailable,
+			body:   `{"ready":false,"reason":"paused"}`,
+		},
+		{
+			name:   "maintenance",
+			status: replicate.Status{Copied: true, Streaming: true, Maintenance: true},
+			code:   http.StatusServiceUnavailable,
+			body:   `{"ready":false,"reason":"maintenance"}`,
+		},
+		{
+			name:   "ready",
+			status: replicate.Status{Copied: true, Streaming: true, Lag: time.Second},
+			code:   http.StatusOK,
+			body:   `{"ready":true}`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &fakeReplicator{status: tc.status}
+			h := admin.New(admin.Config{ReadyMaxLag: 10 * time.Second}, r, fakeProxy(0)).Handler()
+
+			rec := do(h, http.MethodGet, "/readyz", "")
+			assert.Equal(t, tc.code, rec.Code)
+			assert.JSONEq(t, tc.body, rec.Body.String())
+		})
+	}
+
+	t.Run("stalled", func(t *testing.T) {
+		// the last commit was applied quickly, but
+		// nothing has been applied for a minute since
+		r := &fakeReplicator{status: replicate.Status{
+			Copied:       true,
+			Streaming:    true,
+			Lag:          time.Second,
+			PendingSince: time.Now().Add(-time.Minute),
+		}}
+		h := admin.New(admin.Config{ReadyMaxLag: 10 * time.Second}, r, fakeProxy(0)).Handler()
+
+		rec := do(h, http.MethodGet, "/readyz", "")
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"reason":"lag 1m0`)
+	})
+}
+
+func TestStatus(t *testing.T) {
+	r := &fakeReplicator{status: replicate.Status{
+		Slot:           "sqledge",
+		Publication:    "sqledge",
+		Copied:         true,
+		Streaming:      true,
+		CurrentLSN:     pglogrepl.LSN(0x16B3748),
+		ConfirmedLSN:   pglogrepl.LSN(0x16B3700),
+		LastCommitTime: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Lag:            1500 * time.Millisecond,
+		Tables:         []string{"names", "things"},
+		Barrier:        "test-1",
+		BarrierLSN:     pglogrepl.LSN(0x16B3748),
+	}}
+
+	h := admin.New(admin.Config{}, r, fakeProxy(3)).Handler()
+
+	rec := do(h, http.MethodGet, "/status", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{
+		"slot": "sqledge",
+		"publication": "sqledge",
+		"copied": true,
+		"streaming": true,
+		"paused": false,
+		"current_lsn": "0/16B3748",
+		"confirmed_lsn": "0/16B3700",
+		"server_lsn": "0/0",
+		"last_commit_time": "2024-01-02T03:04:05Z",
+		"lag_seconds": 1.5,
+		"tables": ["names", "things"],
+		"connections": 3,
+		"maintenance": false,
+		"barrier": "test-1",
+		"barrier_lsn": "0/16B3748"
+	}`, rec.Body.String())
+
+	rec = do(h, http.MethodPost, "/status", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestAdminActions(t *testing.T) {
+	r := &fakeReplicator{}
+	h := admin.New(admin.Config{Token: "secret"}, r, fakeProxy(0)).Handler()
+
+	rec := do(h, http.MethodPost, "/replication/pause", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.False(t, r.status.Paused)
+
+	rec = do(h, http.MethodPost, "/replication/pause", "secret")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, r.status.Paused)
+
+	rec = do(h, http.MethodPost, "/replication/resume", "secret")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.False(t, r.status.Paused)
+
+	rec = do(h, http.MethodGet, "/tables/names/resync", "secret")
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	rec = do(h, http.MethodPost, "/tables/names/resync", "secret")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []string{"names"}, r.resyncs)
+
+	rec = do(h, http.MethodPost, "/tables/names/other", "secret")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	r.err = replicate.ErrResyncInProgress
+	rec = do(h, http.MethodPost, "/tables/names/resync", "secret")
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	r.err = replicate.ErrNotStreaming
+	rec = do(h, http.MethodPost, "/tables/names/resync", "secret")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+func TestMetrics(t *testing.T) {
+	h := admin.New(admin.Config{}, &fakeReplicator{}, fakeProxy(0)).Handler()
+
+	rec := do(h, http.MethodGet, "/metrics", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "sqledge_replication_lag_seconds")
+	assert.Contains(t, rec.Body.String(), "sqledge_proxy_connections")
+
+	h = admin.New(admin.Config{Token: "secret"}, &fakeReplicator{}, fakeProxy(0)).Handler()
+
+	rec = do(h, http.MethodGet, "/metrics", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = do(h, http.MethodGet, "/metrics", "secret")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = do(h, http.MethodPost, "/metrics", "secret")
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestClient(t *testing.T) {
+	r := &fakeReplicator{}
+	srv := httptest.NewServer(admin.New(admin.Config{Token: "secret"}, r, fakeProxy(0)).Handler())
+	defer srv.Close()
+
+	c := admin.NewClient(srv.URL, "secret")
+	assert.NoError(t, c.Resync(context.Background(), "names"))
+	assert.Equal(t, []string{"names"}, r.resyncs)
+
+	r.err = replicate.ErrResyncInProgress
+	assert.EqualError(t, c.Resync(context.Background(), "names"), "admin api: 409 Conflict: resync already in progress")
+
+	c = admin.NewClient(srv.URL, "wrong")
+	assert.EqualError(t, c.Resync(context.Background(), "names"), "admin api: 401 Unauthorized: unauthorized")
+}
+
+func TestClientVerify(t *testing.T) {
+	r := &fakeReplicator{report: verify.Report{
+		LSN: "0/16B6C50",
+		Tables: []verify.TableReport{
+			{Table: "names", UpstreamRows: 2, LocalRows: 1, Missing: 1, MissingKeys: []string{"2"}},
+		},
+	}}
+	srv := httptest.NewServer(admin.New(admin.Config{}, r, fakeProxy(0)).Handler())
+	defer srv.Close()
+
+	c := admin.NewClient(srv.URL, "")
+
+	report, err := c.Verify(context.Background(), true)
+	assert.NoError(t, err)
+	assert.Equal(t, &r.report, report)
+	assert.Equal(t, []bool{true}, r.verifies)
+
+	r.err = replicate.ErrVerifyInProgress
+	_, err = c.Verify(context.Background(), false)
+	assert.EqualError(t, err, "admin api: 409 Conflict: verify already in progress")
+	assert.Equal(t, []bool{true, false}, r.verifies)
+}
+
+func TestBackup(t *testing.T) {
+	h := admin.New(admin.Config{}, &fakeReplicator{}, fakeProxy(0)).Handler()
+	assert.Equal(t, http.StatusNotFound, do(h, http.MethodPost, "/backup", "").Code)
+
+	h = admin.New(admin.Config{
+		Backup: func(ctx context.Context) (*backup.Result, error) {
+			return &backup.Result{Path: "backups/sqledge.db", LSN: "0/1"}, nil
+		},
+	}, &fakeReplicator{}, fakeProxy(0)).Handler()
+
+	rec := do(h, http.MethodPost, "/backup", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"lsn":"0/1"`)
+}
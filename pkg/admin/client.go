@@ -0,0 +1,90 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/zknill/sqledge/pkg/verify"
+)
+
+// Client calls the admin API of a running sqledge.
+type Client struct {
+	base  string
+	token string
+	http  *http.Client
+}
+
+func NewClient(address, token string) *Client {
+	base := address
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+
+	return &Client{
+		base:  strings.TrimSuffix(base, "/"),
+		token: token,
+		http:  http.DefaultClient,
+	}
+}
+
+// Resync resyncs the table, returning once it's done.
+func (c *Client) Resync(ctx context.Context, table string) error {
+	return c.post(ctx, "/tables/"+url.PathEscape(table)+"/resync", nil)
+}
+
+// Verify verifies the local database against the upstream, with
+// repair resyncing the tables that differ.
+func (c *Client) Verify(ctx context.Context, repair bool) (*verify.Report, error) {
+	path := "/verify"
+	if repair {
+		path += "?repair=true"
+	}
+
+	var report verify.Report
+	if err := c.post(ctx, path, &report); err != nil {
+		return nil, err
+	}
+
+	return &report, nil
+}
+
+// post calls the admin action, decoding the response into out if set.
+func (c *Client) post(ctx context.Context, path string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.base+path, nil)
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("call admin api: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		if out == nil {
+			return nil
+		}
+
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("decode response: %w", err)
+		}
+
+		return nil
+	}
+
+	var body errorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Error == "" {
+		return fmt.Errorf("admin api: %s", resp.Status)
+	}
+
+	return fmt.Errorf("admin api: %s: %s", resp.Status, body.Error)
+}
@@ -0,0 +1,210 @@
+package backup
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/zknill/sqledge/pkg/config"
+	"github.com/zknill/sqledge/pkg/local"
+	"github.com/zknill/sqledge/pkg/sqlgen"
+)
+
+// Result is a backup written, and the old backups removed.
+type Result struct {
+	Path       string    `json:"path"`
+	LSN        string    `json:"lsn"`
+	Size       int64     `json:"size"`
+	Compressed bool      `json:"compressed"`
+	CreatedAt  time.Time `json:"created_at"`
+	Removed    []string  `json:"removed"`
+}
+
+// Run writes a consistent copy of the local database to the backup
+// dir, while replication keeps running. The file is named after the
+// time and the LSN it represents, e.g.
+// sqledge-20240102T150405.000Z-0_16B6C50.db, with .gz if it's compressed.
+// Only the newest backups are kept.
+func Run(ctx context.Context, cfg *config.Config) (*Result, error) {
+	if _, err := os.Stat(cfg.Local.Path); err != nil {
+		return nil, fmt.Errorf("local database: %w", err)
+	}
+
+	dir := cfg.Backup.Dir
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create backup dir: %w", err)
+	}
+
+	res := &Result{
+		Compressed: cfg.Backup.Compress,
+		CreatedAt:  time.Now().UTC(),
+		Removed:    []string{},
+	}
+
+	tmp := filepath.Join(dir, fmt.Sprintf(".%s-%d.tmp", prefix(cfg), res.CreatedAt.UnixNano()))
+	defer os.Remove(tmp)
+
+	if err := local.Copy(ctx, local.NewConfig(cfg), tmp); err != nil {
+		return nil, err
+	}
+
+	lsn, err := pos(cfg, tmp)
+	if err != nil {
+		return nil, err
+	}
+
+	res.LSN = lsn
+	res.Path = filepath.Join(dir, fmt.Sprintf("%s-%s-%s.db", prefix(cfg), res.CreatedAt.Format("20060102T150405.000Z"), strings.ReplaceAll(lsn, "/", "_")))
+
+	if res.Compressed {
+		res.Path += ".gz"
+
+		if err := compress(tmp, res.Path); err != nil {
+			os.Remove(res.Path)
+			return nil, err
+		}
+	} else if err := os.Rename(tmp, res.Path); err != nil {
+		return nil, fmt.Errorf("rename backup: %w", err)
+	}
+
+	info, err := os.Stat(res.Path)
+	if err != nil {
+		return nil, fmt.Errorf("stat backup: %w", err)
+	}
+
+	res.Size = info.Size()
+
+	res.Removed, err = rotate(cfg, cfg.Backup.Keep)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info().Msgf("backed up %s at %s to %s", cfg.Local.Path, lsn, res.Path)
+
+	return res, nil
+}
+
+// prefix is the local database's file name, without the extension.
+func prefix(cfg *config.Config) string {
+	base := filepath.Base(cfg.Local.Path)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// pos is the position the copy at path represents.
+func pos(cfg *config.Config, path string) (string, error) {
+	lcfg := local.NewConfig(cfg)
+	lcfg.Path = path
+
+	db, err := local.OpenReader(lcfg)
+	if err != nil {
+		return "", fmt.Errorf("open backup: %w", err)
+	}
+	defer db.Close()
+
+	lsn, err := sqlgen.NewSqliteDriver(sqlgen.SqliteConfig{
+		SourceDB:    cfg.Upstream.DBName,
+		Plugin:      cfg.Replication.Plugin,
+		Publication: cfg.Replication.Publication,
+	}, db).Pos()
+	if err != nil {
+		return "", err
+	}
+
+	if lsn == "" {
+		return "", errors.New("local database hasn't been copied yet")
+	}
+
+	return lsn, nil
+}
+
+func compress(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("open backup: %w", err)
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("create backup: %w", err)
+	}
+	defer out.Close()
+
+	zw := gzip.NewWriter(out)
+	zw.Name = strings.TrimSuffix(filepath.Base(dst), ".gz")
+
+	if _, err := io.Copy(zw, in); err != nil {
+		return fmt.Errorf("compress backup: %w", err)
+	}
+
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("compress backup: %w", err)
+	}
+
+	if err := out.Sync(); err != nil {
+		return fmt.Errorf("sync backup: %w", err)
+	}
+
+	return out.Close()
+}
+
+// rotate removes all but the newest keep backups, zero keeps all.
+func rotate(cfg *config.Config, keep int) ([]string, error) {
+	removed := []string{}
+
+	if keep <= 0 {
+		return removed, nil
+	}
+
+	backups, err := List(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	for len(backups) > keep {
+		if err := os.Remove(backups[0]); err != nil {
+			return nil, fmt.Errorf("remove old backup: %w", err)
+		}
+
+		removed = append(removed, backups[0])
+		backups = backups[1:]
+	}
+
+	return removed, nil
+}
+
+// List returns the paths of the backups in the backup dir, oldest first.
+func List(cfg *config.Config) ([]string, error) {
+	entries, err := os.ReadDir(cfg.Backup.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("read backup dir: %w", err)
+	}
+
+	var out []string
+
+	for _, e := range entries {
+		name := e.Name()
+
+		if e.IsDir() || !strings.HasPrefix(name, prefix(cfg)+"-") {
+			continue
+		}
+
+		if strings.HasSuffix(name, ".db") || strings.HasSuffix(name, ".db.gz") {
+			out = append(out, filepath.Join(cfg.Backup.Dir, name))
+		}
+	}
+
+	// the names sort by the time they were taken
+	sort.Strings(out)
+
+	return out, nil
+}
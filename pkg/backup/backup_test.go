@@ -0,0 +1,83 @@
+package backup_test
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zknill/sqledge/pkg/backup"
+	"github.com/zknill/sqledge/pkg/config"
+	"github.com/zknill/sqledge/pkg/local"
+	"github.com/zknill/sqledge/pkg/sqlgen"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Setenv("SQLEDGE_LOCAL_DB_PATH", filepath.Join(dir, "edge.db"))
+	t.Setenv("SQLEDGE_BACKUP_DIR", filepath.Join(dir, "backups"))
+	t.Setenv("SQLEDGE_BACKUP_KEEP", "2")
+	t.Setenv("SQLEDGE_UPSTREAM_NAME", "app")
+
+	cfg, err := config.Load()
+	require.NoError(t, err)
+
+	db, err := local.OpenWriter(local.NewConfig(cfg))
+	require.NoError(t, err)
+	defer db.Close()
+
+	sqliteCfg := sqlgen.SqliteConfig{
+		SourceDB:    cfg.Upstream.DBName,
+		Plugin:      cfg.Replication.Plugin,
+		Publication: cfg.Replication.Publication,
+	}
+
+	driver := sqlgen.NewSqliteDriver(sqliteCfg, db)
+	gen := sqlgen.NewSqlite(sqliteCfg, nil)
+
+	require.NoError(t, driver.InitPositionTable())
+
+	_, err = backup.Run(context.Background(), cfg)
+	assert.EqualError(t, err, "local database hasn't been copied yet")
+
+	var paths []string
+
+	for _, lsn := range []string{"0/1", "0/2", "0/3"} {
+		require.NoError(t, driver.Execute(gen.Pos(lsn)))
+
+		res, err := backup.Run(context.Background(), cfg)
+		require.NoError(t, err)
+
+		assert.Equal(t, lsn, res.LSN)
+		assert.Contains(t, res.Path, "_"+lsn[2:]+".db")
+
+		paths = append(paths, res.Path)
+	}
+
+	backups, err := backup.List(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, paths[1:], backups)
+
+	cfg.Backup.Compress = true
+
+	res, err := backup.Run(context.Background(), cfg)
+	require.NoError(t, err)
+	assert.Equal(t, []string{paths[1]}, res.Removed)
+
+	f, err := os.Open(res.Path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	require.NoError(t, err)
+
+	header := make([]byte, 16)
+	_, err = io.ReadFull(zr, header)
+	require.NoError(t, err)
+	assert.Equal(t, "SQLite format 3\x00", string(header))
+}
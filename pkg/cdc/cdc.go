@@ -0,0 +1,140 @@
+package cdc
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/zknill/sqledge/pkg/feed"
+)
+
+// Event is a transaction's changes to the tables subscribed to,
+// sent once the transaction has been committed locally.
+type Event struct {
+	LSN        pglogrepl.LSN
+	CommitTime time.Time
+	Changes    []feed.Change
+	Messages   []feed.Message
+}
+
+type eventJSON struct {
+	LSN        string         `json:"lsn"`
+	CommitTime time.Time      `json:"commit_time"`
+	Changes    []feed.Change  `json:"changes"`
+	Messages   []feed.Message `json:"messages,omitempty"`
+}
+
+func (e Event) MarshalJSON() ([]byte, error) {
+	return json.Marshal(eventJSON{
+		LSN:        e.LSN.String(),
+		CommitTime: e.CommitTime,
+		Changes:    e.Changes,
+		Messages:   e.Messages,
+	})
+}
+
+type Options struct {
+	// From is the position to resume after, the zero
+	// position only sends transactions committed from now.
+	From pglogrepl.LSN
+
+	// Tables are the tables to send changes for, all if empty.
+	Tables []string
+
+	// Messages are the prefixes of the messages emitted upstream
+	// with pg_logical_emit_message to send, none if empty, and
+	// * for all of them.
+	Messages []string
+}
+
+// Subscription sends the events on C, until the context is done or the
+// subscription fails. Err is why C was closed.
+type Subscription struct {
+	C <-chan Event
+
+	done chan struct{}
+	err  error
+}
+
+// Subscribe sends the changes committed after opts.From. It returns
+// feed.ErrTooOld if the hub no longer has the transactions after it.
+func Subscribe(ctx context.Context, hub *feed.Hub, opts Options) (*Subscription, error) {
+	from := opts.From
+	if from == 0 {
+		from = hub.Latest()
+	}
+
+	sub, err := hub.Subscribe(from)
+	if err != nil {
+		return nil, err
+	}
+
+	c := make(chan Event)
+
+	s := &Subscription{
+		C:    c,
+		done: make(chan struct{}),
+	}
+
+	tables := map[string]bool{}
+	for _, t := range opts.Tables {
+		tables[t] = true
+	}
+
+	go func() {
+		defer close(s.done)
+		defer close(c)
+		defer sub.Close()
+
+		for {
+			tx, err := sub.Next(ctx)
+			if err != nil {
+				s.err = err
+				return
+			}
+
+			ev := Event{LSN: tx.LSN, CommitTime: tx.CommitTime}
+
+			for _, ch := range tx.Changes {
+				if len(tables) == 0 || tables[ch.Table] {
+					ev.Changes = append(ev.Changes, ch)
+				}
+			}
+
+			for _, msg := range tx.Messages {
+				for _, p := range opts.Messages {
+					if msg.HasPrefix(p) {
+						ev.Messages = append(ev.Messages, msg)
+						break
+					}
+				}
+			}
+
+			if len(ev.Changes) == 0 && len(ev.Messages) == 0 {
+				continue
+			}
+
+			select {
+			case c <- ev:
+			case <-ctx.Done():
+				s.err = ctx.Err()
+				return
+			}
+		}
+	}()
+
+	return s, nil
+}
+
+// Err is why C was closed, it's nil until then. It's the context's
+// error if the context is done, or feed.ErrSlow if the subscriber
+// fell too far behind.
+func (s *Subscription) Err() error {
+	select {
+	case <-s.done:
+		return s.err
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,145 @@
+package cdc_test
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zknill/sqledge/pkg/cdc"
+	"github.com/zknill/sqledge/pkg/feed"
+)
+
+func tx(lsn pglogrepl.LSN, tables ...string) feed.Tx {
+	t := feed.Tx{LSN: lsn}
+
+	for _, table := range tables {
+		t.Changes = append(t.Changes, feed.Change{
+			Table: table,
+			Op:    feed.OpInsert,
+			New:   map[string]any{"id": int64(lsn)},
+		})
+	}
+
+	return t
+}
+
+func TestSubscribe(t *testing.T) {
+	hub := feed.NewHub(10)
+	hub.Start(10)
+	hub.Publish(tx(11, "users"), tx(12, "orders"), tx(13, "users", "orders"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sub, err := cdc.Subscribe(ctx, hub, cdc.Options{From: 11, Tables: []string{"users"}})
+	require.NoError(t, err)
+
+	hub.Publish(tx(14, "orders"), tx(15, "users"))
+
+	ev := <-sub.C
+	assert.Equal(t, pglogrepl.LSN(13), ev.LSN)
+	require.Len(t, ev.Changes, 1)
+	assert.Equal(t, "users", ev.Changes[0].Table)
+
+	ev = <-sub.C
+	assert.Equal(t, pglogrepl.LSN(15), ev.LSN)
+
+	cancel()
+
+	_, ok := <-sub.C
+	assert.False(t, ok)
+	assert.ErrorIs(t, sub.Err(), context.Canceled)
+
+	_, err = cdc.Subscribe(context.Background(), hub, cdc.Options{From: 9})
+	assert.ErrorIs(t, err, feed.ErrTooOld)
+}
+
+func TestSubscribeFromNow(t *testing.T) {
+	hub := feed.NewHub(10)
+	hub.Publish(tx(11, "users"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sub, err := cdc.Subscribe(ctx, hub, cdc.Options{})
+	require.NoError(t, err)
+
+	hub.Publish(tx(12, "users"))
+
+	ev := <-sub.C
+	assert.Equal(t, pglogrepl.LSN(12), ev.LSN)
+}
+
+func TestHandler(t *testing.T) {
+	hub := feed.NewHub(10)
+	hub.Start(10)
+	hub.Publish(tx(11, "users"), tx(12, "orders"))
+
+	srv := httptest.NewServer(cdc.Handler(hub))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/changes?table=orders", nil)
+	require.NoError(t, err)
+	req.Header.Set("Last-Event-ID", "0/A")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	r := bufio.NewReader(resp.Body)
+
+	var lines []string
+	for len(lines) < 3 {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		lines = append(lines, strings.TrimSuffix(line, "\n"))
+	}
+
+	assert.Equal(t, "id: 0/C", lines[0])
+	assert.Equal(t, "event: changes", lines[1])
+	assert.Equal(t, `data: {"lsn":"0/C","commit_time":"0001-01-01T00:00:00Z","changes":[{"table":"orders","op":"insert","new":{"id":12}}]}`, lines[2])
+
+	resp, err = http.Get(srv.URL + "/changes?from=0/9")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusGone, resp.StatusCode)
+}
+
+func TestSubscribeMessages(t *testing.T) {
+	hub := feed.NewHub(10)
+	hub.Start(10)
+	hub.Publish(
+		feed.Tx{LSN: 11, Messages: []feed.Message{{Prefix: "app.cache", Content: "users"}}},
+		tx(12, "users"),
+		feed.Tx{LSN: 13, Messages: []feed.Message{{Prefix: "other", Content: "skipped"}}},
+		feed.Tx{LSN: 14, Messages: []feed.Message{{Prefix: "app", Content: "bust"}}},
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sub, err := cdc.Subscribe(ctx, hub, cdc.Options{From: 10, Tables: []string{"orders"}, Messages: []string{"app"}})
+	require.NoError(t, err)
+
+	ev := <-sub.C
+	assert.Equal(t, pglogrepl.LSN(11), ev.LSN)
+	assert.Empty(t, ev.Changes)
+	assert.Equal(t, []feed.Message{{Prefix: "app.cache", Content: "users"}}, ev.Messages)
+
+	ev = <-sub.C
+	assert.Equal(t, pglogrepl.LSN(14), ev.LSN)
+}
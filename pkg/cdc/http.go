@@ -0,0 +1,151 @@
+package cdc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/rs/zerolog/log"
+	"github.com/zknill/sqledge/pkg/feed"
+)
+
+// heartbeat is how often an idle stream sends a comment,
+// to keep proxies from closing it.
+const heartbeat = 15 * time.Second
+
+// Handler streams the changes as Server-Sent Events:
+//
+//	GET /changes?table=<table>&message=<prefix>&from=<lsn>
+//
+// table and message can be repeated, or comma separated, message is the
+// prefixes of the logical decoding messages to send, and from is the position
+// to resume after. Each event's id is its LSN, so clients reconnecting
+// with Last-Event-ID resume where they left off.
+func Handler(hub *feed.Hub) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		opts, err := options(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		sub, err := Subscribe(ctx, hub, opts)
+		if errors.Is(err, feed.ErrTooOld) {
+			writeError(w, http.StatusGone, fmt.Errorf("%s: %w", opts.From, err))
+			return
+		}
+
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		flusher, _ := w.(http.Flusher)
+		flush := func() {
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flush()
+
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case ev, ok := <-sub.C:
+				if !ok {
+					if err := sub.Err(); err != nil && ctx.Err() == nil {
+						log.Warn().Err(err).Msgf("changes to %s", r.RemoteAddr)
+						fmt.Fprintf(w, "event: error\ndata: %q\n\n", err.Error())
+						flush()
+					}
+
+					return
+				}
+
+				b, err := json.Marshal(ev)
+				if err != nil {
+					log.Error().Err(err).Msg("encode changes")
+					return
+				}
+
+				if _, err := fmt.Fprintf(w, "id: %s\nevent: changes\ndata: %s\n\n", ev.LSN, b); err != nil {
+					return
+				}
+			case <-ticker.C:
+				if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+					return
+				}
+			}
+
+			flush()
+		}
+	})
+}
+
+// options reads the subscription from the request, Last-Event-ID
+// takes precedence over from.
+func options(r *http.Request) (Options, error) {
+	var opts Options
+
+	q := r.URL.Query()
+
+	opts.Tables = list(q["table"])
+	opts.Messages = list(q["message"])
+
+	from := q.Get("from")
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		from = id
+	}
+
+	if from != "" {
+		lsn, err := pglogrepl.ParseLSN(from)
+		if err != nil {
+			return opts, fmt.Errorf("invalid from: %w", err)
+		}
+
+		opts.From = lsn
+	}
+
+	return opts, nil
+}
+
+// list splits the comma separated values.
+func list(values []string) []string {
+	var out []string
+
+	for _, v := range values {
+		for _, v := range strings.Split(v, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				out = append(out, v)
+			}
+		}
+	}
+
+	return out
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	json.NewEncoder(w).Encode(errorResponse{Error: err.Error()})
+}
@@ -1,61 +1,324 @@
 package config
 
 import (
-	"fmt"
-
-	"github.com/joeshaw/envdecode"
+	"math"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// Config is loaded from the defaults and env vars in the struct tags,
+// see Loader. Secrets are redacted when the config is printed.
 type Config struct {
 	Upstream struct {
+		// URL is a postgres connection URI or keyword/value DSN,
+		// when it's set it replaces the other connection fields.
+		URL string `env:"SQLEDGE_UPSTREAM_URL" secret:"dsn"`
+
 		User    string `env:"SQLEDGE_UPSTREAM_USER,default=postgres"`
-		Pass    string `env:"SQLEDGE_UPSTREAM_PASSWORD"`
+		Pass    string `env:"SQLEDGE_UPSTREAM_PASSWORD" secret:"true"`
 		Address string `env:"SQLEDGE_UPSTREAM_ADDRESS,default=localhost"`
 		Port    int    `env:"SQLEDGE_UPSTREAM_PORT,default=5432"`
 		DBName  string `env:"SQLEDGE_UPSTREAM_NAME,default=postgres"`
 		Schema  string `env:"SQLEDGE_UPSTREAM_SCHEMA,default=public"`
+
+		// libpq connection parameters, these override the
+		// same parameters in the URL.
+		SSLMode            string        `env:"SQLEDGE_UPSTREAM_SSLMODE"`
+		SSLRootCert        string        `env:"SQLEDGE_UPSTREAM_SSLROOTCERT"`
+		SSLCert            string        `env:"SQLEDGE_UPSTREAM_SSLCERT"`
+		SSLKey             string        `env:"SQLEDGE_UPSTREAM_SSLKEY"`
+		SSLPassword        string        `env:"SQLEDGE_UPSTREAM_SSLPASSWORD" secret:"true"`
+		ConnectTimeout     time.Duration `env:"SQLEDGE_UPSTREAM_CONNECT_TIMEOUT"`
+		TargetSessionAttrs string        `env:"SQLEDGE_UPSTREAM_TARGET_SESSION_ATTRS"`
+
+		// PassFile is used instead of ~/.pgpass when there's no
+		// password. Service, or PGSERVICE, is a section of the
+		// service file that replaces the other connection fields.
+		PassFile    string `env:"SQLEDGE_UPSTREAM_PASSFILE"`
+		Service     string `env:"SQLEDGE_UPSTREAM_SERVICE"`
+		ServiceFile string `env:"SQLEDGE_UPSTREAM_SERVICEFILE"`
+
+		// Params are any other connection parameters as
+		// key=value, separated by a semicolon.
+		Params []string `env:"SQLEDGE_UPSTREAM_PARAMS"`
 	}
 
 	Replication struct {
 		Plugin               string `env:"SQLEDGE_REPLICATION_PLUGIN,default=pgoutput"`
 		SlotName             string `env:"SQLEDGE_REPLICATION_SLOT_NAME,default=sqledge"`
 		CreateSlotIfNoExists bool   `env:"SQLEDGE_REPLICATION_CREATE_SLOT,default=true"`
-		Temporary            bool   `env:"SQLEDGE_REPLICATION_TEMP_SLOT,default=true"`
+		Temporary            bool   `env:"SQLEDGE_REPLICATION_TEMP_SLOT,default=false"`
 		Publication          string `env:"SQLEDGE_REPLICATION_PUBLICATION,default=sqledge"`
+
+		// Keyless is how updates and deletes to tables without a
+		// replica identity are handled, either "error" or "skip".
+		Keyless string `env:"SQLEDGE_REPLICATION_KEYLESS,default=error"`
+
+		// Group commit, upstream transactions are applied in a
+		// single SQLite transaction until one of these is reached.
+		BatchMaxTx    int           `env:"SQLEDGE_REPLICATION_BATCH_MAX_TX,default=1"`
+		BatchMaxBytes int           `env:"SQLEDGE_REPLICATION_BATCH_MAX_BYTES,default=4194304"`
+		BatchMaxDelay time.Duration `env:"SQLEDGE_REPLICATION_BATCH_MAX_DELAY,default=100ms"`
+
+		// IndexSyncInterval is how often upstream indexes
+		// are checked for changes, zero disables the sync.
+		IndexSyncInterval time.Duration `env:"SQLEDGE_REPLICATION_INDEX_SYNC_INTERVAL,default=1m"`
+
+		// SequenceSyncInterval is how often the upstream sequences'
+		// values are read, zero only reads them on start.
+		SequenceSyncInterval time.Duration `env:"SQLEDGE_REPLICATION_SEQUENCE_SYNC_INTERVAL,default=30s"`
 	}
 
 	Local struct {
 		Path string `env:"SQLEDGE_LOCAL_DB_PATH,default=./sqledge.db"`
+
+		// Bootstrap is a snapshot artifact to start from, when
+		// there's no local database, instead of copying.
+		Bootstrap string `env:"SQLEDGE_LOCAL_BOOTSTRAP"`
+
+		// Indexes are edge only SQLite CREATE INDEX
+		// statements, separated by a semicolon.
+		Indexes []string `env:"SQLEDGE_LOCAL_INDEXES"`
+
+		// SQLite pragmas
+		Synchronous string        `env:"SQLEDGE_LOCAL_SYNCHRONOUS,default=NORMAL"`
+		CacheSize   int           `env:"SQLEDGE_LOCAL_CACHE_SIZE,default=-20000"`
+		MmapSize    int64         `env:"SQLEDGE_LOCAL_MMAP_SIZE,default=0"`
+		BusyTimeout time.Duration `env:"SQLEDGE_LOCAL_BUSY_TIMEOUT,default=5s"`
+
+		ReadConns          int           `env:"SQLEDGE_LOCAL_READ_CONNS,default=4"`
+		CheckpointInterval time.Duration `env:"SQLEDGE_LOCAL_CHECKPOINT_INTERVAL,default=1m"`
 	}
 
 	Proxy struct {
 		Address string `env:"SQLEDGE_PROXY_ADDRESS,default=localhost"`
-		Port    int    `env:"SQLEDGE_PROXY_ADDRESS,default=5433"`
+		Port    int    `env:"SQLEDGE_PROXY_PORT,default=5433"`
+
+		// Listen supports LISTEN through the proxy, with a
+		// connection to the upstream shared by the clients.
+		Listen bool `env:"SQLEDGE_PROXY_LISTEN,default=true"`
+
+		// NotifyTables are the tables that send a notification on
+		// the sqledge_<table> channel when their rows change locally.
+		NotifyTables []string `env:"SQLEDGE_PROXY_NOTIFY_TABLES"`
+	}
+
+	Admin struct {
+		Enabled bool   `env:"SQLEDGE_ADMIN_ENABLED,default=true"`
+		Address string `env:"SQLEDGE_ADMIN_ADDRESS,default=localhost"`
+		Port    int    `env:"SQLEDGE_ADMIN_PORT,default=9090"`
+
+		// ReadyMaxLag is the replication lag above which
+		// sqledge reports that it isn't ready.
+		ReadyMaxLag time.Duration `env:"SQLEDGE_ADMIN_READY_MAX_LAG,default=10s"`
+
+		// Token, if set, must be sent as a bearer token to
+		// use the admin actions.
+		Token string `env:"SQLEDGE_ADMIN_TOKEN" secret:"true"`
+	}
+
+	Tracing struct {
+		// Endpoint is the host:port of an OTLP/HTTP collector,
+		// tracing is off when it's empty.
+		Endpoint    string  `env:"SQLEDGE_TRACING_ENDPOINT"`
+		Insecure    bool    `env:"SQLEDGE_TRACING_INSECURE,default=true"`
+		SampleRatio float64 `env:"SQLEDGE_TRACING_SAMPLE_RATIO,default=1"`
+	}
+
+	Relay struct {
+		// Parent is the admin API address of a sqledge to
+		// replicate from, instead of a slot on the upstream.
+		// ParentToken is the parent's admin token.
+		Parent      string `env:"SQLEDGE_RELAY_PARENT"`
+		ParentToken string `env:"SQLEDGE_RELAY_PARENT_TOKEN" secret:"true"`
+
+		// Buffer is how many transactions are kept for
+		// children to resume from.
+		Buffer int `env:"SQLEDGE_RELAY_BUFFER,default=10000"`
+	}
+
+	Backup struct {
+		// Dir is where backups are written. Keep is how many
+		// backups are kept, older ones are removed, zero keeps
+		// all of them.
+		Dir      string `env:"SQLEDGE_BACKUP_DIR,default=./backups"`
+		Compress bool   `env:"SQLEDGE_BACKUP_COMPRESS,default=false"`
+		Keep     int    `env:"SQLEDGE_BACKUP_KEEP,default=7"`
+	}
+
+	Hooks struct {
+		// Targets are the row changes POSTed to a URL once they're
+		// applied locally, as table:ops=url, e.g.
+		// orders:insert,update=http://localhost:8080/orders. The
+		// table can be *, and without ops every change is sent.
+		Targets []string `env:"SQLEDGE_HOOKS_TARGETS"`
+
+		// Failed deliveries are retried after Backoff, doubling up
+		// to MaxBackoff, MaxAttempts times, or forever if it's zero.
+		Timeout     time.Duration `env:"SQLEDGE_HOOKS_TIMEOUT,default=10s"`
+		Backoff     time.Duration `env:"SQLEDGE_HOOKS_BACKOFF,default=1s"`
+		MaxBackoff  time.Duration `env:"SQLEDGE_HOOKS_MAX_BACKOFF,default=5m"`
+		MaxAttempts int           `env:"SQLEDGE_HOOKS_MAX_ATTEMPTS,default=0"`
+	}
+
+	Verify struct {
+		// Interval is how often the running sqledge verifies the
+		// local database against the upstream, zero is never.
+		// Repair resyncs the tables that differ.
+		Interval time.Duration `env:"SQLEDGE_VERIFY_INTERVAL,default=0"`
+		Repair   bool          `env:"SQLEDGE_VERIFY_REPAIR,default=false"`
+
+		// ChunkSize is the rows compared at once, Throttle
+		// is the pause between chunks, and MaxKeys bounds
+		// the row keys reported for each kind of difference.
+		ChunkSize int           `env:"SQLEDGE_VERIFY_CHUNK_SIZE,default=1000"`
+		Throttle  time.Duration `env:"SQLEDGE_VERIFY_THROTTLE,default=10ms"`
+		MaxKeys   int           `env:"SQLEDGE_VERIFY_MAX_KEYS,default=100"`
+	}
+
+	Supervisor struct {
+		// RestartPolicy is what happens when the proxy or the
+		// replicator fails, either "never" or "on-failure".
+		RestartPolicy  string        `env:"SQLEDGE_SUPERVISOR_RESTART_POLICY,default=on-failure"`
+		MaxRestarts    int           `env:"SQLEDGE_SUPERVISOR_MAX_RESTARTS,default=5"`
+		RestartBackoff time.Duration `env:"SQLEDGE_SUPERVISOR_RESTART_BACKOFF,default=1s"`
+
+		// ResetAfter is how long a component has to run before
+		// failing for its restarts and backoff to start over.
+		ResetAfter time.Duration `env:"SQLEDGE_SUPERVISOR_RESET_AFTER,default=5m"`
+
+		// DrainTimeout bounds how long in flight queries and the
+		// current upstream transaction get to finish on shutdown.
+		// ShutdownTimeout bounds the whole shutdown.
+		DrainTimeout    time.Duration `env:"SQLEDGE_SUPERVISOR_DRAIN_TIMEOUT,default=10s"`
+		ShutdownTimeout time.Duration `env:"SQLEDGE_SUPERVISOR_SHUTDOWN_TIMEOUT,default=15s"`
 	}
 }
 
+// PostgresConnString is the connection string for the upstream.
 func (c *Config) PostgresConnString() string {
-	pass := ""
-	if c.Upstream.Pass != "" {
-		pass = ":" + c.Upstream.Pass
+	return c.connString("application_name", "sqledge")
+}
+
+// ReplicationConnString is the connection string for
+// the upstream's logical replication protocol.
+func (c *Config) ReplicationConnString() string {
+	return c.connString("application_name", "sqledge", "replication", "database")
+}
+
+// connString adds the connection options, and the
+// defaults for the params that aren't already set.
+func (c *Config) connString(defaults ...string) string {
+	dsn := c.Upstream.URL
+
+	switch {
+	case dsn != "":
+	case c.Upstream.Service != "":
+		dsn = "service=" + quoteDSN(c.Upstream.Service)
+	default:
+		u := &url.URL{
+			Scheme: "postgres",
+			Host:   net.JoinHostPort(c.Upstream.Address, strconv.Itoa(c.Upstream.Port)),
+			Path:   "/" + c.Upstream.DBName,
+			User:   url.User(c.Upstream.User),
+		}
+
+		if c.Upstream.Pass != "" {
+			u.User = url.UserPassword(c.Upstream.User, c.Upstream.Pass)
+		}
+
+		dsn = u.String()
 	}
 
-	s := fmt.Sprintf("postgres://%s%s@%s:%d/%s?application_name=sqledge",
-		c.Upstream.User,
-		pass, c.Upstream.Address,
-		c.Upstream.Port,
-		c.Upstream.DBName,
-	)
+	options := c.options()
 
-	return s
+	if isURL(dsn) {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			// rejected when the config is loaded
+			return dsn
+		}
+
+		q := u.Query()
+
+		for i := 0; i+1 < len(options); i += 2 {
+			q.Set(options[i], options[i+1])
+		}
+
+		for i := 0; i+1 < len(defaults); i += 2 {
+			if !q.Has(defaults[i]) {
+				q.Set(defaults[i], defaults[i+1])
+			}
+		}
+
+		u.RawQuery = q.Encode()
+
+		return u.String()
+	}
+
+	// later keywords override earlier ones
+	for i := 0; i+1 < len(options); i += 2 {
+		dsn += " " + options[i] + "=" + quoteDSN(options[i+1])
+	}
+
+	for i := 0; i+1 < len(defaults); i += 2 {
+		if !hasKeyword(dsn, defaults[i]) {
+			dsn += " " + defaults[i] + "=" + quoteDSN(defaults[i+1])
+		}
+	}
+
+	return dsn
+}
+
+// options are the libpq connection params that are set.
+func (c *Config) options() []string {
+	var opts []string
+
+	add := func(key, value string) {
+		if value != "" {
+			opts = append(opts, key, value)
+		}
+	}
+
+	add("sslmode", c.Upstream.SSLMode)
+	add("sslrootcert", c.Upstream.SSLRootCert)
+	add("sslcert", c.Upstream.SSLCert)
+	add("sslkey", c.Upstream.SSLKey)
+	add("sslpassword", c.Upstream.SSLPassword)
+	add("target_session_attrs", c.Upstream.TargetSessionAttrs)
+	add("passfile", c.Upstream.PassFile)
+	add("servicefile", c.Upstream.ServiceFile)
+
+	if c.Upstream.ConnectTimeout > 0 {
+		// libpq takes whole seconds
+		add("connect_timeout", strconv.Itoa(int(math.Ceil(c.Upstream.ConnectTimeout.Seconds()))))
+	}
+
+	for _, p := range c.Upstream.Params {
+		key, value, _ := strings.Cut(p, "=")
+		add(strings.TrimSpace(key), strings.TrimSpace(value))
+	}
+
+	return opts
+}
+
+func isURL(dsn string) bool {
+	return strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://")
 }
 
-func Load() (*Config, error) {
-	var c Config
+func hasKeyword(dsn, keyword string) bool {
+	return regexp.MustCompile(`(^|\s)` + regexp.QuoteMeta(keyword) + `\s*=`).MatchString(dsn)
+}
 
-	if err := envdecode.StrictDecode(&c); err != nil {
-		return nil, fmt.Errorf("parse config: %w", err)
+// quoteDSN quotes a keyword/value DSN value if it needs it.
+func quoteDSN(v string) string {
+	if v != "" && !strings.ContainsAny(v, ` '\`) {
+		return v
 	}
 
-	return &c, nil
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
 }
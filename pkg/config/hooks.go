@@ -0,0 +1,70 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Hook is a hooks.targets entry, the changes to send to the URL.
+type Hook struct {
+	// Table is * for all tables.
+	Table string
+
+	// Ops are insert, update, delete or truncate, all if empty.
+	Ops []string
+
+	URL string
+}
+
+// Matches reports if the change to the table is sent to the hook.
+func (h Hook) Matches(table, op string) bool {
+	if h.Table != "*" && h.Table != table {
+		return false
+	}
+
+	if len(h.Ops) == 0 {
+		return true
+	}
+
+	for _, o := range h.Ops {
+		if o == op {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseHook parses a table:ops=url hooks target.
+func ParseHook(s string) (Hook, error) {
+	target, rawURL, ok := strings.Cut(s, "=")
+	if !ok {
+		return Hook{}, fmt.Errorf("invalid hook %q, want table:ops=url", s)
+	}
+
+	table, ops, _ := strings.Cut(strings.TrimSpace(target), ":")
+
+	h := Hook{Table: strings.TrimSpace(table), URL: strings.TrimSpace(rawURL)}
+
+	if h.Table == "" {
+		return Hook{}, fmt.Errorf("invalid hook %q, no table", s)
+	}
+
+	for _, op := range strings.Split(ops, ",") {
+		switch op = strings.ToLower(strings.TrimSpace(op)); op {
+		case "":
+		case "insert", "update", "delete", "truncate":
+			h.Ops = append(h.Ops, op)
+		default:
+			return Hook{}, fmt.Errorf("invalid hook %q, unknown op %q", s, op)
+		}
+	}
+
+	u, err := url.Parse(h.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Hook{}, fmt.Errorf("invalid hook %q, want an http or https url", s)
+	}
+
+	return h, nil
+}
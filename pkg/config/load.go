@@ -0,0 +1,340 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gopkg.in/yaml.v3"
+)
+
+// FileEnv is the env var for the config file path,
+// used when the -config flag isn't set.
+const FileEnv = "SQLEDGE_CONFIG_FILE"
+
+// Loader layers the config, each layer overriding the last:
+// the defaults, the config file, env vars and then CLI flags.
+type Loader struct {
+	file  string
+	flags map[string]*flagValue
+}
+
+// NewLoader registers -config and a flag for every
+// config field on fs, e.g. -proxy-port for SQLEDGE_PROXY_PORT.
+// Load must be called after fs is parsed.
+func NewLoader(fs *flag.FlagSet) *Loader {
+	l := &Loader{flags: map[string]*flagValue{}}
+
+	fs.StringVar(&l.file, "config", "", "path to a YAML or TOML config file, env "+FileEnv)
+
+	for _, f := range fields(&Config{}) {
+		v := &flagValue{isBool: f.value.Kind() == reflect.Bool}
+		l.flags[f.flag] = v
+
+		usage := "env " + f.env
+		if f.def != "" {
+			usage += " (default " + f.def + ")"
+		}
+
+		fs.Var(v, f.flag, usage)
+	}
+
+	return l
+}
+
+// Load reads the config from the defaults and env vars only.
+func Load() (*Config, error) {
+	return NewLoader(flag.NewFlagSet("sqledge", flag.ContinueOnError)).Load()
+}
+
+// Load reads and validates the config.
+func (l *Loader) Load() (*Config, error) {
+	cfg := &Config{}
+	fs := fields(cfg)
+
+	for _, f := range fs {
+		if err := setField(f.value, f.def); err != nil {
+			return nil, fmt.Errorf("default %s: %w", f.name(), err)
+		}
+	}
+
+	file := l.file
+	if file == "" {
+		file = os.Getenv(FileEnv)
+	}
+
+	if file != "" {
+		if err := loadFile(file, fs); err != nil {
+			return nil, fmt.Errorf("config file %s: %w", file, err)
+		}
+	}
+
+	for _, f := range fs {
+		v, ok := os.LookupEnv(f.env)
+		if !ok {
+			continue
+		}
+
+		if err := setField(f.value, v); err != nil {
+			return nil, fmt.Errorf("%s: %w", f.env, err)
+		}
+	}
+
+	for _, f := range fs {
+		v := l.flags[f.flag]
+		if v == nil || !v.set {
+			continue
+		}
+
+		if err := setField(f.value, v.s); err != nil {
+			return nil, fmt.Errorf("-%s: %w", f.flag, err)
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.resolve(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// resolve checks the connection string, and fills the upstream
+// connection fields from the URL or service file.
+func (c *Config) resolve() error {
+	if c.Upstream.Service == "" {
+		c.Upstream.Service = os.Getenv("PGSERVICE")
+	}
+
+	pgCfg, err := pgconn.ParseConfig(c.PostgresConnString())
+	if err != nil {
+		return fmt.Errorf("upstream connection: %w", err)
+	}
+
+	if c.Upstream.URL == "" && c.Upstream.Service == "" {
+		return nil
+	}
+
+	c.Upstream.User = pgCfg.User
+	c.Upstream.Pass = pgCfg.Password
+	c.Upstream.Address = pgCfg.Host
+	c.Upstream.Port = int(pgCfg.Port)
+	c.Upstream.DBName = pgCfg.Database
+
+	return nil
+}
+
+// field is a config field, named by its section
+// and key in the config file, env var and flag.
+type field struct {
+	section string
+	key     string
+	env     string
+	flag    string
+	def     string
+	secret  string
+	value   reflect.Value
+}
+
+func (f field) name() string {
+	return f.section + "." + f.key
+}
+
+func fields(c *Config) []field {
+	var out []field
+
+	sections := reflect.ValueOf(c).Elem()
+
+	for i := 0; i < sections.NumField(); i++ {
+		section := strings.ToLower(sections.Type().Field(i).Name)
+		s := sections.Field(i)
+
+		for j := 0; j < s.NumField(); j++ {
+			sf := s.Type().Field(j)
+
+			tag, ok := sf.Tag.Lookup("env")
+			if !ok {
+				continue
+			}
+
+			env, opts, _ := strings.Cut(tag, ",")
+			def, _ := strings.CutPrefix(opts, "default=")
+			key := strings.ToLower(strings.TrimPrefix(env, "SQLEDGE_"+strings.ToUpper(section)+"_"))
+
+			out = append(out, field{
+				section: section,
+				key:     key,
+				env:     env,
+				flag:    section + "-" + strings.ReplaceAll(key, "_", "-"),
+				def:     def,
+				secret:  sf.Tag.Get("secret"),
+				value:   s.Field(j),
+			})
+		}
+	}
+
+	return out
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func setField(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if s != "" && err != nil {
+			return fmt.Errorf("invalid duration %q", s)
+		}
+
+		v.SetInt(int64(d))
+
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		if s == "" {
+			v.SetBool(false)
+			return nil
+		}
+
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid bool %q", s)
+		}
+
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		if s == "" {
+			v.SetInt(0)
+			return nil
+		}
+
+		i, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+
+		v.SetInt(i)
+	case reflect.Float64:
+		if s == "" {
+			v.SetFloat(0)
+			return nil
+		}
+
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", s)
+		}
+
+		v.SetFloat(f)
+	case reflect.Slice:
+		var items []string
+
+		for _, item := range strings.Split(s, ";") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+
+	return nil
+}
+
+func loadFile(path string, fs []field) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	values := map[string]any{}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &values)
+	case ".toml":
+		err = toml.Unmarshal(b, &values)
+	default:
+		return fmt.Errorf("unknown format %q, want .yaml, .yml or .toml", ext)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	byName := map[string]field{}
+	for _, f := range fs {
+		byName[f.name()] = f
+	}
+
+	var errs []error
+
+	for section, v := range values {
+		keys, ok := v.(map[string]any)
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: not a section", section))
+			continue
+		}
+
+		for key, v := range keys {
+			f, ok := byName[section+"."+key]
+			if !ok {
+				errs = append(errs, fmt.Errorf("%s.%s: unknown key", section, key))
+				continue
+			}
+
+			if err := setField(f.value, fileValue(v)); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", f.name(), err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// fileValue formats values from the file as they'd be set in
+// an env var, a list is joined with a semicolon.
+func fileValue(v any) string {
+	if list, ok := v.([]any); ok {
+		items := make([]string, len(list))
+		for i, item := range list {
+			items[i] = fmt.Sprint(item)
+		}
+
+		return strings.Join(items, ";")
+	}
+
+	return fmt.Sprint(v)
+}
+
+type flagValue struct {
+	s      string
+	set    bool
+	isBool bool
+}
+
+func (v *flagValue) String() string   { return v.s }
+func (v *flagValue) IsBoolFlag() bool { return v.isBool }
+
+func (v *flagValue) Set(s string) error {
+	v.s = s
+	v.set = true
+
+	return nil
+}
@@ -0,0 +1,218 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	slotName   = regexp.MustCompile(`^[a-z0-9_]{1,63}$`)
+	identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$]{0,62}$`)
+)
+
+// Validate checks every field, returning all the problems at once.
+func (c *Config) Validate() error {
+	fs := fields(c)
+
+	v := validator{fields: map[string]field{}}
+	for _, f := range fs {
+		v.fields[f.name()] = f
+	}
+
+	v.port("upstream.port", c.Upstream.Port)
+	v.required("upstream.user", c.Upstream.User)
+	v.required("upstream.address", c.Upstream.Address)
+	v.required("upstream.name", c.Upstream.DBName)
+	v.check("upstream.schema", identifier.MatchString(c.Upstream.Schema), "invalid schema name %q", c.Upstream.Schema)
+
+	if c.Upstream.SSLMode != "" {
+		v.oneOf("upstream.sslmode", c.Upstream.SSLMode, "disable", "allow", "prefer", "require", "verify-ca", "verify-full")
+	}
+
+	if c.Upstream.TargetSessionAttrs != "" {
+		v.oneOf("upstream.target_session_attrs", c.Upstream.TargetSessionAttrs,
+			"any", "read-write", "read-only", "primary", "standby", "prefer-standby")
+	}
+
+	for _, p := range c.Upstream.Params {
+		key, _, ok := strings.Cut(p, "=")
+		v.check("upstream.params", ok && strings.TrimSpace(key) != "", "invalid param %q, want key=value", p)
+	}
+
+	v.check("replication.plugin", c.Replication.Plugin == "pgoutput", "unsupported plugin %q, only pgoutput is supported", c.Replication.Plugin)
+	v.check("replication.slot_name", slotName.MatchString(c.Replication.SlotName),
+		"invalid slot name %q, only lower case letters, numbers and underscores are allowed", c.Replication.SlotName)
+	v.check("replication.publication", identifier.MatchString(c.Replication.Publication), "invalid publication name %q", c.Replication.Publication)
+	v.oneOf("replication.keyless", c.Replication.Keyless, "error", "skip")
+	v.check("replication.batch_max_tx", c.Replication.BatchMaxTx >= 1, "must be at least 1, got %d", c.Replication.BatchMaxTx)
+	v.check("replication.batch_max_bytes", c.Replication.BatchMaxBytes >= 0, "must not be negative, got %d", c.Replication.BatchMaxBytes)
+
+	v.required("local.db_path", c.Local.Path)
+	v.oneOf("local.synchronous", strings.ToUpper(c.Local.Synchronous), "OFF", "NORMAL", "FULL", "EXTRA")
+	v.check("local.mmap_size", c.Local.MmapSize >= 0, "must not be negative, got %d", c.Local.MmapSize)
+	v.check("local.read_conns", c.Local.ReadConns >= 1, "must be at least 1, got %d", c.Local.ReadConns)
+
+	v.port("proxy.port", c.Proxy.Port)
+
+	if c.Admin.Enabled {
+		v.port("admin.port", c.Admin.Port)
+		v.check("admin.token", c.Admin.Token != "" || loopback(c.Admin.Address),
+			"required when the admin API listens on %q, which isn't a loopback address", c.Admin.Address)
+	}
+
+	v.check("tracing.sample_ratio", c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1,
+		"must be between 0 and 1, got %g", c.Tracing.SampleRatio)
+
+	v.check("relay.buffer", c.Relay.Buffer >= 0, "must not be negative, got %d", c.Relay.Buffer)
+
+	v.required("backup.dir", c.Backup.Dir)
+	v.check("backup.keep", c.Backup.Keep >= 0, "must not be negative, got %d", c.Backup.Keep)
+
+	for _, t := range c.Hooks.Targets {
+		_, err := ParseHook(t)
+		v.check("hooks.targets", err == nil, "%v", err)
+	}
+
+	v.check("hooks.timeout", c.Hooks.Timeout > 0, "must be positive, got %s", c.Hooks.Timeout)
+	v.check("hooks.backoff", c.Hooks.Backoff > 0, "must be positive, got %s", c.Hooks.Backoff)
+	v.check("hooks.max_attempts", c.Hooks.MaxAttempts >= 0, "must not be negative, got %d", c.Hooks.MaxAttempts)
+
+	v.check("verify.chunk_size", c.Verify.ChunkSize >= 1, "must be at least 1, got %d", c.Verify.ChunkSize)
+	v.check("verify.max_keys", c.Verify.MaxKeys >= 0, "must not be negative, got %d", c.Verify.MaxKeys)
+
+	v.oneOf("supervisor.restart_policy", c.Supervisor.RestartPolicy, "never", "on-failure")
+	v.check("supervisor.max_restarts", c.Supervisor.MaxRestarts >= 0, "must not be negative, got %d", c.Supervisor.MaxRestarts)
+
+	// a temporary slot is dropped with the connection, the changes after
+	// the local position are gone by the time the replicator restarts
+	v.check("replication.temp_slot", !c.Replication.Temporary || c.Supervisor.RestartPolicy != "on-failure" || c.Relay.Parent != "",
+		"a temporary slot can't be restarted from with the on-failure restart policy, use a permanent slot or the never policy")
+
+	for _, f := range fs {
+		if f.value.Type() == durationType {
+			v.check(f.name(), f.value.Int() >= 0, "must not be negative, got %s", f.value.Interface())
+		}
+	}
+
+	return errors.Join(v.errs...)
+}
+
+type validator struct {
+	fields map[string]field
+	errs   []error
+}
+
+func (v *validator) check(name string, ok bool, format string, args ...any) {
+	if ok {
+		return
+	}
+
+	v.errs = append(v.errs, fmt.Errorf("%s (%s): %s", name, v.fields[name].env, fmt.Sprintf(format, args...)))
+}
+
+func (v *validator) required(name, value string) {
+	v.check(name, value != "", "required")
+}
+
+func (v *validator) port(name string, port int) {
+	v.check(name, port > 0 && port < 65536, "must be between 1 and 65535, got %d", port)
+}
+
+func (v *validator) oneOf(name, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+
+	v.check(name, false, "must be one of %s, got %q", strings.Join(allowed, ", "), value)
+}
+
+// loopback is true if the address only accepts local connections.
+func loopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+
+	return ip != nil && ip.IsLoopback()
+}
+
+const redacted = "REDACTED"
+
+var dsnPassword = regexp.MustCompile(`(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+
+// Redacted is the effective config in the config file format,
+// with the secrets redacted.
+func (c *Config) Redacted() string {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	var section *yaml.Node
+
+	for _, f := range fields(c) {
+		if section == nil || root.Content[len(root.Content)-2].Value != f.section {
+			section = &yaml.Node{Kind: yaml.MappingNode}
+			root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: f.section}, section)
+		}
+
+		value := &yaml.Node{}
+		if err := value.Encode(redact(f)); err != nil {
+			value = &yaml.Node{Kind: yaml.ScalarNode, Value: err.Error()}
+		}
+
+		section.Content = append(section.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: f.key}, value)
+	}
+
+	var b strings.Builder
+
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+
+	if err := enc.Encode(root); err != nil {
+		return err.Error()
+	}
+
+	return b.String()
+}
+
+func redact(f field) any {
+	switch {
+	case f.value.IsZero():
+	case f.secret == "true":
+		return redacted
+	case f.secret == "dsn":
+		dsn := f.value.String()
+
+		if isURL(dsn) {
+			u, err := url.Parse(dsn)
+			if err != nil {
+				return redacted
+			}
+
+			if _, ok := u.User.Password(); ok {
+				u.User = url.UserPassword(u.User.Username(), redacted)
+			}
+
+			return u.String()
+		}
+
+		return dsnPassword.ReplaceAllString(dsn, "${1}"+redacted)
+	}
+
+	if f.value.Type() == durationType {
+		return fmt.Sprint(f.value.Interface())
+	}
+
+	if f.value.Kind() == reflect.Slice && f.value.IsNil() {
+		return []string{}
+	}
+
+	return f.value.Interface()
+}
@@ -0,0 +1,311 @@
+package feed
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+)
+
+var (
+	// ErrTooOld is returned when the transactions after
+	// the position asked for are no longer buffered.
+	ErrTooOld = errors.New("position is no longer buffered")
+
+	// ErrSlow is returned when a subscriber fell
+	// too far behind, and was dropped.
+	ErrSlow = errors.New("subscriber fell too far behind")
+
+	ErrClosed = errors.New("subscription closed")
+)
+
+// Tx is an upstream transaction, as applied to the local database.
+type Tx struct {
+	LSN        pglogrepl.LSN
+	CommitTime time.Time
+
+	// Statements are the SQLite statements the transaction
+	// was applied with, with the values inlined.
+	Statements []string
+
+	// Changes are the rows the transaction changed.
+	Changes []Change
+
+	// Messages are emitted upstream with pg_logical_emit_message. A
+	// non-transactional message is sent on its own, at its own LSN.
+	Messages []Message
+
+	// Local is work done on the local database between upstream
+	// transactions, like a table resync or an index or sequence sync,
+	// at the LSN of the transaction before it. Its statements set the
+	// state rather than change it, so applying them again is harmless,
+	// and it's sent again to subscribers resuming from its LSN.
+	Local bool
+}
+
+// Message is a message emitted upstream with pg_logical_emit_message,
+// the content is sent as text.
+type Message struct {
+	Prefix        string `json:"prefix"`
+	Content       string `json:"content"`
+	Transactional bool   `json:"transactional"`
+}
+
+// HasPrefix reports if the message's prefix is p, or
+// starts with p and a dot, e.g. app.cache has app.
+func (m Message) HasPrefix(p string) bool {
+	return p == "*" || m.Prefix == p || strings.HasPrefix(m.Prefix, p+".")
+}
+
+// Change is a row inserted, updated or deleted, or a table truncated.
+// Integers are int64, floats and numerics json.Number, bools bool,
+// json and jsonb json.RawMessage, and other values are the text postgres
+// sent. Unchanged TOAST values aren't sent, and are left out of New.
+type Change struct {
+	Table string `json:"table"`
+	Op    string `json:"op"`
+
+	// Old is the key, or the whole row with REPLICA IDENTITY FULL,
+	// it's only sent for updates that change the key.
+	Old map[string]any `json:"old,omitempty"`
+	New map[string]any `json:"new,omitempty"`
+}
+
+const (
+	OpInsert   = "insert"
+	OpUpdate   = "update"
+	OpDelete   = "delete"
+	OpTruncate = "truncate"
+)
+
+type txJSON struct {
+	LSN        string    `json:"lsn"`
+	CommitTime time.Time `json:"commit_time"`
+	Statements []string  `json:"statements"`
+	Changes    []Change  `json:"changes,omitempty"`
+	Messages   []Message `json:"messages,omitempty"`
+	Local      bool      `json:"local,omitempty"`
+}
+
+func (tx Tx) MarshalJSON() ([]byte, error) {
+	return json.Marshal(txJSON{
+		LSN:        tx.LSN.String(),
+		CommitTime: tx.CommitTime,
+		Statements: tx.Statements,
+		Changes:    tx.Changes,
+		Messages:   tx.Messages,
+		Local:      tx.Local,
+	})
+}
+
+func (tx *Tx) UnmarshalJSON(b []byte) error {
+	var v txJSON
+
+	// keep the numbers as they were sent
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	if err := dec.Decode(&v); err != nil {
+		return err
+	}
+
+	lsn, err := pglogrepl.ParseLSN(v.LSN)
+	if err != nil {
+		return err
+	}
+
+	*tx = Tx{LSN: lsn, CommitTime: v.CommitTime, Statements: v.Statements, Changes: v.Changes, Messages: v.Messages, Local: v.Local}
+
+	return nil
+}
+
+// Hub fans the transactions out to subscribers, after they are
+// committed locally, and buffers the last few for subscribers
+// to resume from.
+type Hub struct {
+	mu   sync.Mutex
+	size int
+	buf  []Tx
+
+	// floor is the last position not in the buffer, either
+	// dropped from it or applied before the hub started, and
+	// floorLocal if local work at it was dropped
+	floor      pglogrepl.LSN
+	floorLocal bool
+
+	subs map[*Subscription]struct{}
+}
+
+// NewHub buffers the last size transactions.
+func NewHub(size int) *Hub {
+	return &Hub{
+		size: size,
+		subs: map[*Subscription]struct{}{},
+	}
+}
+
+// Start marks the transactions up to pos as applied before
+// the hub saw them, so subscribers can't resume from before it.
+func (h *Hub) Start(pos pglogrepl.LSN) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.buf) == 0 && pos > h.floor {
+		h.floor, h.floorLocal = pos, false
+	}
+}
+
+// Latest is the position of the last transaction published.
+func (h *Hub) Latest() pglogrepl.LSN {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.buf) == 0 {
+		return h.floor
+	}
+
+	return h.buf[len(h.buf)-1].LSN
+}
+
+// Publish sends the committed transactions to the subscribers.
+func (h *Hub) Publish(txs ...Tx) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, tx := range txs {
+		h.buf = append(h.buf, tx)
+
+		if len(h.buf) > h.size {
+			h.floor, h.floorLocal = h.buf[0].LSN, h.buf[0].Local
+			h.buf = h.buf[1:]
+		}
+
+		for s := range h.subs {
+			if !s.push(tx) {
+				delete(h.subs, s)
+			}
+		}
+	}
+}
+
+// Subscribe returns the transactions committed after from, and the
+// local work at from, starting with the buffered ones.
+func (h *Hub) Subscribe(from pglogrepl.LSN) (*Subscription, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if from < h.floor || from == h.floor && h.floorLocal {
+		return nil, ErrTooOld
+	}
+
+	s := &Subscription{
+		hub:    h,
+		max:    h.size + subscriberQueue,
+		notify: make(chan struct{}, 1),
+	}
+
+	for _, tx := range h.buf {
+		if tx.LSN > from || tx.LSN == from && tx.Local {
+			s.queue = append(s.queue, tx)
+		}
+	}
+
+	h.subs[s] = struct{}{}
+
+	return s, nil
+}
+
+func (h *Hub) unsubscribe(s *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.subs, s)
+}
+
+// subscriberQueue is how far beyond the buffer a
+// subscriber can fall behind before it's dropped.
+const subscriberQueue = 1000
+
+type Subscription struct {
+	hub *Hub
+	max int
+
+	mu     sync.Mutex
+	queue  []Tx
+	err    error
+	notify chan struct{}
+}
+
+// push queues the transaction, it returns
+// false if the subscriber has been dropped.
+func (s *Subscription) push(tx Tx) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return false
+	}
+
+	if len(s.queue) >= s.max {
+		s.err, s.queue = ErrSlow, nil
+	} else {
+		s.queue = append(s.queue, tx)
+	}
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+
+	return s.err == nil
+}
+
+// Next waits for the next transaction.
+func (s *Subscription) Next(ctx context.Context) (Tx, error) {
+	for {
+		s.mu.Lock()
+
+		if len(s.queue) > 0 {
+			tx := s.queue[0]
+			s.queue = s.queue[1:]
+			s.mu.Unlock()
+
+			return tx, nil
+		}
+
+		err := s.err
+		s.mu.Unlock()
+
+		if err != nil {
+			return Tx{}, err
+		}
+
+		select {
+		case <-s.notify:
+		case <-ctx.Done():
+			return Tx{}, ctx.Err()
+		}
+	}
+}
+
+// Close stops the subscription.
+func (s *Subscription) Close() {
+	s.hub.unsubscribe(s)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err == nil {
+		s.err, s.queue = ErrClosed, nil
+	}
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
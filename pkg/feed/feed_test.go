@@ -0,0 +1,145 @@
+package feed_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zknill/sqledge/pkg/feed"
+)
+
+func tx(lsn pglogrepl.LSN) feed.Tx {
+	return feed.Tx{LSN: lsn, Statements: []string{"INSERT INTO t VALUES (1);"}}
+}
+
+func TestSubscribe(t *testing.T) {
+	hub := feed.NewHub(2)
+	hub.Start(10)
+
+	_, err := hub.Subscribe(9)
+	assert.ErrorIs(t, err, feed.ErrTooOld)
+
+	hub.Publish(tx(11), tx(12))
+
+	sub, err := hub.Subscribe(11)
+	require.NoError(t, err)
+	defer sub.Close()
+
+	hub.Publish(tx(13))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	for _, want := range []pglogrepl.LSN{12, 13} {
+		got, err := sub.Next(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, want, got.LSN)
+	}
+
+	// 11 has been dropped from the buffer
+	_, err = hub.Subscribe(10)
+	assert.ErrorIs(t, err, feed.ErrTooOld)
+
+	_, err = hub.Subscribe(11)
+	assert.NoError(t, err)
+}
+
+func TestSubscribeLocal(t *testing.T) {
+	hub := feed.NewHub(2)
+	hub.Start(10)
+
+	local := feed.Tx{LSN: 11, Statements: []string{"DELETE FROM postgres_sequences;"}, Local: true}
+	hub.Publish(tx(11), local)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// the local work at 11 is sent again to subscribers resuming from it
+	sub, err := hub.Subscribe(11)
+	require.NoError(t, err)
+	defer sub.Close()
+
+	got, err := sub.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, local, got)
+
+	// the transaction at 11 has been dropped from the buffer
+	hub.Publish(tx(12))
+
+	_, err = hub.Subscribe(11)
+	assert.NoError(t, err)
+
+	// and then the local work at 11
+	hub.Publish(tx(13))
+
+	_, err = hub.Subscribe(11)
+	assert.ErrorIs(t, err, feed.ErrTooOld)
+
+	_, err = hub.Subscribe(12)
+	assert.NoError(t, err)
+}
+
+func TestSubscriptionSlow(t *testing.T) {
+	hub := feed.NewHub(0)
+
+	sub, err := hub.Subscribe(0)
+	require.NoError(t, err)
+
+	for i := 1; i <= 1001; i++ {
+		hub.Publish(tx(pglogrepl.LSN(i)))
+	}
+
+	_, err = sub.Next(context.Background())
+	assert.ErrorIs(t, err, feed.ErrSlow)
+}
+
+func TestSubscriptionClose(t *testing.T) {
+	hub := feed.NewHub(10)
+
+	sub, err := hub.Subscribe(0)
+	require.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		_, err := sub.Next(context.Background())
+		done <- err
+	}()
+
+	sub.Close()
+	assert.ErrorIs(t, <-done, feed.ErrClosed)
+}
+
+func TestTxJSON(t *testing.T) {
+	in := feed.Tx{
+		LSN:        pglogrepl.LSN(0x16B6C50),
+		CommitTime: time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC),
+		Statements: []string{"DELETE FROM t WHERE id = 1;"},
+		Changes: []feed.Change{{
+			Table: "t",
+			Op:    feed.OpDelete,
+			Old:   map[string]any{"id": json.Number("9007199254740993")},
+		}},
+	}
+
+	local := feed.Tx{LSN: 0x16B6C50, Statements: []string{"DELETE FROM postgres_sequences;"}, Local: true}
+
+	b, err := json.Marshal(in)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `"lsn":"0/16B6C50"`)
+
+	var out feed.Tx
+	require.NoError(t, json.Unmarshal(b, &out))
+	assert.Equal(t, in, out)
+	assert.NotContains(t, string(b), `"local"`)
+
+	b, err = json.Marshal(local)
+	require.NoError(t, err)
+
+	out = feed.Tx{}
+	require.NoError(t, json.Unmarshal(b, &out))
+	assert.Equal(t, local, out)
+}
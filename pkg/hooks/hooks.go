@@ -0,0 +1,361 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/zknill/sqledge/pkg/config"
+	"github.com/zknill/sqledge/pkg/feed"
+	"github.com/zknill/sqledge/pkg/local"
+	"github.com/zknill/sqledge/pkg/metrics"
+	"github.com/zknill/sqledge/pkg/sqlgen"
+)
+
+// pollInterval is how often the outbox is checked for deliveries.
+const pollInterval = time.Second
+
+// Event is the JSON POSTed to a hook for a change.
+type Event struct {
+	LSN        string    `json:"lsn"`
+	CommitTime time.Time `json:"commit_time"`
+
+	feed.Change
+}
+
+// Hooks queues the changes to send to the hooks in the outbox.
+type Hooks struct {
+	hooks []config.Hook
+}
+
+// New returns nil if there are no hooks.
+func New(cfg *config.Config) (*Hooks, error) {
+	if len(cfg.Hooks.Targets) == 0 {
+		return nil, nil
+	}
+
+	h := &Hooks{}
+
+	for _, t := range cfg.Hooks.Targets {
+		hook, err := config.ParseHook(t)
+		if err != nil {
+			return nil, err
+		}
+
+		h.hooks = append(h.hooks, hook)
+	}
+
+	return h, nil
+}
+
+// InitOutbox creates the outbox table the deliveries are queued in. The
+// ids are AUTOINCREMENT so they're never reused, as hooks use them to
+// spot changes delivered twice.
+func InitOutbox(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS postgres_outbox (
+		id integer PRIMARY KEY AUTOINCREMENT,
+		url text NOT NULL,
+		payload text NOT NULL,
+		attempts integer NOT NULL DEFAULT 0,
+		next_attempt integer NOT NULL DEFAULT 0,
+		last_error text
+	)`)
+	if err != nil {
+		return fmt.Errorf("create outbox table: %w", err)
+	}
+
+	return nil
+}
+
+// Outbox returns the statements that queue the transaction's changes
+// for the hooks they match. They're run in the SQLite transaction that
+// applies the changes, so a change is queued if and only if it's applied.
+func (h *Hooks) Outbox(tx feed.Tx) (string, error) {
+	if h == nil {
+		return "", nil
+	}
+
+	buf := &strings.Builder{}
+
+	for _, change := range tx.Changes {
+		var payload []byte
+
+		for _, hook := range h.hooks {
+			if !hook.Matches(change.Table, change.Op) {
+				continue
+			}
+
+			if payload == nil {
+				var err error
+
+				payload, err = json.Marshal(Event{LSN: tx.LSN.String(), CommitTime: tx.CommitTime, Change: change})
+				if err != nil {
+					return "", fmt.Errorf("encode %s change to %s: %w", change.Op, change.Table, err)
+				}
+			}
+
+			buf.WriteString(sqlgen.Stmt{
+				SQL:  "INSERT INTO postgres_outbox (url, payload) VALUES (?, ?);",
+				Args: []any{hook.URL, string(payload)},
+			}.String())
+			buf.WriteString(" ")
+		}
+	}
+
+	return buf.String(), nil
+}
+
+// Run delivers the changes queued in the outbox until the context is
+// done. Each change is POSTed until the hook returns a 2xx, so it's
+// delivered at least once, and the changes to each URL are delivered
+// in the order they were applied.
+func Run(ctx context.Context, cfg *config.Config) error {
+	// the replicator creates the local database, after
+	// checking if there's one to bootstrap or download
+	for {
+		if _, err := os.Stat(cfg.Local.Path); err == nil {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+
+	db, err := local.OpenWriter(local.NewConfig(cfg))
+	if err != nil {
+		return fmt.Errorf("connect to local db: %w", err)
+	}
+	defer db.Close()
+
+	if err := InitOutbox(db); err != nil {
+		return err
+	}
+
+	d := &deliverer{
+		cfg:    cfg,
+		db:     db,
+		client: &http.Client{Timeout: cfg.Hooks.Timeout},
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := d.deliver(ctx); err != nil && ctx.Err() == nil {
+			log.Error().Err(err).Msg("deliver hooks")
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+type deliverer struct {
+	cfg    *config.Config
+	db     *sql.DB
+	client *http.Client
+}
+
+type delivery struct {
+	id          int64
+	url         string
+	payload     string
+	attempts    int
+	nextAttempt int64
+}
+
+// deliver sends the due changes to each URL, oldest first,
+// stopping at the first that fails or isn't due yet.
+func (d *deliverer) deliver(ctx context.Context) error {
+	var pending int
+
+	if err := d.db.QueryRowContext(ctx, "SELECT count(*) FROM postgres_outbox").Scan(&pending); err != nil {
+		return fmt.Errorf("count outbox: %w", err)
+	}
+
+	metrics.HookOutbox.Set(float64(pending))
+
+	if pending == 0 {
+		return nil
+	}
+
+	urls, err := d.urls(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, url := range urls {
+		for ctx.Err() == nil {
+			next, err := d.next(ctx, url)
+			if err != nil {
+				return err
+			}
+
+			if next == nil || next.nextAttempt > time.Now().UnixMilli() {
+				break
+			}
+
+			ok, err := d.send(ctx, next)
+			if err != nil {
+				return err
+			}
+
+			if !ok {
+				break
+			}
+
+			metrics.HookOutbox.Dec()
+		}
+	}
+
+	return ctx.Err()
+}
+
+func (d *deliverer) urls(ctx context.Context) ([]string, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT DISTINCT url FROM postgres_outbox")
+	if err != nil {
+		return nil, fmt.Errorf("read outbox: %w", err)
+	}
+	defer rows.Close()
+
+	var urls []string
+
+	for rows.Next() {
+		var url string
+		if err := rows.Scan(&url); err != nil {
+			return nil, fmt.Errorf("read outbox: %w", err)
+		}
+
+		urls = append(urls, url)
+	}
+
+	return urls, rows.Err()
+}
+
+func (d *deliverer) next(ctx context.Context, url string) (*delivery, error) {
+	row := d.db.QueryRowContext(ctx, `SELECT id, url, payload, attempts, next_attempt
+		FROM postgres_outbox
+		WHERE url = ?
+		ORDER BY id
+		LIMIT 1`, url)
+
+	del := &delivery{}
+
+	err := row.Scan(&del.id, &del.url, &del.payload, &del.attempts, &del.nextAttempt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("read outbox: %w", err)
+	}
+
+	return del, nil
+}
+
+// send POSTs the change, and removes it from the outbox if it's
+// delivered, or schedules the next attempt if it isn't. It reports
+// if the next change to the URL can be sent.
+func (d *deliverer) send(ctx context.Context, del *delivery) (bool, error) {
+	start := time.Now()
+	sendErr := d.post(ctx, del)
+
+	metrics.HookDeliveryDuration.WithLabelValues(del.url).Observe(time.Since(start).Seconds())
+
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+
+	attempts := del.attempts + 1
+
+	if sendErr == nil {
+		metrics.HookDeliveries.WithLabelValues(del.url, "success").Inc()
+		return true, d.remove(ctx, del)
+	}
+
+	metrics.HookDeliveries.WithLabelValues(del.url, "failure").Inc()
+
+	if limit := d.cfg.Hooks.MaxAttempts; limit > 0 && attempts >= limit {
+		log.Error().Err(sendErr).Msgf("dropping change %d to %s after %d attempts", del.id, del.url, attempts)
+		metrics.HookDeliveries.WithLabelValues(del.url, "dropped").Inc()
+
+		return true, d.remove(ctx, del)
+	}
+
+	wait := backoff(d.cfg.Hooks.Backoff, d.cfg.Hooks.MaxBackoff, attempts)
+
+	log.Warn().Err(sendErr).Msgf("deliver change %d to %s, attempt %d, retrying in %s", del.id, del.url, attempts, wait)
+
+	_, err := d.db.ExecContext(ctx, `UPDATE postgres_outbox
+		SET attempts = ?, next_attempt = ?, last_error = ?
+		WHERE id = ?`, attempts, time.Now().Add(wait).UnixMilli(), sendErr.Error(), del.id)
+	if err != nil {
+		return false, fmt.Errorf("update outbox: %w", err)
+	}
+
+	return false, nil
+}
+
+func (d *deliverer) remove(ctx context.Context, del *delivery) error {
+	if _, err := d.db.ExecContext(ctx, "DELETE FROM postgres_outbox WHERE id = ?", del.id); err != nil {
+		return fmt.Errorf("remove from outbox: %w", err)
+	}
+
+	return nil
+}
+
+// post sends the change, the delivery id lets the
+// hook ignore a change delivered more than once.
+func (d *deliverer) post(ctx context.Context, del *delivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, del.url, bytes.NewBufferString(del.payload))
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Sqledge-Delivery", strconv.FormatInt(del.id, 10))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("hook returned %s", resp.Status)
+	}
+
+	return nil
+}
+
+// backoff doubles the wait for each failed attempt, up to limit.
+func backoff(base, limit time.Duration, attempts int) time.Duration {
+	wait := base
+
+	for i := 1; i < attempts && (limit <= 0 || wait < limit); i++ {
+		wait *= 2
+	}
+
+	if limit > 0 && wait > limit {
+		return limit
+	}
+
+	return wait
+}
@@ -0,0 +1,142 @@
+package hooks_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zknill/sqledge/pkg/config"
+	"github.com/zknill/sqledge/pkg/feed"
+	"github.com/zknill/sqledge/pkg/hooks"
+	"github.com/zknill/sqledge/pkg/local"
+	"github.com/zknill/sqledge/pkg/sqlgen"
+)
+
+func TestDeliver(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		calls    int
+		received []hooks.Event
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		calls++
+
+		// the first attempt fails, and is retried
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		assert.NotEmpty(t, r.Header.Get("Sqledge-Delivery"))
+
+		b, _ := io.ReadAll(r.Body)
+
+		var ev hooks.Event
+		assert.NoError(t, json.Unmarshal(b, &ev))
+
+		received = append(received, ev)
+	}))
+	defer srv.Close()
+
+	t.Setenv("SQLEDGE_LOCAL_DB_PATH", filepath.Join(t.TempDir(), "edge.db"))
+	t.Setenv("SQLEDGE_HOOKS_TARGETS", "orders:insert,delete="+srv.URL)
+	t.Setenv("SQLEDGE_HOOKS_BACKOFF", "10ms")
+
+	cfg, err := config.Load()
+	require.NoError(t, err)
+
+	db, err := local.OpenWriter(local.NewConfig(cfg))
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, hooks.InitOutbox(db))
+
+	h, err := hooks.New(cfg)
+	require.NoError(t, err)
+
+	outbox, err := h.Outbox(feed.Tx{
+		LSN: 10,
+		Changes: []feed.Change{
+			{Table: "orders", Op: feed.OpInsert, New: map[string]any{"note": "it's"}},
+			{Table: "orders", Op: feed.OpUpdate, New: map[string]any{"id": 1}},
+			{Table: "users", Op: feed.OpInsert, New: map[string]any{"id": 1}},
+			{Table: "orders", Op: feed.OpDelete, Old: map[string]any{"id": 1}},
+		},
+	})
+	require.NoError(t, err)
+
+	_, err = db.Exec(outbox)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	go func() { done <- hooks.Run(ctx, cfg) }()
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return len(received) == 2
+	}, 10*time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	require.Len(t, received, 2)
+	assert.Equal(t, 3, calls)
+
+	assert.Equal(t, "0/A", received[0].LSN)
+	assert.Equal(t, feed.OpInsert, received[0].Op)
+	assert.Equal(t, "it's", received[0].New["note"])
+	assert.Equal(t, feed.OpDelete, received[1].Op)
+
+	var left int
+	require.NoError(t, db.QueryRow("SELECT count(*) FROM postgres_outbox").Scan(&left))
+	assert.Zero(t, left)
+}
+
+func TestInitOutbox(t *testing.T) {
+	t.Setenv("SQLEDGE_LOCAL_DB_PATH", filepath.Join(t.TempDir(), "edge.db"))
+
+	cfg, err := config.Load()
+	require.NoError(t, err)
+
+	db, err := local.OpenWriter(local.NewConfig(cfg))
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, hooks.InitOutbox(db))
+
+	// the replicator reads the local schema after creating the outbox
+	schema, err := sqlgen.NewSqliteDriver(sqlgen.SqliteConfig{}, db).CurrentSchema()
+	require.NoError(t, err)
+	assert.Empty(t, schema)
+
+	// delivery ids aren't reused once the latest is delivered
+	_, err = db.Exec("INSERT INTO postgres_outbox (url, payload) VALUES ('http://hook', '{}')")
+	require.NoError(t, err)
+	_, err = db.Exec("DELETE FROM postgres_outbox")
+	require.NoError(t, err)
+	_, err = db.Exec("INSERT INTO postgres_outbox (url, payload) VALUES ('http://hook', '{}')")
+	require.NoError(t, err)
+
+	var id int
+	require.NoError(t, db.QueryRow("SELECT id FROM postgres_outbox").Scan(&id))
+	assert.Equal(t, 2, id)
+}
@@ -0,0 +1,167 @@
+package local
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/rs/zerolog/log"
+	"github.com/zknill/sqledge/pkg/config"
+)
+
+type Config struct {
+	Path string
+
+	// pragmas
+	Synchronous string
+	CacheSize   int
+	MmapSize    int64
+	BusyTimeout time.Duration
+
+	ReadConns          int
+	CheckpointInterval time.Duration
+}
+
+func NewConfig(cfg *config.Config) Config {
+	return Config{
+		Path:               cfg.Local.Path,
+		Synchronous:        cfg.Local.Synchronous,
+		CacheSize:          cfg.Local.CacheSize,
+		MmapSize:           cfg.Local.MmapSize,
+		BusyTimeout:        cfg.Local.BusyTimeout,
+		ReadConns:          cfg.Local.ReadConns,
+		CheckpointInterval: cfg.Local.CheckpointInterval,
+	}
+}
+
+// OpenWriter opens the single connection used to apply changes.
+// Transactions are opened and closed with separate statements, so
+// all statements have to use the same connection. The database runs
+// in WAL mode, so the proxy's reads aren't blocked by the writer.
+func OpenWriter(cfg Config) (*sql.DB, error) {
+	db := open(cfg, "PRAGMA journal_mode = WAL;")
+	db.SetMaxOpenConns(1)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("open writer: %w", err)
+	}
+
+	return db, nil
+}
+
+// OpenReader opens the pool of read only connections.
+func OpenReader(cfg Config) (*sql.DB, error) {
+	db := open(cfg, "PRAGMA query_only = true;")
+
+	if cfg.ReadConns > 0 {
+		db.SetMaxOpenConns(cfg.ReadConns)
+		db.SetMaxIdleConns(cfg.ReadConns)
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("open reader: %w", err)
+	}
+
+	return db, nil
+}
+
+func open(cfg Config, pragmas ...string) *sql.DB {
+	pragmas = append(pragmas,
+		fmt.Sprintf("PRAGMA busy_timeout = %d;", cfg.BusyTimeout.Milliseconds()),
+	)
+
+	if cfg.Synchronous != "" {
+		pragmas = append(pragmas, fmt.Sprintf("PRAGMA synchronous = %s;", cfg.Synchronous))
+	}
+
+	if cfg.CacheSize != 0 {
+		pragmas = append(pragmas, fmt.Sprintf("PRAGMA cache_size = %d;", cfg.CacheSize))
+	}
+
+	if cfg.MmapSize != 0 {
+		pragmas = append(pragmas, fmt.Sprintf("PRAGMA mmap_size = %d;", cfg.MmapSize))
+	}
+
+	return sql.OpenDB(&connector{
+		dsn: cfg.Path,
+		driver: &sqlite3.SQLiteDriver{
+			ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+				for _, p := range pragmas {
+					if _, err := conn.Exec(p, nil); err != nil {
+						return fmt.Errorf("%s: %w", p, err)
+					}
+				}
+
+				return nil
+			},
+		},
+	})
+}
+
+// connector runs the pragmas on each new connection.
+type connector struct {
+	dsn    string
+	driver *sqlite3.SQLiteDriver
+}
+
+func (c *connector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.driver
+}
+
+// Checkpoint periodically copies the WAL back into the database file,
+// so that the WAL doesn't grow without bound while readers are active.
+// It uses its own connection, and blocks until the context is done.
+func Checkpoint(ctx context.Context, cfg Config) error {
+	if cfg.CheckpointInterval <= 0 {
+		return nil
+	}
+
+	db := open(cfg)
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+
+	ticker := time.NewTicker(cfg.CheckpointInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		var busy, logFrames, checkpointed int
+
+		row := db.QueryRowContext(ctx, "PRAGMA wal_checkpoint(PASSIVE);")
+		if err := row.Scan(&busy, &logFrames, &checkpointed); err != nil {
+			log.Warn().Err(err).Msg("wal checkpoint")
+			continue
+		}
+
+		log.Trace().Msgf("wal checkpoint: busy %d, log %d, checkpointed %d", busy, logFrames, checkpointed)
+	}
+}
+
+// Copy writes a consistent copy of the database to dst with VACUUM
+// INTO, from a read snapshot, so the writer can keep applying changes.
+// dst must not exist.
+func Copy(ctx context.Context, cfg Config, dst string) error {
+	db := open(cfg)
+	defer db.Close()
+
+	if _, err := db.ExecContext(ctx, "VACUUM INTO ?;", dst); err != nil {
+		return fmt.Errorf("vacuum into %s: %w", dst, err)
+	}
+
+	return nil
+}
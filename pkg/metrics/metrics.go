@@ -0,0 +1,167 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "sqledge"
+
+// Replication
+var (
+	MessagesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "replication",
+		Name:      "messages_received_total",
+		Help:      "Logical replication messages received, by message type.",
+	}, []string{"type"})
+
+	Rows = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "replication",
+		Name:      "rows_total",
+		Help:      "Rows changed by replication, by table and operation.",
+	}, []string{"table", "op"})
+
+	ApplyDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "replication",
+		Name:      "apply_duration_seconds",
+		Help:      "Time to apply an upstream transaction, from its begin to its commit.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 4, 10),
+	})
+
+	LagSeconds = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "replication",
+		Name:      "lag_seconds",
+		Help:      "Time between the upstream commit and the apply of the last transaction.",
+	})
+
+	LagBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "replication",
+		Name:      "lag_bytes",
+		Help:      "WAL between the upstream's reported WAL end and the last transaction applied.",
+	})
+
+	CopyTables = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "replication",
+		Name:      "copy_tables",
+		Help:      "Tables to copy in the initial copy.",
+	})
+
+	CopyTablesDone = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "replication",
+		Name:      "copy_tables_done",
+		Help:      "Tables copied so far in the initial copy.",
+	})
+
+	CopyRows = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "replication",
+		Name:      "copy_rows_total",
+		Help:      "Rows copied in the initial copy and resyncs, by table.",
+	}, []string{"table"})
+
+	Reconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "replication",
+		Name:      "reconnects_total",
+		Help:      "Times the replication connection was reopened after the first connect.",
+	})
+
+	VerifyRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "replication",
+		Name:      "verify_runs_total",
+		Help:      "Verifies of the local database against the upstream, by result: match, mismatch or error.",
+	}, []string{"result"})
+
+	LogicalMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "replication",
+		Name:      "logical_messages_total",
+		Help:      "Messages emitted upstream with pg_logical_emit_message, by prefix and result: handled, error or unhandled.",
+	}, []string{"prefix", "result"})
+
+	VerifyRows = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "replication",
+		Name:      "verify_rows",
+		Help:      "Rows found missing, extra or differing by the last verify, by table and kind.",
+	}, []string{"table", "kind"})
+)
+
+// Proxy
+var (
+	ProxyConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "proxy",
+		Name:      "connections",
+		Help:      "Open client connections.",
+	})
+
+	ProxyConnectionsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "proxy",
+		Name:      "connections_total",
+		Help:      "Client connections accepted.",
+	})
+
+	Queries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "proxy",
+		Name:      "queries_total",
+		Help:      "Queries handled, by route.",
+	}, []string{"route"})
+
+	QueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "proxy",
+		Name:      "query_duration_seconds",
+		Help:      "Time to run a query and write its response, by route.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+	}, []string{"route"})
+
+	QueryErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "proxy",
+		Name:      "query_errors_total",
+		Help:      "Queries that failed, by route and SQLSTATE.",
+	}, []string{"route", "sqlstate"})
+)
+
+// Hooks
+var (
+	HookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "hooks",
+		Name:      "deliveries_total",
+		Help:      "Hook delivery attempts, by url and result: success, failure or dropped.",
+	}, []string{"url", "result"})
+
+	HookDeliveryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "hooks",
+		Name:      "delivery_duration_seconds",
+		Help:      "Time to POST a change to a hook, by url.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{"url"})
+
+	HookOutbox = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "hooks",
+		Name:      "outbox",
+		Help:      "Changes waiting in the outbox to be delivered.",
+	})
+)
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
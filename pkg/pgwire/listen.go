@@ -0,0 +1,376 @@
+package pgwire
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/rs/zerolog/log"
+)
+
+// listenTimeout is how long LISTEN waits for
+// the upstream to start listening on the channel.
+const listenTimeout = 10 * time.Second
+
+// Listener shares a single upstream connection between the clients
+// LISTENing through the proxy, and fans the notifications out to them.
+type Listener struct {
+	connString string
+
+	mu sync.Mutex
+
+	// subs are the clients listening on each channel, and
+	// listening the channels the upstream is listening on
+	subs      map[string]map[chan<- *pgproto3.NotificationResponse]struct{}
+	listening map[string]bool
+
+	// synced is closed, and replaced, each time the
+	// upstream's channels are brought up to date
+	synced chan struct{}
+	wake   chan struct{}
+}
+
+func NewListener(connString string) *Listener {
+	return &Listener{
+		connString: connString,
+		subs:       map[string]map[chan<- *pgproto3.NotificationResponse]struct{}{},
+		listening:  map[string]bool{},
+		synced:     make(chan struct{}),
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+// Run keeps the upstream connection listening on the channels the
+// clients are listening on, reconnecting if it's lost, until the
+// context is done.
+func (l *Listener) Run(ctx context.Context) error {
+	backoff := time.Second
+
+	for {
+		err := l.listen(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		log.Warn().Err(err).Msgf("upstream listener, reconnecting in %s", backoff)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+func (l *Listener) listen(ctx context.Context) error {
+	// only connect once a client is listening
+	for !l.wanted() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-l.wake:
+		}
+	}
+
+	cfg, err := pgconn.ParseConfig(l.connString)
+	if err != nil {
+		return fmt.Errorf("parse upstream config: %w", err)
+	}
+
+	cfg.OnNotification = func(_ *pgconn.PgConn, n *pgconn.Notification) {
+		l.Notify(n.PID, n.Channel, n.Payload)
+	}
+
+	conn, err := pgconn.ConnectConfig(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("connect upstream: %w", err)
+	}
+	defer conn.Close(context.Background())
+
+	// a new connection isn't listening on anything
+	l.mu.Lock()
+	l.listening = map[string]bool{}
+	l.mu.Unlock()
+
+	for {
+		if err := l.sync(ctx, conn); err != nil {
+			return err
+		}
+
+		waitCtx, cancel := context.WithCancel(ctx)
+
+		go func() {
+			select {
+			case <-l.wake:
+				cancel()
+			case <-waitCtx.Done():
+			}
+		}()
+
+		err := conn.WaitForNotification(waitCtx)
+		cancel()
+
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case err != nil && !errors.Is(err, context.Canceled):
+			return fmt.Errorf("wait for notification: %w", err)
+		}
+	}
+}
+
+// sync listens on the channels that have clients, and stops
+// listening on the channels that don't.
+func (l *Listener) sync(ctx context.Context, conn *pgconn.PgConn) error {
+	l.mu.Lock()
+
+	var listen, unlisten []string
+
+	for channel := range l.subs {
+		if !l.listening[channel] {
+			listen = append(listen, channel)
+		}
+	}
+
+	for channel := range l.listening {
+		if _, ok := l.subs[channel]; !ok {
+			unlisten = append(unlisten, channel)
+		}
+	}
+
+	l.mu.Unlock()
+
+	for _, channel := range listen {
+		if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()).ReadAll(); err != nil {
+			return fmt.Errorf("listen %q: %w", channel, err)
+		}
+	}
+
+	for _, channel := range unlisten {
+		if _, err := conn.Exec(ctx, "UNLISTEN "+pgx.Identifier{channel}.Sanitize()).ReadAll(); err != nil {
+			return fmt.Errorf("unlisten %q: %w", channel, err)
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, channel := range listen {
+		l.listening[channel] = true
+	}
+
+	for _, channel := range unlisten {
+		delete(l.listening, channel)
+	}
+
+	close(l.synced)
+	l.synced = make(chan struct{})
+
+	return nil
+}
+
+// Listen sends the notifications on the channel to c, once the
+// upstream is listening on it. Notifications are dropped if c is full.
+func (l *Listener) Listen(ctx context.Context, channel string, c chan<- *pgproto3.NotificationResponse) error {
+	l.mu.Lock()
+
+	if l.subs[channel] == nil {
+		l.subs[channel] = map[chan<- *pgproto3.NotificationResponse]struct{}{}
+	}
+
+	l.subs[channel][c] = struct{}{}
+
+	l.mu.Unlock()
+
+	l.poke()
+
+	ctx, cancel := context.WithTimeout(ctx, listenTimeout)
+	defer cancel()
+
+	for {
+		l.mu.Lock()
+		listening, synced := l.listening[channel], l.synced
+		l.mu.Unlock()
+
+		if listening {
+			return nil
+		}
+
+		select {
+		case <-synced:
+		case <-ctx.Done():
+			l.Unlisten(channel, c)
+			return fmt.Errorf("listen %q: upstream listener: %w", channel, ctx.Err())
+		}
+	}
+}
+
+// Unlisten stops sending the notifications on the channel to c.
+func (l *Listener) Unlisten(channel string, c chan<- *pgproto3.NotificationResponse) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.subs[channel], c)
+
+	if len(l.subs[channel]) == 0 {
+		delete(l.subs, channel)
+		l.poke()
+	}
+}
+
+// UnlistenAll stops sending any notifications to c.
+func (l *Listener) UnlistenAll(c chan<- *pgproto3.NotificationResponse) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for channel, subs := range l.subs {
+		delete(subs, c)
+
+		if len(subs) == 0 {
+			delete(l.subs, channel)
+			l.poke()
+		}
+	}
+}
+
+// Notify sends a notification to the clients listening on the channel.
+func (l *Listener) Notify(pid uint32, channel, payload string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := &pgproto3.NotificationResponse{PID: pid, Channel: channel, Payload: payload}
+
+	for c := range l.subs[channel] {
+		select {
+		case c <- n:
+		default:
+			log.Warn().Msgf("dropping notification on %q, client is too far behind", channel)
+		}
+	}
+}
+
+func (l *Listener) wanted() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return len(l.subs) > 0
+}
+
+// poke wakes the upstream connection to sync its channels.
+func (l *Listener) poke() {
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
+
+// notificationQueue is how many notifications a client
+// can fall behind by before they're dropped.
+const notificationQueue = 1000
+
+// lockedConn writes each message to the connection in one go, so
+// notifications aren't written in the middle of a query's response.
+type lockedConn struct {
+	net.Conn
+	mu sync.Mutex
+}
+
+func (c *lockedConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.Conn.Write(b)
+}
+
+func isListenQuery(query string) bool {
+	switch operation(query) {
+	case "listen", "unlisten", "notify":
+		return true
+	}
+
+	return false
+}
+
+// handleListen handles LISTEN and UNLISTEN with the shared listener,
+// and sends NOTIFY to the upstream. The raw query keeps its case, for
+// quoted channel names and payloads.
+func handleListen(ctx context.Context, upstream *sql.DB, listener *Listener, c chan *pgproto3.NotificationResponse, conn net.Conn, raw string) (string, error) {
+	op, rest, _ := strings.Cut(strings.TrimSpace(raw), " ")
+	op = strings.ToUpper(op)
+
+	if op == "NOTIFY" {
+		return routeUpstream, execUpstream(ctx, upstream, conn, raw, func(int64) string {
+			return "NOTIFY"
+		})
+	}
+
+	if listener == nil {
+		return routeUnsupported, &pgconn.PgError{
+			Code:    featureNotSupported,
+			Message: "LISTEN isn't enabled on this proxy",
+		}
+	}
+
+	channel, err := channelName(rest)
+	if err != nil {
+		return routeListen, err
+	}
+
+	switch {
+	case op == "LISTEN":
+		err = listener.Listen(ctx, channel, c)
+	case channel == "*":
+		listener.UnlistenAll(c)
+	default:
+		listener.Unlisten(channel, c)
+	}
+
+	if err != nil {
+		return routeListen, err
+	}
+
+	out := (&pgproto3.CommandComplete{CommandTag: []byte(op)}).Encode(nil)
+	out = (&pgproto3.ReadyForQuery{TxStatus: 'I'}).Encode(out)
+
+	if _, err := conn.Write(out); err != nil {
+		log.Error().Err(err).Msg("write response")
+	}
+
+	return routeListen, nil
+}
+
+// channelName parses the channel identifier, unquoted
+// names are folded to lower case like postgres does.
+func channelName(s string) (string, error) {
+	s = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), ";"))
+
+	if s == "*" {
+		return s, nil
+	}
+
+	if strings.HasPrefix(s, `"`) {
+		if len(s) < 3 || !strings.HasSuffix(s, `"`) {
+			return "", &pgconn.PgError{Code: syntaxError, Message: fmt.Sprintf("invalid channel name: %s", s)}
+		}
+
+		return strings.ReplaceAll(s[1:len(s)-1], `""`, `"`), nil
+	}
+
+	if s == "" || strings.ContainsAny(s, " \t\n;,'\"") {
+		return "", &pgconn.PgError{Code: syntaxError, Message: fmt.Sprintf("invalid channel name: %q", s)}
+	}
+
+	return strings.ToLower(s), nil
+}
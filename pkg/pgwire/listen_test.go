@@ -0,0 +1,58 @@
+package pgwire
+
+import (
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannelName(t *testing.T) {
+	tests := map[string]string{
+		"Orders":         "orders",
+		"orders;":        "orders",
+		`"Orders"`:       "Orders",
+		`"say ""hi"""`:   `say "hi"`,
+		"*":              "*",
+		" new_orders ; ": "new_orders",
+	}
+
+	for in, want := range tests {
+		got, err := channelName(in)
+		assert.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+
+	for _, in := range []string{"", "a b", `"`, `""`, "a;b"} {
+		_, err := channelName(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestListenerNotify(t *testing.T) {
+	l := NewListener("")
+
+	a := make(chan *pgproto3.NotificationResponse, 1)
+	b := make(chan *pgproto3.NotificationResponse, 1)
+
+	l.subs["orders"] = map[chan<- *pgproto3.NotificationResponse]struct{}{a: {}, b: {}}
+	l.subs["users"] = map[chan<- *pgproto3.NotificationResponse]struct{}{a: {}}
+
+	l.Notify(1, "orders", "1")
+	assert.Equal(t, "1", (<-a).Payload)
+	assert.Equal(t, "1", (<-b).Payload)
+
+	l.Unlisten("orders", b)
+	l.Notify(1, "orders", "2")
+	assert.Equal(t, "2", (<-a).Payload)
+	assert.Empty(t, b)
+
+	// a full client drops the notification
+	l.Notify(1, "orders", "3")
+	l.Notify(1, "orders", "4")
+	assert.Equal(t, "3", (<-a).Payload)
+
+	l.UnlistenAll(a)
+	assert.Empty(t, l.subs)
+	assert.False(t, l.wanted())
+}
@@ -1,17 +1,27 @@
 package pgwire
 
 import (
+	"context"
 	"database/sql"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"regexp"
 	"strings"
+	"time"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/mattn/go-sqlite3"
 	"github.com/rs/zerolog/log"
+	"github.com/zknill/sqledge/pkg/metrics"
 	"github.com/zknill/sqledge/pkg/sqlgen"
+	"github.com/zknill/sqledge/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // magic numbers come from here:
@@ -29,11 +39,66 @@ const (
 
 var withStatement = regexp.MustCompile(`with .* as (.*) select`)
 
-func Handle(schema string, upstream, local *sql.DB, conn net.Conn) {
-	if err := onStart(conn); err != nil {
+// SQLSTATE codes
+const (
+	featureNotSupported = "0A000"
+	syntaxError         = "42601"
+	undefinedTable      = "42P01"
+	internalError       = "XX000"
+)
+
+// Query routes
+const (
+	routeLocal       = "local"
+	routeUpstream    = "upstream"
+	routeFallback    = "fallback"
+	routeUnsupported = "unsupported"
+	routeListen      = "listen"
+)
+
+var tracer = tracing.Tracer("pgwire")
+
+// Handle serves a client connection. The listener is shared between the
+// connections for LISTEN, without it LISTEN isn't supported, and the
+// sequences are shared for nextval.
+func Handle(schema string, upstream, local *sql.DB, listener *Listener, sequences *Sequences, conn net.Conn) {
+	params, err := onStart(conn)
+	if err != nil {
 		log.Error().Err(err).Msg("on start error")
 	}
 
+	// notifications are written between the responses
+	// to queries, which are each written in one go
+	conn = &lockedConn{Conn: conn}
+
+	notifications := make(chan *pgproto3.NotificationResponse, notificationQueue)
+	done := make(chan struct{})
+
+	defer close(done)
+
+	if listener != nil {
+		defer listener.UnlistenAll(notifications)
+	}
+
+	go func() {
+		for {
+			select {
+			case n := <-notifications:
+				if _, err := conn.Write(n.Encode(nil)); err != nil {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	seqs := newSequenceSession(sequences)
+
+	// a traceparent startup parameter is the parent
+	// of all the queries on the connection
+	connCtx := tracing.FromTraceparent(context.Background(), params["traceparent"])
+
 	log.Debug().Msg("completed startup")
 
 	for {
@@ -63,204 +128,240 @@ func Handle(schema string, upstream, local *sql.DB, conn net.Conn) {
 			return
 		}
 
-		query := strings.ToLower(string(body[:len(body)-1]))
-
-		switch {
-		case strings.HasPrefix(query, "select") || withStatement.MatchString(query):
-			log.Debug().Msgf("querying: %q", string(query))
+		raw := string(body[:len(body)-1])
+		query := strings.ToLower(raw)
 
-			rows, err := local.Query(query)
-			if err != nil {
-				log.Error().Err(err).Msg("local query")
-
-				errReadyForQuery(fmt.Errorf("failed to query local: %w", err), conn)
-
-				continue
-			}
-
-			desc := rowDesc(rows)
-			out := desc.Encode(nil)
-
-			data := rowData(rows)
-			for _, row := range data {
-				out = row.Encode(out)
-			}
+		start := time.Now()
 
-			log.Debug().Msgf("found %d rows", len(data))
+		ctx, span := tracer.Start(
+			tracing.FromComment(connCtx, query),
+			"pgwire.query",
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("db.system", "postgresql"),
+				attribute.String("db.operation", operation(query)),
+			),
+		)
 
-			cmd := &pgproto3.CommandComplete{CommandTag: []byte("")}
-			out = cmd.Encode(out)
+		var route string
 
-			ready := &pgproto3.ReadyForQuery{TxStatus: 'I'}
-			out = ready.Encode(out)
+		if isListenQuery(query) {
+			route, err = handleListen(ctx, upstream, listener, notifications, conn, raw)
+		} else {
+			route, err = handleQuery(ctx, upstream, local, seqs, conn, raw)
+		}
 
-			if _, err := conn.Write(out); err != nil {
-				log.Error().Err(err).Msg("write response")
+		metrics.Queries.WithLabelValues(route).Inc()
+		metrics.QueryDuration.WithLabelValues(route).Observe(time.Since(start).Seconds())
 
-				continue
-			}
-		case strings.HasPrefix(query, "update"):
-			r, err := upstream.Exec(query)
-			if err != nil {
-				errReadyForQuery(fmt.Errorf("failed to query upstream: %w", err), conn)
+		span.SetAttributes(attribute.String("sqledge.route", route))
 
-				continue
-			}
+		if err != nil {
+			metrics.QueryErrors.WithLabelValues(route, sqlState(err)).Inc()
+			span.SetAttributes(attribute.String("db.sqlstate", sqlState(err)))
+			errReadyForQuery(err, conn)
+		}
 
-			updates, err := r.RowsAffected()
-			cmd := pgproto3.CommandComplete{CommandTag: []byte("UPDATE " + fmt.Sprintf("%d", updates))}
+		endSpan(span, err)
+	}
+}
 
-			out := cmd.Encode(nil)
-			ready := &pgproto3.ReadyForQuery{TxStatus: 'I'}
-			out = ready.Encode(out)
+func operation(query string) string {
+	op, _, _ := strings.Cut(strings.TrimSpace(query), " ")
+	return op
+}
 
-			if _, err := conn.Write(out); err != nil {
-				log.Error().Err(err).Msg("write response")
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
 
-				continue
-			}
-		case strings.HasPrefix(query, "insert"):
-			r, err := upstream.Exec(query)
-			if err != nil {
-				errReadyForQuery(fmt.Errorf("failed to query upstream: %w", err), conn)
+	span.End()
+}
 
-				continue
-			}
+// handleQuery runs the query against the local or upstream db, and
+// writes the response. Errors are returned before the response is
+// written, so the caller can write the error response. Queries
+// are sent upstream as they were received, not lower cased.
+func handleQuery(ctx context.Context, upstream, local *sql.DB, seqs *sequenceSession, conn net.Conn, raw string) (string, error) {
+	query := strings.ToLower(raw)
+	isRead := strings.HasPrefix(query, "select") || withStatement.MatchString(query)
+
+	switch {
+	case isRead && setsSequence(query):
+		// the sequences are synced from the upstream,
+		// so setval has to change them there
+		if err := queryRows(ctx, "upstream.query", upstream, conn, raw); err != nil {
+			return routeUpstream, fmt.Errorf("failed to query upstream: %w", err)
+		}
 
-			updates, err := r.RowsAffected()
-			cmd := pgproto3.CommandComplete{CommandTag: []byte("INSERT 0 " + fmt.Sprintf("%d", updates))}
+		return routeUpstream, nil
+	case isRead:
+		log.Debug().Msgf("querying: %q", string(query))
 
-			out := cmd.Encode(nil)
-			ready := &pgproto3.ReadyForQuery{TxStatus: 'I'}
-			out = ready.Encode(out)
+		rewritten, err := seqs.rewrite(ctx, query)
+		if err != nil {
+			return routeLocal, err
+		}
 
-			if _, err := conn.Write(out); err != nil {
-				log.Error().Err(err).Msg("write response")
+		err = queryRows(ctx, "sqlite.query", local, conn, rewritten)
+		if err == nil {
+			return routeLocal, nil
+		}
 
-				continue
-			}
-		case strings.HasPrefix(query, "delete"):
-			r, err := upstream.Exec(query)
-			if err != nil {
-				errReadyForQuery(fmt.Errorf("failed to query upstream: %w", err), conn)
+		// only a query sqlite can't run is sent upstream, a fault in
+		// the local db is returned, so it isn't hidden by the upstream
+		if !dialectError(err) {
+			return routeLocal, fmt.Errorf("failed to query local: %w", err)
+		}
 
-				continue
-			}
+		log.Debug().Err(err).Msg("query isn't supported by sqlite, falling back to upstream")
 
-			updates, err := r.RowsAffected()
-			cmd := pgproto3.CommandComplete{CommandTag: []byte("DELETE " + fmt.Sprintf("%d", updates))}
+		if err := queryRows(ctx, "upstream.query", upstream, conn, raw); err != nil {
+			return routeFallback, fmt.Errorf("failed to query upstream: %w", err)
+		}
 
-			out := cmd.Encode(nil)
-			ready := &pgproto3.ReadyForQuery{TxStatus: 'I'}
-			out = ready.Encode(out)
+		return routeFallback, nil
+	case strings.HasPrefix(query, "update"):
+		return routeUpstream, execUpstream(ctx, upstream, conn, raw, func(n int64) string {
+			return fmt.Sprintf("UPDATE %d", n)
+		})
+	case strings.HasPrefix(query, "insert"):
+		return routeUpstream, execUpstream(ctx, upstream, conn, raw, func(n int64) string {
+			return fmt.Sprintf("INSERT 0 %d", n)
+		})
+	case strings.HasPrefix(query, "delete"):
+		return routeUpstream, execUpstream(ctx, upstream, conn, raw, func(n int64) string {
+			return fmt.Sprintf("DELETE %d", n)
+		})
+	case strings.HasPrefix(query, "create table"):
+		log.Debug().Msgf("handle create table: %q", query)
 
-			if _, err := conn.Write(out); err != nil {
-				log.Error().Err(err).Msg("write response")
+		return routeUpstream, execUpstream(ctx, upstream, conn, raw, func(int64) string {
+			return "CREATE TABLE"
+		})
+	case strings.HasPrefix(query, "delete table"):
+		return routeUpstream, execUpstream(ctx, upstream, conn, raw, func(int64) string {
+			return "DELETE TABLE"
+		})
+	case strings.HasPrefix(query, "alter table"):
+		return routeUpstream, execUpstream(ctx, upstream, conn, raw, func(int64) string {
+			return "ALTER TABLE"
+		})
+	default:
+		// this covers all unknown queries
+		return routeUnsupported, &pgconn.PgError{
+			Code:    featureNotSupported,
+			Message: fmt.Sprintf("unknown query type: %q", query),
+		}
+	}
+}
 
-				continue
-			}
-		case strings.HasPrefix(query, "create table"):
-			log.Debug().Msgf("handle create table: %q", query)
-			_, err := upstream.Exec(query)
-			if err != nil {
-				errReadyForQuery(fmt.Errorf("failed to query upstream: %w", err), conn)
+// queryRows runs the query on the db, in a span with the name,
+// and writes the rows.
+func queryRows(ctx context.Context, name string, db *sql.DB, conn net.Conn, query string) (err error) {
+	ctx, span := tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient))
+	defer func() { endSpan(span, err) }()
 
-				continue
-			}
-			log.Debug().Msgf("upstream for create table: %q", query)
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
 
-			cmd := pgproto3.CommandComplete{CommandTag: []byte("CREATE TABLE")}
-			out := cmd.Encode(nil)
+	return writeRows(rows, conn)
+}
 
-			ready := &pgproto3.ReadyForQuery{TxStatus: 'I'}
-			out = ready.Encode(out)
+func writeRows(rows *sql.Rows, conn net.Conn) error {
+	defer rows.Close()
 
-			if _, err := conn.Write(out); err != nil {
-				log.Error().Err(err).Msg("write response")
+	desc := rowDesc(rows)
+	out := desc.Encode(nil)
 
-				continue
-			}
-			log.Debug().Msgf("success create table: %q", query)
-		case strings.HasPrefix(query, "delete table"):
-			_, err := upstream.Exec(query)
-			if err != nil {
-				errReadyForQuery(fmt.Errorf("failed to query upstream: %w", err), conn)
+	data := rowData(rows)
+	for _, row := range data {
+		out = row.Encode(out)
+	}
 
-				continue
-			}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("read rows: %w", err)
+	}
 
-			cmd := pgproto3.CommandComplete{CommandTag: []byte("DELETE TABLE")}
-			out := cmd.Encode(nil)
+	log.Debug().Msgf("found %d rows", len(data))
 
-			ready := &pgproto3.ReadyForQuery{TxStatus: 'I'}
-			out = ready.Encode(out)
+	cmd := &pgproto3.CommandComplete{CommandTag: []byte("")}
+	out = cmd.Encode(out)
 
-			if _, err := conn.Write(out); err != nil {
-				log.Error().Err(err).Msg("write response")
+	ready := &pgproto3.ReadyForQuery{TxStatus: 'I'}
+	out = ready.Encode(out)
 
-				continue
-			}
-		case strings.HasPrefix(query, "alter table"):
-			_, err := upstream.Exec(query)
-			if err != nil {
-				errReadyForQuery(fmt.Errorf("failed to query upstream: %w", err), conn)
+	if _, err := conn.Write(out); err != nil {
+		log.Error().Err(err).Msg("write response")
+	}
 
-				continue
-			}
+	return nil
+}
 
-			cmd := pgproto3.CommandComplete{CommandTag: []byte("ALTER TABLE")}
-			out := cmd.Encode(nil)
+func execUpstream(ctx context.Context, upstream *sql.DB, conn net.Conn, query string, tag func(rows int64) string) (err error) {
+	ctx, span := tracer.Start(ctx, "upstream.exec", trace.WithSpanKind(trace.SpanKindClient))
+	defer func() { endSpan(span, err) }()
 
-			ready := &pgproto3.ReadyForQuery{TxStatus: 'I'}
-			out = ready.Encode(out)
+	r, err := upstream.ExecContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to query upstream: %w", err)
+	}
 
-			if _, err := conn.Write(out); err != nil {
-				log.Error().Err(err).Msg("write response")
+	updates, _ := r.RowsAffected()
 
-				continue
-			}
-		default:
-			// this covers all unknown queries
-			errReadyForQuery(fmt.Errorf("unknown query type: %q", query), conn)
+	cmd := pgproto3.CommandComplete{CommandTag: []byte(tag(updates))}
+	out := cmd.Encode(nil)
 
-			continue
-		}
+	ready := &pgproto3.ReadyForQuery{TxStatus: 'I'}
+	out = ready.Encode(out)
 
+	if _, err := conn.Write(out); err != nil {
+		log.Error().Err(err).Msg("write response")
 	}
 
+	return nil
 }
 
 // Eventually this method should parse the connection
 // details and connect to the upstream database using them.
-func onStart(conn net.Conn) error {
+// It returns the startup parameters.
+func onStart(conn net.Conn) (map[string]string, error) {
 	readBuf := make([]byte, 4)
 
 	if _, err := conn.Read(readBuf); err != nil {
-		return fmt.Errorf("read msg len: %w", err)
+		return nil, fmt.Errorf("read msg len: %w", err)
 	}
 
 	l := binary.BigEndian.Uint32(readBuf) - 4
 
 	if l < 4 || l > 10000 {
-		return fmt.Errorf("invalid msg len: %d", l)
+		return nil, fmt.Errorf("invalid msg len: %d", l)
 	}
 
 	b := make([]byte, l)
 
 	if _, err := conn.Read(b); err != nil {
-		return fmt.Errorf("read msg: %w", err)
+		return nil, fmt.Errorf("read msg: %w", err)
 	}
 
 	log.Debug().Msgf("startup message size: %d", l)
 
 	msgType := binary.BigEndian.Uint32(b)
 
+	var params map[string]string
+
 	switch msgType {
 	case SSLRequest:
 		conn.Write([]byte{'N'})
 		return onStart(conn)
 
 	case StartupMessage:
+		params = startupParams(b[4:])
+
 		// AuthenticationOk
 		{
 			success := uint32(0)
@@ -308,7 +409,25 @@ func onStart(conn net.Conn) error {
 		}
 	}
 
-	return nil
+	return params, nil
+}
+
+// startupParams parses the null terminated
+// name and value pairs of a startup message.
+func startupParams(b []byte) map[string]string {
+	params := map[string]string{}
+
+	fields := strings.Split(string(b), "\x00")
+
+	for i := 0; i+1 < len(fields); i += 2 {
+		if fields[i] == "" {
+			break
+		}
+
+		params[fields[i]] = fields[i+1]
+	}
+
+	return params
 }
 
 func rowData(rows *sql.Rows) []*pgproto3.DataRow {
@@ -360,6 +479,10 @@ func rowDesc(rows *sql.Rows) *pgproto3.RowDescription {
 
 	for i, c := range cols {
 		oid, size := sqlgen.ColType(strings.ToLower(types[i].DatabaseTypeName())).PgType()
+		if oid == -1 {
+			// unknown types, and rows from the upstream, are sent as text
+			oid, size = 25, -1
+		}
 
 		rowDesc.Fields = append(rowDesc.Fields, pgproto3.FieldDescription{
 			Name:         []byte(c),
@@ -372,9 +495,37 @@ func rowDesc(rows *sql.Rows) *pgproto3.RowDescription {
 	return rowDesc
 }
 
+// sqlState is the SQLSTATE of an upstream error, or
+// internal_error for errors that don't have one.
+// dialectError is true if sqlite can't run the query because it uses
+// postgres syntax or functions. Missing tables or columns, locks and
+// corruption are faults in the local db, not the query.
+func dialectError(err error) bool {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) || sqliteErr.Code != sqlite3.ErrError {
+		return false
+	}
+
+	msg := sqliteErr.Error()
+
+	return strings.Contains(msg, "syntax error") ||
+		strings.HasPrefix(msg, "no such function") ||
+		strings.HasPrefix(msg, "unrecognized token") ||
+		strings.HasPrefix(msg, "wrong number of arguments")
+}
+
+func sqlState(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code != "" {
+		return pgErr.Code
+	}
+
+	return internalError
+}
+
 func errReadyForQuery(err error, w io.Writer) {
 	log.Error().Err(err).Msg("error in pgwire")
-	errResponse := pgproto3.ErrorResponse{Message: err.Error()}
+	errResponse := pgproto3.ErrorResponse{Severity: "ERROR", Code: sqlState(err), Message: err.Error()}
 	out := errResponse.Encode(nil)
 
 	ready := pgproto3.ReadyForQuery{TxStatus: 'I'}
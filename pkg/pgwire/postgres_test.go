@@ -0,0 +1,135 @@
+package pgwire
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	// stands in for the upstream, with a
+	// function the local db doesn't have
+	sql.Register("sqlite3_upstream", &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			if err := conn.RegisterFunc("pg_only", func(s string) string { return s }, true); err != nil {
+				return err
+			}
+
+			return conn.RegisterFunc("setval", func(name string, v int64) int64 { return v }, true)
+		},
+	})
+}
+
+type queryResult struct {
+	route string
+	err   error
+	rows  [][]byte
+}
+
+// query runs the query through handleQuery, and reads the response.
+func query(t *testing.T, upstream, local *sql.DB, seqs *sequenceSession, raw string) queryResult {
+	t.Helper()
+
+	server, client := net.Pipe()
+	defer client.Close()
+
+	done := make(chan queryResult)
+
+	go func() {
+		route, err := handleQuery(context.Background(), upstream, local, seqs, server, raw)
+		server.Close()
+
+		done <- queryResult{route: route, err: err}
+	}()
+
+	frontend := pgproto3.NewFrontend(client, client)
+
+	var rows [][]byte
+
+	for {
+		msg, err := frontend.Receive()
+		if err != nil {
+			// nothing is written for an error, the caller writes it
+			break
+		}
+
+		if row, ok := msg.(*pgproto3.DataRow); ok {
+			rows = append(rows, row.Values[0])
+		}
+
+		if _, ok := msg.(*pgproto3.ReadyForQuery); ok {
+			break
+		}
+	}
+
+	res := <-done
+	res.rows = rows
+
+	return res
+}
+
+func TestHandleQuery(t *testing.T) {
+	local := sequencesDB(t)
+	seqs := newSequenceSession(NewSequences(local))
+
+	upstream, err := sql.Open("sqlite3_upstream", ":memory:")
+	require.NoError(t, err)
+	defer upstream.Close()
+
+	upstream.SetMaxOpenConns(1)
+
+	_, err = upstream.Exec("CREATE TABLE names (name text);")
+	require.NoError(t, err)
+
+	t.Run("write", func(t *testing.T) {
+		res := query(t, upstream, local, seqs, "INSERT INTO names VALUES ('Alice')")
+		require.NoError(t, res.err)
+		assert.Equal(t, routeUpstream, res.route)
+
+		// the upstream got the query as it was sent, not lower cased
+		var name string
+		require.NoError(t, upstream.QueryRow("SELECT name FROM names").Scan(&name))
+		assert.Equal(t, "Alice", name)
+	})
+
+	t.Run("fallback", func(t *testing.T) {
+		res := query(t, upstream, local, seqs, "SELECT pg_only('Ann')")
+		require.NoError(t, res.err)
+		assert.Equal(t, routeFallback, res.route)
+		assert.Equal(t, [][]byte{[]byte("Ann")}, res.rows)
+	})
+
+	t.Run("nextval", func(t *testing.T) {
+		res := query(t, upstream, local, seqs, "SELECT nextval('names_id_seq')")
+		require.NoError(t, res.err)
+		assert.Equal(t, routeLocal, res.route)
+		assert.Equal(t, [][]byte{[]byte("11")}, res.rows)
+
+		res = query(t, upstream, local, seqs, "SELECT currval('names_id_seq')")
+		require.NoError(t, res.err)
+		assert.Equal(t, routeLocal, res.route)
+		assert.Equal(t, [][]byte{[]byte("11")}, res.rows)
+	})
+
+	t.Run("setval", func(t *testing.T) {
+		res := query(t, upstream, local, seqs, "SELECT setval('names_id_seq', 20)")
+		require.NoError(t, res.err)
+		assert.Equal(t, routeUpstream, res.route)
+		assert.Equal(t, [][]byte{[]byte("20")}, res.rows)
+	})
+
+	t.Run("local fault", func(t *testing.T) {
+		// the table is missing locally, which
+		// the upstream mustn't hide
+		res := query(t, upstream, local, seqs, "SELECT name FROM names")
+		assert.ErrorContains(t, res.err, "no such table")
+		assert.Equal(t, routeLocal, res.route)
+		assert.Empty(t, res.rows)
+	})
+}
@@ -0,0 +1,150 @@
+package pgwire
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var (
+	// setval changes the sequence upstream
+	sequenceSet = regexp.MustCompile(`\bsetval\s*\(`)
+
+	// nextval('name'), currval('schema.name'::regclass)
+	nextvalCall = regexp.MustCompile(`\bnextval\s*\(\s*'([^']+)'(?:\s*::\s*regclass)?\s*\)`)
+	currvalCall = regexp.MustCompile(`\bcurrval\s*\(\s*'([^']+)'(?:\s*::\s*regclass)?\s*\)`)
+)
+
+// setsSequence reports if the query calls setval,
+// which has to run upstream to change the sequence.
+func setsSequence(query string) bool {
+	return sequenceSet.MatchString(query)
+}
+
+// sequenceName is the name of the sequence in postgres_sequences, the
+// schema is the upstream's, which is the only one replicated.
+func sequenceName(arg string) string {
+	if i := strings.LastIndex(arg, "."); i >= 0 {
+		arg = arg[i+1:]
+	}
+
+	return strings.Trim(arg, `"`)
+}
+
+// Sequences emulates nextval for reads served locally, from the
+// sequence values synced to postgres_sequences. The values handed
+// out follow the last value synced from the upstream, and the last
+// value handed out by this process, whichever is further along.
+// They aren't reserved upstream, so they're for edge local writers
+// and a copy that's promoted, not for writes sent upstream.
+type Sequences struct {
+	local *sql.DB
+
+	mu sync.Mutex
+	// the last value handed out for each sequence
+	issued map[string]int64
+}
+
+func NewSequences(local *sql.DB) *Sequences {
+	return &Sequences{local: local, issued: map[string]int64{}}
+}
+
+// next hands out the next value of the sequence.
+func (s *Sequences) next(ctx context.Context, name string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var (
+		lastValue, increment int64
+		called               bool
+	)
+
+	err := s.local.QueryRowContext(
+		ctx,
+		"SELECT last_value, increment_by, is_called FROM postgres_sequences WHERE name = ?;",
+		name,
+	).Scan(&lastValue, &increment, &called)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, &pgconn.PgError{Code: undefinedTable, Message: fmt.Sprintf("relation %q does not exist", name)}
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("read sequence %q: %w", name, err)
+	}
+
+	next := lastValue
+	if called {
+		next += increment
+	}
+
+	if issued, ok := s.issued[name]; ok {
+		// further along in the direction of the sequence
+		if after := issued + increment; (increment > 0 && after > next) || (increment < 0 && after < next) {
+			next = after
+		}
+	}
+
+	s.issued[name] = next
+
+	return next, nil
+}
+
+// sequenceSession is the sequence state of a connection,
+// the last value nextval handed out to it for each sequence.
+type sequenceSession struct {
+	seqs *Sequences
+	last map[string]int64
+}
+
+func newSequenceSession(seqs *Sequences) *sequenceSession {
+	return &sequenceSession{seqs: seqs, last: map[string]int64{}}
+}
+
+// rewrite replaces the nextval calls in a read with the values they
+// hand out, and the currval calls with the value nextval last handed
+// out to the session. Each call is replaced by a single value, so
+// nextval called for each row of a result gets the same value for
+// every row. A currval before nextval in the session is the last
+// value synced from upstream, which is null until nextval is first
+// called there.
+func (s *sequenceSession) rewrite(ctx context.Context, query string) (string, error) {
+	var err error
+
+	query = nextvalCall.ReplaceAllStringFunc(query, func(call string) string {
+		if err != nil {
+			return call
+		}
+
+		name := sequenceName(nextvalCall.FindStringSubmatch(call)[1])
+
+		var v int64
+
+		if v, err = s.seqs.next(ctx, name); err != nil {
+			return call
+		}
+
+		s.last[name] = v
+
+		return strconv.FormatInt(v, 10)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return currvalCall.ReplaceAllStringFunc(query, func(call string) string {
+		name := sequenceName(currvalCall.FindStringSubmatch(call)[1])
+
+		if v, ok := s.last[name]; ok {
+			return strconv.FormatInt(v, 10)
+		}
+
+		return "(SELECT last_value FROM postgres_sequences WHERE name = '" + strings.ReplaceAll(name, "'", "''") + "' AND is_called)"
+	}), nil
+}
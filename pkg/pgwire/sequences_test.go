@@ -0,0 +1,122 @@
+package pgwire
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetsSequence(t *testing.T) {
+	assert.True(t, setsSequence("select setval('names_id_seq', 10)"))
+	assert.True(t, setsSequence("select setval ('names_id_seq', 10), name from names"))
+	assert.False(t, setsSequence("select nextval('names_id_seq')"))
+	assert.False(t, setsSequence("select currval('names_id_seq')"))
+	assert.False(t, setsSequence("select mysetval('names_id_seq')"))
+}
+
+// sequencesDB is a local db with the sequences synced from upstream.
+func sequencesDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`CREATE TABLE postgres_sequences (name text PRIMARY KEY, last_value integer, increment_by integer, is_called boolean);
+	INSERT INTO postgres_sequences VALUES ('names_id_seq', 10, 1, true), ('fresh_seq', 1, 1, false), ('down_seq', -5, -5, true);`)
+	require.NoError(t, err)
+
+	return db
+}
+
+func TestSequencesRewrite(t *testing.T) {
+	ctx := context.Background()
+	seqs := NewSequences(sequencesDB(t))
+
+	a, b := newSequenceSession(seqs), newSequenceSession(seqs)
+
+	tests := []struct {
+		session *sequenceSession
+		query   string
+		want    string
+	}{
+		{
+			// currval before nextval is the last synced value
+			session: a,
+			query:   "select currval('names_id_seq')",
+			want:    "select (SELECT last_value FROM postgres_sequences WHERE name = 'names_id_seq' AND is_called)",
+		},
+		{
+			session: a,
+			query:   "select nextval('public.names_id_seq'::regclass), currval('names_id_seq')",
+			want:    "select 11, 11",
+		},
+		{
+			// values aren't handed out twice across sessions
+			session: b,
+			query:   `select nextval( '"names_id_seq"' ) as id`,
+			want:    "select 12 as id",
+		},
+		{
+			session: a,
+			query:   "select currval('names_id_seq')",
+			want:    "select 11",
+		},
+		{
+			// the first value of a sequence nextval wasn't called on
+			session: a,
+			query:   "select nextval('fresh_seq')",
+			want:    "select 1",
+		},
+		{
+			session: a,
+			query:   "select nextval('down_seq'), nextval('down_seq')",
+			want:    "select -10, -15",
+		},
+		{
+			session: a,
+			query:   "select * from names",
+			want:    "select * from names",
+		},
+	}
+
+	for _, test := range tests {
+		got, err := test.session.rewrite(ctx, test.query)
+		require.NoError(t, err, test.query)
+		assert.Equal(t, test.want, got, test.query)
+	}
+
+	_, err := a.rewrite(ctx, "select nextval('missing_seq')")
+	assert.Equal(t, undefinedTable, sqlState(err))
+}
+
+func TestSequencesSync(t *testing.T) {
+	ctx := context.Background()
+	db := sequencesDB(t)
+	seqs := NewSequences(db)
+
+	v, err := seqs.next(ctx, "names_id_seq")
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), v)
+
+	// a sync that's further along than the values handed out here
+	_, err = db.Exec("UPDATE postgres_sequences SET last_value = 100 WHERE name = 'names_id_seq'")
+	require.NoError(t, err)
+
+	v, err = seqs.next(ctx, "names_id_seq")
+	require.NoError(t, err)
+	assert.Equal(t, int64(101), v)
+
+	// and one that's behind them
+	_, err = db.Exec("UPDATE postgres_sequences SET last_value = 50 WHERE name = 'names_id_seq'")
+	require.NoError(t, err)
+
+	v, err = seqs.next(ctx, "names_id_seq")
+	require.NoError(t, err)
+	assert.Equal(t, int64(102), v)
+}
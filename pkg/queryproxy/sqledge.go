@@ -3,22 +3,62 @@ package queryproxy
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
+	"sync"
 	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
-	_ "github.com/mattn/go-sqlite3"
 	"github.com/rs/zerolog/log"
+	"github.com/zknill/sqledge/pkg/cdc"
 	"github.com/zknill/sqledge/pkg/config"
+	"github.com/zknill/sqledge/pkg/feed"
+	"github.com/zknill/sqledge/pkg/local"
+	"github.com/zknill/sqledge/pkg/metrics"
 	"github.com/zknill/sqledge/pkg/pgwire"
 )
 
 func Run(ctx context.Context, cfg *config.Config) error {
-	localDB, err := sql.Open("sqlite3", cfg.Local.Path)
+	return NewProxy(cfg, nil).Run(ctx)
+}
+
+type Proxy struct {
+	cfg   *config.Config
+	conns *conns
+
+	// hub is the changes committed locally, for
+	// the notifications on the NotifyTables
+	hub *feed.Hub
+}
+
+func NewProxy(cfg *config.Config, hub *feed.Hub) *Proxy {
+	return &Proxy{
+		cfg:   cfg,
+		conns: &conns{open: map[net.Conn]struct{}{}},
+		hub:   hub,
+	}
+}
+
+// Conns is the number of open client connections.
+func (p *Proxy) Conns() int {
+	p.conns.mu.Lock()
+	defer p.conns.mu.Unlock()
+
+	return len(p.conns.open)
+}
+
+// Run serves the proxy until the context is done, and then
+// drains the open connections before returning.
+func (p *Proxy) Run(ctx context.Context) error {
+	cfg, conns := p.cfg, p.conns
+
+	localDB, err := local.OpenReader(local.NewConfig(cfg))
 	if err != nil {
 		return fmt.Errorf("connect to local db: %w", err)
 	}
+	defer localDB.Close()
 
 	log.Debug().Msg("connected to local")
 
@@ -26,8 +66,9 @@ func Run(ctx context.Context, cfg *config.Config) error {
 	if err != nil {
 		return fmt.Errorf("connect to upstream db: %w", err)
 	}
+	defer remoteDB.Close()
 
-	log.Debug().Msgf("connected to remote %q, pinging", cfg.PostgresConnString())
+	log.Debug().Msgf("connected to remote %s:%d, pinging", cfg.Upstream.Address, cfg.Upstream.Port)
 
 	pingCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
@@ -40,34 +81,151 @@ func Run(ctx context.Context, cfg *config.Config) error {
 
 	lis, err := net.Listen("tcp", fmt.Sprintf("%s:%d", cfg.Proxy.Address, cfg.Proxy.Port))
 	if err != nil {
-		log.Fatal().Msg(err.Error())
+		return fmt.Errorf("listen: %w", err)
 	}
 
 	go func() {
-		defer remoteDB.Close()
-		defer localDB.Close()
-		defer lis.Close()
 		<-ctx.Done()
+		lis.Close()
 	}()
 
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			default:
+	sequences := pgwire.NewSequences(localDB)
+
+	var listener *pgwire.Listener
+
+	if cfg.Proxy.Listen {
+		listener = pgwire.NewListener(cfg.PostgresConnString())
+
+		go listener.Run(ctx)
+
+		if p.hub != nil && len(cfg.Proxy.NotifyTables) > 0 {
+			go p.notify(ctx, listener)
+		}
+	}
+
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+
+			if errors.Is(err, net.ErrClosed) {
+				return fmt.Errorf("accept: %w", err)
 			}
 
-			conn, err := lis.Accept()
-			if err != nil {
-				log.Error().Err(err).Msg("accept err")
+			log.Error().Err(err).Msg("accept err")
+
+			continue
+		}
+
+		conns.add(conn)
+
+		go func() {
+			defer conns.remove(conn)
+
+			pgwire.Handle(cfg.Upstream.Schema, remoteDB, localDB, listener, sequences, conn)
+		}()
+	}
+
+	conns.drain(cfg.Supervisor.DrainTimeout)
 
-				continue
+	return ctx.Err()
+}
+
+// notify sends a notification on the sqledge_<table> channel for each
+// row changed locally in the NotifyTables, with the change as the payload.
+func (p *Proxy) notify(ctx context.Context, listener *pgwire.Listener) {
+	for ctx.Err() == nil {
+		sub, err := cdc.Subscribe(ctx, p.hub, cdc.Options{Tables: p.cfg.Proxy.NotifyTables})
+		if err != nil {
+			log.Error().Err(err).Msg("subscribe to changes for notifications")
+			return
+		}
+
+		for ev := range sub.C {
+			for _, change := range ev.Changes {
+				payload, err := json.Marshal(change)
+				if err != nil {
+					log.Error().Err(err).Msgf("encode notification for %q", change.Table)
+					continue
+				}
+
+				listener.Notify(0, "sqledge_"+change.Table, string(payload))
 			}
+		}
 
-			pgwire.Handle(cfg.Upstream.Schema, remoteDB, localDB, conn)
+		if err := sub.Err(); ctx.Err() == nil {
+			// resubscribe from the current position, changes
+			// missed by falling behind aren't notified
+			log.Warn().Err(err).Msg("changes for notifications")
 		}
+	}
+}
+
+// conns tracks the open client connections, so they
+// can be drained when the proxy is stopped.
+type conns struct {
+	mu   sync.Mutex
+	wg   sync.WaitGroup
+	open map[net.Conn]struct{}
+}
+
+func (c *conns) add(conn net.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.wg.Add(1)
+	c.open[conn] = struct{}{}
+
+	metrics.ProxyConnections.Inc()
+	metrics.ProxyConnectionsTotal.Inc()
+}
+
+func (c *conns) remove(conn net.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conn.Close()
+	delete(c.open, conn)
+	c.wg.Done()
+
+	metrics.ProxyConnections.Dec()
+}
+
+// drain stops reading from the connections, so connections that
+// are waiting for a query are closed straight away, and in flight
+// queries finish before the connection is closed. Connections still
+// open after the timeout are closed.
+func (c *conns) drain(timeout time.Duration) {
+	c.mu.Lock()
+	log.Debug().Msgf("draining %d connections", len(c.open))
+
+	for conn := range c.open {
+		conn.SetReadDeadline(time.Now())
+	}
+	c.mu.Unlock()
+
+	done := make(chan struct{})
+
+	go func() {
+		c.wg.Wait()
+		close(done)
 	}()
 
-	return nil
+	select {
+	case <-done:
+		return
+	case <-time.After(timeout):
+	}
+
+	c.mu.Lock()
+	log.Warn().Msgf("closing %d connections after drain timeout", len(c.open))
+
+	for conn := range c.open {
+		conn.Close()
+	}
+	c.mu.Unlock()
+
+	<-done
 }
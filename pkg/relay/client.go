@@ -0,0 +1,164 @@
+package relay
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/zknill/sqledge/pkg/feed"
+)
+
+// Client replicates from a parent sqledge's relay.
+type Client struct {
+	base  string
+	token string
+	http  *http.Client
+}
+
+func NewClient(address, token string) *Client {
+	base := address
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+
+	return &Client{
+		base:  strings.TrimSuffix(base, "/"),
+		token: token,
+		http:  http.DefaultClient,
+	}
+}
+
+// Snapshot downloads the parent's database to dst, and
+// returns the position it's at.
+func (c *Client) Snapshot(ctx context.Context, dst string) (pglogrepl.LSN, error) {
+	resp, err := c.get(ctx, "/relay/snapshot")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	lsn, err := pglogrepl.ParseLSN(resp.Header.Get(lsnHeader))
+	if err != nil {
+		return 0, fmt.Errorf("parse snapshot lsn: %w", err)
+	}
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return 0, fmt.Errorf("create snapshot file: %w", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+
+	if _, err := io.Copy(io.MultiWriter(f, h), resp.Body); err != nil {
+		return 0, fmt.Errorf("download snapshot: %w", err)
+	}
+
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != resp.Header.Get(sha256Header) {
+		return 0, fmt.Errorf("snapshot checksum is %s, the parent sent %s", sum, resp.Header.Get(sha256Header))
+	}
+
+	if err := f.Sync(); err != nil {
+		return 0, fmt.Errorf("sync snapshot file: %w", err)
+	}
+
+	return lsn, f.Close()
+}
+
+// Stream calls apply with each transaction after from, in order, until
+// the context is done or the stream fails. It returns an error wrapping
+// feed.ErrTooOld if the parent no longer has the transactions after from.
+func (c *Client) Stream(ctx context.Context, from pglogrepl.LSN, apply func(feed.Tx) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	resp, err := c.get(ctx, "/relay/stream?from="+from.String())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// the parent sends a heartbeat while idle,
+	// give up on it if that stops
+	idle := time.AfterFunc(idleTimeout, cancel)
+	defer idle.Stop()
+
+	r := bufio.NewReader(resp.Body)
+
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			switch {
+			case !idle.Stop():
+				return fmt.Errorf("parent idle for %s", idleTimeout)
+			case ctx.Err() != nil:
+				return ctx.Err()
+			}
+
+			return fmt.Errorf("read stream: %w", err)
+		}
+
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			idle.Reset(idleTimeout)
+			continue
+		}
+
+		// applying doesn't count as idle
+		idle.Stop()
+
+		var tx feed.Tx
+		if err := json.Unmarshal(line, &tx); err != nil {
+			return fmt.Errorf("decode transaction: %w", err)
+		}
+
+		if err := apply(tx); err != nil {
+			return err
+		}
+
+		idle.Reset(idleTimeout)
+	}
+}
+
+func (c *Client) get(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base+path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("call parent: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusGone {
+		return nil, fmt.Errorf("parent: %s: %w", resp.Status, feed.ErrTooOld)
+	}
+
+	var body errorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Error == "" {
+		return nil, fmt.Errorf("parent: %s", resp.Status)
+	}
+
+	return nil, fmt.Errorf("parent: %s: %s", resp.Status, body.Error)
+}
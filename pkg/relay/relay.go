@@ -0,0 +1,165 @@
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/rs/zerolog/log"
+	"github.com/zknill/sqledge/pkg/feed"
+)
+
+const (
+	lsnHeader    = "Sqledge-Lsn"
+	sha256Header = "Sqledge-Sha256"
+
+	// heartbeat is how often an idle stream sends a blank
+	// line, idleTimeout is how long a child waits for one.
+	heartbeat   = 10 * time.Second
+	idleTimeout = 3 * heartbeat
+)
+
+// Snapshot writes a consistent copy of the local database to
+// path, and returns the position it's at and its SHA-256.
+type Snapshot func(ctx context.Context, path string) (lsn, sha256 string, err error)
+
+type server struct {
+	hub      *feed.Hub
+	snapshot Snapshot
+}
+
+// Handler serves the local database and the transactions applied
+// to it, for children to replicate from:
+//
+//	GET /relay/snapshot              the SQLite database file
+//	GET /relay/stream?from=<lsn>     the transactions after the lsn, and the local work at it, as JSON lines
+func Handler(hub *feed.Hub, snapshot Snapshot) http.Handler {
+	s := &server{hub: hub, snapshot: snapshot}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/relay/snapshot", s.serveSnapshot)
+	mux.HandleFunc("/relay/stream", s.serveStream)
+
+	return mux
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	json.NewEncoder(w).Encode(errorResponse{Error: err.Error()})
+}
+
+func (s *server) serveSnapshot(w http.ResponseWriter, r *http.Request) {
+	dir, err := os.MkdirTemp("", "sqledge-relay-")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "snapshot.db")
+
+	lsn, sum, err := s.snapshot(r.Context(), path)
+	if err != nil {
+		log.Error().Err(err).Msg("relay snapshot")
+		writeError(w, http.StatusInternalServerError, err)
+
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	log.Info().Msgf("sending snapshot at %s to %s", lsn, r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	w.Header().Set(lsnHeader, lsn)
+	w.Header().Set(sha256Header, sum)
+
+	if _, err := io.Copy(w, f); err != nil {
+		log.Warn().Err(err).Msg("send snapshot")
+	}
+}
+
+func (s *server) serveStream(w http.ResponseWriter, r *http.Request) {
+	from, err := pglogrepl.ParseLSN(r.URL.Query().Get("from"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid from: %w", err))
+		return
+	}
+
+	sub, err := s.hub.Subscribe(from)
+	if errors.Is(err, feed.ErrTooOld) {
+		writeError(w, http.StatusGone, fmt.Errorf("%s: %w", from, err))
+		return
+	}
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer sub.Close()
+
+	flusher, _ := w.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flush()
+
+	log.Info().Msgf("relaying from %s to %s", from, r.RemoteAddr)
+
+	enc := json.NewEncoder(w)
+
+	for {
+		ctx, cancel := context.WithTimeout(r.Context(), heartbeat)
+		tx, err := sub.Next(ctx)
+		cancel()
+
+		switch {
+		case r.Context().Err() != nil:
+			return
+		case errors.Is(err, context.DeadlineExceeded):
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return
+			}
+		case err != nil:
+			log.Warn().Err(err).Msgf("relay to %s", r.RemoteAddr)
+			return
+		default:
+			if err := enc.Encode(tx); err != nil {
+				return
+			}
+		}
+
+		flush()
+	}
+}
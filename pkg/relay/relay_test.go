@@ -0,0 +1,93 @@
+package relay_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zknill/sqledge/pkg/feed"
+	"github.com/zknill/sqledge/pkg/relay"
+)
+
+func TestStream(t *testing.T) {
+	hub := feed.NewHub(10)
+	hub.Start(10)
+	hub.Publish(feed.Tx{LSN: 11}, feed.Tx{LSN: 12})
+
+	srv := httptest.NewServer(relay.Handler(hub, nil))
+	defer srv.Close()
+
+	client := relay.NewClient(srv.URL, "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := client.Stream(ctx, 9, func(feed.Tx) error { return nil })
+	assert.ErrorIs(t, err, feed.ErrTooOld)
+
+	done := errors.New("done")
+
+	var got []pglogrepl.LSN
+
+	err = client.Stream(ctx, 10, func(tx feed.Tx) error {
+		got = append(got, tx.LSN)
+
+		if tx.LSN == 12 {
+			hub.Publish(feed.Tx{LSN: 13})
+		}
+
+		if tx.LSN == 13 {
+			return done
+		}
+
+		return nil
+	})
+
+	assert.ErrorIs(t, err, done)
+	assert.Equal(t, []pglogrepl.LSN{11, 12, 13}, got)
+
+	// the parent's local work at 13 is sent again to a child resuming from it
+	local := feed.Tx{LSN: 13, Statements: []string{"DROP TABLE IF EXISTS names;"}, Local: true}
+	hub.Publish(local)
+
+	err = client.Stream(ctx, 13, func(tx feed.Tx) error {
+		assert.Equal(t, local.Statements, tx.Statements)
+		assert.True(t, tx.Local)
+
+		return done
+	})
+
+	assert.ErrorIs(t, err, done)
+}
+
+func TestSnapshot(t *testing.T) {
+	sum := "16a0eeb0791b6c92451fd284dd9f599e0a7dbe7f6ebea6e2d2d06c7f74aec112"
+
+	srv := httptest.NewServer(relay.Handler(feed.NewHub(10), func(ctx context.Context, path string) (string, string, error) {
+		return "0/16B6C50", sum, os.WriteFile(path, []byte("snapshot"), 0o644)
+	}))
+	defer srv.Close()
+
+	client := relay.NewClient(srv.URL, "")
+	dst := filepath.Join(t.TempDir(), "edge.db")
+
+	lsn, err := client.Snapshot(context.Background(), dst)
+	require.NoError(t, err)
+	assert.Equal(t, pglogrepl.LSN(0x16B6C50), lsn)
+
+	b, err := os.ReadFile(dst)
+	require.NoError(t, err)
+	assert.Equal(t, "snapshot", string(b))
+
+	sum = "bad"
+
+	_, err = client.Snapshot(context.Background(), dst)
+	assert.ErrorContains(t, err, "checksum")
+}
@@ -0,0 +1,332 @@
+package replicate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/rs/zerolog/log"
+	"github.com/zknill/sqledge/pkg/config"
+	"github.com/zknill/sqledge/pkg/local"
+)
+
+var ErrLocalExists = errors.New("local database already exists, reset it first")
+
+const manifestVersion = 1
+
+// Manifest describes a snapshot artifact, a copy of the local database
+// that new nodes start from instead of copying from the upstream. It's
+// written next to the database file, with a .json suffix.
+type Manifest struct {
+	Version int `json:"version"`
+
+	// LSN is the position the database was copied at, and
+	// Slot is the slot that retains the changes after it.
+	LSN         string `json:"lsn"`
+	Slot        string `json:"slot"`
+	Publication string `json:"publication"`
+	Plugin      string `json:"plugin"`
+	Database    string `json:"database"`
+	Schema      string `json:"schema"`
+
+	SHA256    string    `json:"sha256"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func manifestPath(path string) string {
+	return path + ".json"
+}
+
+// Export writes a snapshot artifact of the local database to path. The
+// copy is consistent, so it can be taken while replication is running,
+// but the slot has to still retain the changes after the LSN for
+// nodes to start from it, see Bootstrap.
+func Export(ctx context.Context, cfg *config.Config, path string) (*Manifest, error) {
+	if _, err := os.Stat(cfg.Local.Path); err != nil {
+		return nil, fmt.Errorf("local database: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.Remove(tmp); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("remove %s: %w", tmp, err)
+	}
+	defer os.Remove(tmp)
+
+	if err := local.Copy(ctx, local.NewConfig(cfg), tmp); err != nil {
+		return nil, fmt.Errorf("copy local database: %w", err)
+	}
+
+	pos, err := dbPos(cfg, tmp)
+	if err != nil {
+		return nil, err
+	}
+
+	if pos == "" {
+		return nil, fmt.Errorf("local database hasn't been copied yet, run sqledge snapshot first")
+	}
+
+	sum, size, err := checksum(tmp)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{
+		Version:     manifestVersion,
+		LSN:         pos,
+		Slot:        cfg.Replication.SlotName,
+		Publication: cfg.Replication.Publication,
+		Plugin:      cfg.Replication.Plugin,
+		Database:    cfg.Upstream.DBName,
+		Schema:      cfg.Upstream.Schema,
+		SHA256:      sum,
+		Size:        size,
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("encode manifest: %w", err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, fmt.Errorf("rename %s: %w", tmp, err)
+	}
+
+	if err := os.WriteFile(manifestPath(path), append(b, '\n'), 0o644); err != nil {
+		return nil, fmt.Errorf("write manifest: %w", err)
+	}
+
+	return m, nil
+}
+
+// ReadManifest reads the manifest of the snapshot artifact at path.
+func ReadManifest(path string) (*Manifest, error) {
+	b, err := os.ReadFile(manifestPath(path))
+	if err != nil {
+		return nil, fmt.Errorf("read manifest: %w", err)
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("decode manifest: %w", err)
+	}
+
+	if m.Version != manifestVersion {
+		return nil, fmt.Errorf("unsupported manifest version %d", m.Version)
+	}
+
+	return &m, nil
+}
+
+// Bootstrap installs the snapshot artifact at path as the local
+// database, so replication streams from the artifact's LSN without an
+// initial copy. The configured slot is created as a copy of the
+// artifact's slot, which has to still retain the changes after the LSN.
+func Bootstrap(ctx context.Context, cfg *config.Config, path string) (*Manifest, error) {
+	if _, err := os.Stat(cfg.Local.Path); err == nil {
+		return nil, fmt.Errorf("bootstrap %s: %w", cfg.Local.Path, ErrLocalExists)
+	}
+
+	if cfg.Replication.Temporary {
+		return nil, ErrTemporarySlot
+	}
+
+	m, err := ReadManifest(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.matches(cfg); err != nil {
+		return nil, err
+	}
+
+	lsn, err := pglogrepl.ParseLSN(m.LSN)
+	if err != nil {
+		return nil, fmt.Errorf("parse manifest lsn: %w", err)
+	}
+
+	dir := filepath.Dir(cfg.Local.Path)
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(cfg.Local.Path)+".bootstrap-*")
+	if err != nil {
+		return nil, fmt.Errorf("create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := copyFile(tmp, path); err != nil {
+		return nil, err
+	}
+
+	sum, _, err := checksum(tmp.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	if sum != m.SHA256 {
+		return nil, fmt.Errorf("checksum of %s is %s, the manifest has %s", path, sum, m.SHA256)
+	}
+
+	pos, err := dbPos(cfg, tmp.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	if pos != m.LSN {
+		return nil, fmt.Errorf("database is at %q, the manifest has %s", pos, m.LSN)
+	}
+
+	if err := retainSlot(ctx, cfg, m, lsn); err != nil {
+		return nil, err
+	}
+
+	for _, stale := range []string{cfg.Local.Path + "-wal", cfg.Local.Path + "-shm"} {
+		if err := os.Remove(stale); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("remove %s: %w", stale, err)
+		}
+	}
+
+	if err := os.Rename(tmp.Name(), cfg.Local.Path); err != nil {
+		return nil, fmt.Errorf("install local database: %w", err)
+	}
+
+	log.Info().Msgf("bootstrapped %s from %s at %s", cfg.Local.Path, path, m.LSN)
+
+	return m, nil
+}
+
+// matches checks the artifact was exported with the same upstream,
+// the local position is only found for the same database, plugin
+// and publication.
+func (m *Manifest) matches(cfg *config.Config) error {
+	for _, f := range []struct{ name, got, want string }{
+		{"database", m.Database, cfg.Upstream.DBName},
+		{"schema", m.Schema, cfg.Upstream.Schema},
+		{"plugin", m.Plugin, cfg.Replication.Plugin},
+		{"publication", m.Publication, cfg.Replication.Publication},
+	} {
+		if f.got != f.want {
+			return fmt.Errorf("artifact %s is %q, the config has %q", f.name, f.got, f.want)
+		}
+	}
+
+	return nil
+}
+
+// retainSlot makes sure the configured slot retains the changes
+// after lsn, copying it from the artifact's slot if it doesn't exist.
+func retainSlot(ctx context.Context, cfg *config.Config, m *Manifest, lsn pglogrepl.LSN) error {
+	db, err := sql.Open("pgx", cfg.PostgresConnString())
+	if err != nil {
+		return fmt.Errorf("open upstream: %w", err)
+	}
+	defer db.Close()
+
+	slot := cfg.Replication.SlotName
+
+	exists, err := slotRetains(ctx, db, slot, m, lsn)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return nil
+	}
+
+	if _, err := slotRetains(ctx, db, m.Slot, m, lsn); err != nil {
+		return err
+	}
+
+	if _, err := db.ExecContext(ctx, `SELECT pg_copy_logical_replication_slot($1, $2, false);`, m.Slot, slot); err != nil {
+		return fmt.Errorf("copy slot %q to %q: %w", m.Slot, slot, err)
+	}
+
+	log.Debug().Msgf("copied slot %q to %q", m.Slot, slot)
+
+	return nil
+}
+
+// slotRetains checks the slot can stream the changes after lsn. It
+// returns false if the slot doesn't exist.
+func slotRetains(ctx context.Context, db *sql.DB, slot string, m *Manifest, lsn pglogrepl.LSN) (bool, error) {
+	var plugin, database, confirmed sql.NullString
+
+	err := db.QueryRowContext(ctx, `SELECT plugin, database, confirmed_flush_lsn::text
+	FROM pg_replication_slots
+	WHERE slot_name = $1;`, slot).Scan(&plugin, &database, &confirmed)
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		if slot == m.Slot {
+			return false, fmt.Errorf("artifact slot %q doesn't exist", slot)
+		}
+
+		return false, nil
+	case err != nil:
+		return false, fmt.Errorf("read slot %q: %w", slot, err)
+	}
+
+	if plugin.String != m.Plugin || database.String != m.Database {
+		return false, fmt.Errorf("slot %q is for %q with %q, the artifact is for %q with %q",
+			slot, database.String, plugin.String, m.Database, m.Plugin)
+	}
+
+	if confirmed.Valid {
+		confirmedLSN, err := pglogrepl.ParseLSN(confirmed.String)
+		if err != nil {
+			return false, fmt.Errorf("parse confirmed flush lsn: %w", err)
+		}
+
+		if confirmedLSN > lsn {
+			return false, fmt.Errorf("slot %q has confirmed %s, past the artifact's %s, export a new artifact", slot, confirmedLSN, lsn)
+		}
+	}
+
+	return true, nil
+}
+
+func copyFile(dst *os.File, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("open artifact: %w", err)
+	}
+	defer in.Close()
+
+	if _, err := io.Copy(dst, in); err != nil {
+		dst.Close()
+		return fmt.Errorf("copy artifact: %w", err)
+	}
+
+	if err := dst.Sync(); err != nil {
+		dst.Close()
+		return fmt.Errorf("sync artifact: %w", err)
+	}
+
+	return dst.Close()
+}
+
+func checksum(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, fmt.Errorf("open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, fmt.Errorf("read %s: %w", path, err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
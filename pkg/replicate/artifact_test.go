@@ -0,0 +1,69 @@
+package replicate
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zknill/sqledge/pkg/config"
+	"github.com/zknill/sqledge/pkg/local"
+	"github.com/zknill/sqledge/pkg/sqlgen"
+)
+
+func TestExport(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Setenv("SQLEDGE_LOCAL_DB_PATH", filepath.Join(dir, "sqledge.db"))
+	t.Setenv("SQLEDGE_UPSTREAM_NAME", "app")
+
+	cfg, err := config.Load()
+	require.NoError(t, err)
+
+	db, err := local.OpenWriter(local.NewConfig(cfg))
+	require.NoError(t, err)
+	defer db.Close()
+
+	driver := sqlgen.NewSqliteDriver(sqliteConfig(cfg), db)
+	require.NoError(t, driver.InitPositionTable())
+	require.NoError(t, driver.Execute(`CREATE TABLE names (id integer PRIMARY KEY, name text);
+	INSERT INTO names VALUES (1, 'a');`))
+
+	out := filepath.Join(dir, "artifact.db")
+
+	_, err = Export(context.Background(), cfg, out)
+	assert.EqualError(t, err, "local database hasn't been copied yet, run sqledge snapshot first")
+
+	gen := sqlgen.NewSqlite(sqliteConfig(cfg), nil)
+	require.NoError(t, driver.Execute(gen.Pos("0/16B6C50")))
+
+	m, err := Export(context.Background(), cfg, out)
+	require.NoError(t, err)
+
+	assert.Equal(t, "0/16B6C50", m.LSN)
+	assert.Equal(t, cfg.Replication.SlotName, m.Slot)
+	assert.Equal(t, "app", m.Database)
+
+	sum, size, err := checksum(out)
+	require.NoError(t, err)
+	assert.Equal(t, sum, m.SHA256)
+	assert.Equal(t, size, m.Size)
+
+	read, err := ReadManifest(out)
+	require.NoError(t, err)
+	assert.Equal(t, m.SHA256, read.SHA256)
+	assert.NoError(t, read.matches(cfg))
+
+	cfg.Replication.Publication = "other"
+	assert.EqualError(t, read.matches(cfg), `artifact publication is "sqledge", the config has "other"`)
+
+	for _, leftover := range []string{out + ".tmp", out + "-wal"} {
+		_, err = os.Stat(leftover)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	}
+
+	_, err = Bootstrap(context.Background(), cfg, out)
+	assert.ErrorIs(t, err, ErrLocalExists)
+}
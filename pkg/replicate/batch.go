@@ -0,0 +1,125 @@
+package replicate
+
+import "time"
+
+// BatchConfig bounds how many upstream transactions are applied in
+// a single SQLite transaction. A MaxTx of 1 commits each upstream
+// transaction on its own. Zero MaxBytes or MaxDelay are unbounded.
+type BatchConfig struct {
+	MaxTx    int
+	MaxBytes int
+	MaxDelay time.Duration
+}
+
+// batch groups upstream transactions into a single SQLite transaction,
+// to save an fsync per upstream transaction. The SQLite transaction is
+// only committed on upstream transaction boundaries, so readers never
+// see part of an upstream transaction.
+type batch struct {
+	cfg BatchConfig
+
+	open    bool
+	txs     int
+	bytes   int
+	expired bool
+
+	timer *time.Timer
+}
+
+func newBatch(cfg BatchConfig) *batch {
+	return &batch{cfg: cfg}
+}
+
+// begin returns the statement to run at the start
+// of an upstream transaction, if any.
+func (b *batch) begin() string {
+	if b.open {
+		return ""
+	}
+
+	b.open = true
+	b.txs = 0
+	b.bytes = 0
+	b.expired = false
+
+	if b.cfg.MaxDelay > 0 {
+		b.timer = time.NewTimer(b.cfg.MaxDelay)
+	}
+
+	return "BEGIN TRANSACTION;"
+}
+
+// applied counts the bytes of a statement in the batch.
+func (b *batch) applied(n int) {
+	b.bytes += n
+}
+
+// commit returns the statements to run at the end of an upstream
+// transaction, pos is the statement that records its commit LSN.
+func (b *batch) commit(pos string) string {
+	b.txs++
+
+	if b.full() {
+		return pos + " " + b.flush()
+	}
+
+	return pos
+}
+
+func (b *batch) full() bool {
+	switch {
+	case b.expired:
+		return true
+	case b.txs >= b.cfg.MaxTx:
+		return true
+	case b.cfg.MaxBytes > 0 && b.bytes >= b.cfg.MaxBytes:
+		return true
+	}
+
+	return false
+}
+
+// expire fires when the batch has been open for MaxDelay.
+func (b *batch) expire() <-chan time.Time {
+	if !b.open || b.timer == nil {
+		return nil
+	}
+
+	return b.timer.C
+}
+
+// flush returns the statement committing the
+// SQLite transaction, if one is open.
+func (b *batch) flush() string {
+	if !b.close() {
+		return ""
+	}
+
+	return "COMMIT;"
+}
+
+// rollback returns the statement discarding the SQLite
+// transaction, if one is open. The upstream transactions
+// in the batch are replayed from the last committed position.
+func (b *batch) rollback() string {
+	if !b.close() {
+		return ""
+	}
+
+	return "ROLLBACK;"
+}
+
+func (b *batch) close() bool {
+	if !b.open {
+		return false
+	}
+
+	b.open = false
+
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+
+	return true
+}
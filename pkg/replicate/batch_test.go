@@ -0,0 +1,78 @@
+package replicate
+
+import (
+	"testing"
+
+	"github.com/jackc/pglogrepl"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatch(t *testing.T) {
+	t.Run("each transaction", func(t *testing.T) {
+		b := newBatch(BatchConfig{MaxTx: 1})
+
+		assert.Equal(t, "BEGIN TRANSACTION;", b.begin())
+		assert.Equal(t, "pos; COMMIT;", b.commit("pos;"))
+		assert.Equal(t, "BEGIN TRANSACTION;", b.begin())
+	})
+
+	t.Run("max transactions", func(t *testing.T) {
+		b := newBatch(BatchConfig{MaxTx: 3})
+
+		assert.Equal(t, "BEGIN TRANSACTION;", b.begin())
+		assert.Equal(t, "pos1;", b.commit("pos1;"))
+		assert.Equal(t, "", b.begin())
+		assert.Equal(t, "pos2;", b.commit("pos2;"))
+		assert.Equal(t, "", b.begin())
+		assert.Equal(t, "pos3; COMMIT;", b.commit("pos3;"))
+		assert.Equal(t, "", b.flush())
+	})
+
+	t.Run("max bytes", func(t *testing.T) {
+		b := newBatch(BatchConfig{MaxTx: 100, MaxBytes: 10})
+
+		b.begin()
+		b.applied(len("INSERT 1;"))
+		assert.Equal(t, "pos1;", b.commit("pos1;"))
+
+		b.begin()
+		b.applied(len("INSERT 2;"))
+		assert.Equal(t, "pos2; COMMIT;", b.commit("pos2;"))
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		b := newBatch(BatchConfig{MaxTx: 100})
+
+		b.begin()
+		b.expired = true
+		assert.Equal(t, "pos; COMMIT;", b.commit("pos;"))
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		b := newBatch(BatchConfig{MaxTx: 100})
+
+		assert.Equal(t, "", b.rollback())
+
+		b.begin()
+		assert.Equal(t, "pos;", b.commit("pos;"))
+		b.begin()
+		assert.Equal(t, "ROLLBACK;", b.rollback())
+		assert.Equal(t, "", b.flush())
+		assert.Equal(t, "BEGIN TRANSACTION;", b.begin())
+	})
+}
+
+func TestSlotCommit(t *testing.T) {
+	s := &slot{pos: 0x100, flushed: 0x100}
+
+	// received, but still in the open batch
+	s.pos = 0x300
+	assert.Equal(t, pglogrepl.LSN(0x100), s.committed())
+
+	s.commit(0x200)
+	assert.Equal(t, pglogrepl.LSN(0x200), s.committed())
+
+	s.commit(0x150)
+	assert.Equal(t, pglogrepl.LSN(0x200), s.committed())
+}
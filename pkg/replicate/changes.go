@@ -0,0 +1,135 @@
+package replicate
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/zknill/sqledge/pkg/feed"
+)
+
+// changes decodes the row changes in the stream for the feed.
+type changes struct {
+	relations map[uint32]*pglogrepl.RelationMessageV2
+}
+
+func newChanges() *changes {
+	return &changes{relations: make(map[uint32]*pglogrepl.RelationMessageV2)}
+}
+
+func (c *changes) relation(msg *pglogrepl.RelationMessageV2) {
+	c.relations[msg.RelationID] = msg
+}
+
+// decode returns the changes in the message, if it's a row change.
+func (c *changes) decode(msg pglogrepl.Message) []feed.Change {
+	switch msg := msg.(type) {
+	case *pglogrepl.InsertMessageV2:
+		rel, ok := c.relations[msg.RelationID]
+		if !ok {
+			return nil
+		}
+
+		return []feed.Change{{
+			Table: rel.RelationName,
+			Op:    feed.OpInsert,
+			New:   row(rel, msg.Tuple, false),
+		}}
+	case *pglogrepl.UpdateMessageV2:
+		rel, ok := c.relations[msg.RelationID]
+		if !ok {
+			return nil
+		}
+
+		return []feed.Change{{
+			Table: rel.RelationName,
+			Op:    feed.OpUpdate,
+			Old:   row(rel, msg.OldTuple, msg.OldTupleType == pglogrepl.UpdateMessageTupleTypeKey),
+			New:   row(rel, msg.NewTuple, false),
+		}}
+	case *pglogrepl.DeleteMessageV2:
+		rel, ok := c.relations[msg.RelationID]
+		if !ok {
+			return nil
+		}
+
+		return []feed.Change{{
+			Table: rel.RelationName,
+			Op:    feed.OpDelete,
+			Old:   row(rel, msg.OldTuple, msg.OldTupleType == pglogrepl.DeleteMessageTupleTypeKey),
+		}}
+	case *pglogrepl.TruncateMessageV2:
+		out := make([]feed.Change, 0, len(msg.RelationIDs))
+
+		for _, id := range msg.RelationIDs {
+			if rel, ok := c.relations[id]; ok {
+				out = append(out, feed.Change{Table: rel.RelationName, Op: feed.OpTruncate})
+			}
+		}
+
+		return out
+	}
+
+	return nil
+}
+
+// row decodes the tuple, with only the key columns if it's a key.
+func row(rel *pglogrepl.RelationMessageV2, tuple *pglogrepl.TupleData, key bool) map[string]any {
+	if tuple == nil {
+		return nil
+	}
+
+	out := make(map[string]any, len(tuple.Columns))
+
+	for idx, col := range tuple.Columns {
+		if idx >= len(rel.Columns) {
+			break
+		}
+
+		relCol := rel.Columns[idx]
+
+		if key && relCol.Flags != 1 {
+			continue
+		}
+
+		switch col.DataType {
+		case 'u':
+			// unchanged TOAST value
+			continue
+		case 'n':
+			out[relCol.Name] = nil
+		case 'b':
+			out[relCol.Name] = col.Data
+		default:
+			out[relCol.Name] = value(relCol.DataType, string(col.Data))
+		}
+	}
+
+	return out
+}
+
+// value converts the text postgres sent to the type it's encoded to
+// JSON as, values that don't convert are left as text.
+func value(oid uint32, s string) any {
+	switch oid {
+	case pgtype.Int2OID, pgtype.Int4OID, pgtype.Int8OID:
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i
+		}
+	case pgtype.Float4OID, pgtype.Float8OID, pgtype.NumericOID:
+		// NaN and Infinity aren't JSON numbers
+		if f, err := strconv.ParseFloat(s, 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+			return json.Number(s)
+		}
+	case pgtype.BoolOID:
+		return s == "t"
+	case pgtype.JSONOID, pgtype.JSONBOID:
+		if json.Valid([]byte(s)) {
+			return json.RawMessage(s)
+		}
+	}
+
+	return s
+}
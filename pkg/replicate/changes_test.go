@@ -0,0 +1,80 @@
+package replicate
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/zknill/sqledge/pkg/feed"
+)
+
+func TestChangesDecode(t *testing.T) {
+	c := newChanges()
+	c.relation(&pglogrepl.RelationMessageV2{RelationMessage: pglogrepl.RelationMessage{
+		RelationID:      1,
+		RelationName:    "users",
+		ReplicaIdentity: 'd',
+		Columns: []*pglogrepl.RelationMessageColumn{
+			{Flags: 1, Name: "id", DataType: pgtype.Int8OID},
+			{Name: "name", DataType: pgtype.TextOID},
+			{Name: "active", DataType: pgtype.BoolOID},
+			{Name: "score", DataType: pgtype.NumericOID},
+			{Name: "meta", DataType: pgtype.JSONBOID},
+			{Name: "bio", DataType: pgtype.TextOID},
+		},
+	}})
+
+	tuple := func(cols ...*pglogrepl.TupleDataColumn) *pglogrepl.TupleData {
+		return &pglogrepl.TupleData{Columns: cols}
+	}
+	text := func(s string) *pglogrepl.TupleDataColumn {
+		return &pglogrepl.TupleDataColumn{DataType: 't', Data: []byte(s)}
+	}
+	null := &pglogrepl.TupleDataColumn{DataType: 'n'}
+	unchanged := &pglogrepl.TupleDataColumn{DataType: 'u'}
+
+	got := c.decode(&pglogrepl.InsertMessageV2{InsertMessage: pglogrepl.InsertMessage{
+		RelationID: 1,
+		Tuple:      tuple(text("1"), text("ann"), text("t"), text("NaN"), text(`{"a":1}`), null),
+	}})
+
+	assert.Equal(t, []feed.Change{{
+		Table: "users",
+		Op:    feed.OpInsert,
+		New: map[string]any{
+			"id":     int64(1),
+			"name":   "ann",
+			"active": true,
+			"score":  "NaN",
+			"meta":   json.RawMessage(`{"a":1}`),
+			"bio":    nil,
+		},
+	}}, got)
+
+	got = c.decode(&pglogrepl.UpdateMessageV2{UpdateMessage: pglogrepl.UpdateMessage{
+		RelationID:   1,
+		OldTupleType: pglogrepl.UpdateMessageTupleTypeKey,
+		OldTuple:     tuple(text("1"), null, null, null, null, null),
+		NewTuple:     tuple(text("2"), text("ann"), text("f"), text("1.50"), null, unchanged),
+	}})
+
+	assert.Equal(t, []feed.Change{{
+		Table: "users",
+		Op:    feed.OpUpdate,
+		Old:   map[string]any{"id": int64(1)},
+		New: map[string]any{
+			"id":     int64(2),
+			"name":   "ann",
+			"active": false,
+			"score":  json.Number("1.50"),
+			"meta":   nil,
+		},
+	}}, got)
+
+	got = c.decode(&pglogrepl.TruncateMessageV2{TruncateMessage: pglogrepl.TruncateMessage{RelationIDs: []uint32{1}}})
+	assert.Equal(t, []feed.Change{{Table: "users", Op: feed.OpTruncate}}, got)
+
+	assert.Nil(t, c.decode(&pglogrepl.BeginMessage{}))
+}
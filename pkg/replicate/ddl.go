@@ -0,0 +1,293 @@
+package replicate
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+	"github.com/zknill/sqledge/pkg/config"
+)
+
+// ddlPrefix is the prefix of the logical decoding messages
+// the DDL event triggers emit, one for each command.
+const ddlPrefix = "sqledge.ddl"
+
+// ddlEvent is a DDL command run upstream. For tables the columns are
+// the table's after the command, with the same types and key flags
+// a relation message would have.
+type ddlEvent struct {
+	// Event is ddl_command_end or sql_drop
+	Event      string `json:"event"`
+	Tag        string `json:"tag"`
+	ObjectType string `json:"object_type"`
+	Schema     string `json:"schema"`
+	Name       string `json:"name"`
+	Query      string `json:"query"`
+
+	RelationID      uint32      `json:"relid"`
+	ReplicaIdentity string      `json:"replica_identity"`
+	Columns         []ddlColumn `json:"columns"`
+}
+
+type ddlColumn struct {
+	Name string `json:"name"`
+	Type uint32 `json:"type"`
+	Key  bool   `json:"key"`
+}
+
+// relation is the relation message the table would be sent with.
+func (ev ddlEvent) relation() *pglogrepl.RelationMessageV2 {
+	rel := &pglogrepl.RelationMessageV2{RelationMessage: pglogrepl.RelationMessage{
+		RelationID:      ev.RelationID,
+		Namespace:       ev.Schema,
+		RelationName:    ev.Name,
+		ReplicaIdentity: 'd',
+		ColumnNum:       uint16(len(ev.Columns)),
+	}}
+
+	if ev.ReplicaIdentity != "" {
+		rel.ReplicaIdentity = ev.ReplicaIdentity[0]
+	}
+
+	for _, col := range ev.Columns {
+		c := &pglogrepl.RelationMessageColumn{Name: col.Name, DataType: col.Type, TypeModifier: -1}
+
+		if col.Key {
+			c.Flags = 1
+		}
+
+		rel.Columns = append(rel.Columns, c)
+	}
+
+	return rel
+}
+
+var (
+	renameTable  = regexp.MustCompile(`(?is)alter\s+table\s+(?:if\s+exists\s+)?(?:only\s+)?([\w."]+)\s+rename\s+to\s+([\w"]+)`)
+	renameColumn = regexp.MustCompile(`(?is)alter\s+table\s+(?:if\s+exists\s+)?(?:only\s+)?([\w."]+)\s+rename\s+(?:column\s+)?([\w"]+)\s+to\s+([\w"]+)`)
+)
+
+// ddl translates a DDL command run upstream to the SQLite DDL, in the
+// transaction that ran it. Renames are found in the command's query,
+// as the event trigger only knows the new names, and the rest of the
+// changes to a table are made like a relation message would make them.
+// It reports if the indexes need to be synced.
+func (c *Conn) ddl(content []byte, schema string, d DBDriver, gen SQLGen) (string, bool, error) {
+	var ev ddlEvent
+
+	if err := json.Unmarshal(content, &ev); err != nil {
+		log.Warn().Err(err).Msgf("skip invalid ddl message %q", content)
+		return "", false, nil
+	}
+
+	if ev.Schema != schema {
+		return "", false, nil
+	}
+
+	log.Info().Msgf("ddl upstream: %s %s %q", ev.Tag, ev.ObjectType, ev.Name)
+
+	switch {
+	case ev.ObjectType == "index":
+		if ev.Event == "sql_drop" {
+			return gen.DropIndex(ev.Name), false, nil
+		}
+
+		// created indexes are translated by the index sync
+		return "", true, nil
+	case ev.Event == "sql_drop" && ev.ObjectType == "table":
+		c.status.removeTable(ev.Name)
+
+		return gen.DropTable(ev.Name), false, nil
+	case ev.ObjectType != "table" && ev.ObjectType != "table column":
+		return "", false, nil
+	}
+
+	statements := []string{}
+
+	for _, m := range renameTable.FindAllStringSubmatch(ev.Query, -1) {
+		from, to := unqualified(m[1]), ident(m[2])
+
+		if to != ev.Name {
+			continue
+		}
+
+		if stmt := gen.RenameTable(from, to); stmt != "" {
+			c.status.removeTable(from)
+			statements = append(statements, stmt)
+		}
+	}
+
+	for _, m := range renameColumn.FindAllStringSubmatch(ev.Query, -1) {
+		if unqualified(m[1]) != ev.Name {
+			continue
+		}
+
+		if stmt := gen.RenameColumn(ev.Name, ident(m[2]), ident(m[3])); stmt != "" {
+			statements = append(statements, stmt)
+		}
+	}
+
+	if len(ev.Columns) > 0 {
+		rel := ev.relation()
+
+		c.relations[rel.RelationID] = rel.RelationName
+		c.changes.relation(rel)
+		c.status.addTable(rel.RelationName)
+		d.Invalidate(rel.RelationID)
+
+		query, err := gen.Relation(rel)
+		if err != nil {
+			return "", false, fmt.Errorf("%s %q: %w", ev.Tag, ev.Name, err)
+		}
+
+		statements = append(statements, query)
+	}
+
+	return strings.Join(statements, " "), false, nil
+}
+
+// ident is the name of an identifier, unquoted names are
+// folded to lower case like postgres does.
+func ident(s string) string {
+	if strings.HasPrefix(s, `"`) {
+		return strings.ReplaceAll(strings.Trim(s, `"`), `""`, `"`)
+	}
+
+	return strings.ToLower(s)
+}
+
+// unqualified is the name of a table without its schema.
+func unqualified(s string) string {
+	if i := strings.LastIndex(s, "."); i >= 0 {
+		s = s[i+1:]
+	}
+
+	return ident(s)
+}
+
+// ddlTriggers are the event triggers that emit the DDL messages,
+// and the functions they run, in the upstream schema.
+const ddlTriggers = `
+CREATE OR REPLACE FUNCTION %[1]s.sqledge_ddl_command_end() RETURNS event_trigger
+LANGUAGE plpgsql AS $$
+DECLARE
+	cmd record;
+BEGIN
+	FOR cmd IN SELECT * FROM pg_event_trigger_ddl_commands()
+		WHERE object_type IN ('table', 'table column', 'index') AND NOT in_extension
+	LOOP
+		PERFORM pg_logical_emit_message(true, '` + ddlPrefix + `', json_build_object(
+			'event', 'ddl_command_end',
+			'tag', cmd.command_tag,
+			'object_type', cmd.object_type,
+			'schema', cmd.schema_name,
+			'name', c.relname,
+			'query', current_query(),
+			'relid', c.oid,
+			'replica_identity', c.relreplident,
+			'columns', CASE WHEN c.relkind IN ('r', 'p') THEN (
+				SELECT json_agg(json_build_object(
+					'name', a.attname,
+					'type', CASE t.typtype WHEN 'b' THEN t.oid WHEN 'd' THEN t.typbasetype ELSE 'text'::regtype::oid END,
+					'key', coalesce(a.attnum = ANY(i.indkey::int2[]), false)
+				) ORDER BY a.attnum)
+				FROM pg_catalog.pg_attribute a
+				JOIN pg_catalog.pg_type t ON t.oid = a.atttypid
+				LEFT JOIN pg_catalog.pg_index i ON i.indrelid = c.oid
+					AND ((c.relreplident = 'd' AND i.indisprimary) OR (c.relreplident = 'i' AND i.indisreplident))
+				WHERE a.attrelid = c.oid AND a.attnum > 0 AND NOT a.attisdropped
+			) END
+		)::text)
+		FROM pg_catalog.pg_class c
+		WHERE c.oid = cmd.objid;
+	END LOOP;
+END;
+$$;
+
+CREATE OR REPLACE FUNCTION %[1]s.sqledge_sql_drop() RETURNS event_trigger
+LANGUAGE plpgsql AS $$
+DECLARE
+	obj record;
+BEGIN
+	FOR obj IN SELECT * FROM pg_event_trigger_dropped_objects()
+		WHERE object_type IN ('table', 'index') AND NOT is_temporary
+	LOOP
+		PERFORM pg_logical_emit_message(true, '` + ddlPrefix + `', json_build_object(
+			'event', 'sql_drop',
+			'tag', tg_tag,
+			'object_type', obj.object_type,
+			'schema', obj.schema_name,
+			'name', obj.object_name
+		)::text);
+	END LOOP;
+END;
+$$;
+
+DROP EVENT TRIGGER IF EXISTS sqledge_ddl_command_end;
+CREATE EVENT TRIGGER sqledge_ddl_command_end ON ddl_command_end
+	EXECUTE FUNCTION %[1]s.sqledge_ddl_command_end();
+
+DROP EVENT TRIGGER IF EXISTS sqledge_sql_drop;
+CREATE EVENT TRIGGER sqledge_sql_drop ON sql_drop
+	EXECUTE FUNCTION %[1]s.sqledge_sql_drop();
+`
+
+const dropDDLTriggers = `
+DROP EVENT TRIGGER IF EXISTS sqledge_ddl_command_end;
+DROP EVENT TRIGGER IF EXISTS sqledge_sql_drop;
+DROP FUNCTION IF EXISTS %[1]s.sqledge_ddl_command_end();
+DROP FUNCTION IF EXISTS %[1]s.sqledge_sql_drop();
+`
+
+type DDLResult struct {
+	Installed bool     `json:"installed"`
+	Triggers  []string `json:"triggers"`
+}
+
+// InstallDDL creates the event triggers that send the DDL run upstream
+// to the replicas, as logical decoding messages. Event triggers
+// can only be created by a superuser.
+func InstallDDL(ctx context.Context, cfg *config.Config) (*DDLResult, error) {
+	return execDDLTriggers(ctx, cfg, ddlTriggers, true)
+}
+
+// UninstallDDL drops the event triggers.
+func UninstallDDL(ctx context.Context, cfg *config.Config) (*DDLResult, error) {
+	return execDDLTriggers(ctx, cfg, dropDDLTriggers, false)
+}
+
+func execDDLTriggers(ctx context.Context, cfg *config.Config, query string, installed bool) (*DDLResult, error) {
+	db, err := sql.Open("pgx", cfg.PostgresConnString())
+	if err != nil {
+		return nil, fmt.Errorf("open upstream: %w", err)
+	}
+	defer db.Close()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(query, pgx.Identifier{cfg.Upstream.Schema}.Sanitize())); err != nil {
+		return nil, fmt.Errorf("event triggers: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+
+	res := &DDLResult{Installed: installed, Triggers: []string{}}
+
+	if installed {
+		res.Triggers = append(res.Triggers, "sqledge_ddl_command_end", "sqledge_sql_drop")
+	}
+
+	return res, nil
+}
@@ -0,0 +1,80 @@
+package replicate
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zknill/sqledge/pkg/config"
+	"github.com/zknill/sqledge/pkg/local"
+	"github.com/zknill/sqledge/pkg/sqlgen"
+)
+
+func TestDDL(t *testing.T) {
+	t.Setenv("SQLEDGE_LOCAL_DB_PATH", filepath.Join(t.TempDir(), "sqledge.db"))
+
+	cfg, err := config.Load()
+	require.NoError(t, err)
+
+	db, err := local.OpenWriter(local.NewConfig(cfg))
+	require.NoError(t, err)
+	defer db.Close()
+
+	driver := sqlgen.NewSqliteDriver(sqliteConfig(cfg), db)
+	require.NoError(t, driver.InitIndexTable())
+	require.NoError(t, driver.Execute(`CREATE TABLE names (id integer PRIMARY KEY, first text);
+	CREATE TABLE things (id integer PRIMARY KEY);
+	INSERT INTO names VALUES (1, 'ann');`))
+
+	schema, err := driver.CurrentSchema()
+	require.NoError(t, err)
+
+	gen := sqlgen.NewSqlite(sqliteConfig(cfg), schema)
+
+	c := &Conn{relations: map[uint32]string{}, changes: newChanges(), status: newStatus()}
+
+	apply := func(content string) bool {
+		t.Helper()
+
+		query, syncIdx, err := c.ddl([]byte(content), "public", driver, gen)
+		require.NoError(t, err)
+		require.NoError(t, driver.Execute(query))
+
+		return syncIdx
+	}
+
+	// a renamed column keeps its data, and a new column is added
+	apply(`{"event":"ddl_command_end","tag":"ALTER TABLE","object_type":"table column","schema":"public","name":"names",
+		"query":"ALTER TABLE public.names RENAME COLUMN \"first\" TO given; ALTER TABLE names ADD COLUMN last text;",
+		"relid":16384,"replica_identity":"d","columns":[
+			{"name":"id","type":23,"key":true},{"name":"given","type":25,"key":false},{"name":"last","type":25,"key":false}]}`)
+
+	var given string
+	require.NoError(t, db.QueryRow("SELECT given FROM names WHERE id = 1").Scan(&given))
+	assert.Equal(t, "ann", given)
+
+	_, err = db.Exec("SELECT last FROM names")
+	assert.NoError(t, err)
+
+	// a renamed table keeps its rows
+	apply(`{"event":"ddl_command_end","tag":"ALTER TABLE","object_type":"table","schema":"public","name":"people",
+		"query":"alter table names rename to people","relid":16384,"replica_identity":"d","columns":[
+			{"name":"id","type":23,"key":true},{"name":"given","type":25,"key":false},{"name":"last","type":25,"key":false}]}`)
+
+	var n int
+	require.NoError(t, db.QueryRow("SELECT count(*) FROM people").Scan(&n))
+	assert.Equal(t, 1, n)
+
+	// indexes are created by the index sync
+	assert.True(t, apply(`{"event":"ddl_command_end","tag":"CREATE INDEX","object_type":"index","schema":"public","name":"people_last"}`))
+
+	// other schemas are skipped
+	apply(`{"event":"sql_drop","tag":"DROP TABLE","object_type":"table","schema":"other","name":"things"}`)
+	require.NoError(t, db.QueryRow("SELECT count(*) FROM things").Scan(&n))
+
+	apply(`{"event":"sql_drop","tag":"DROP TABLE","object_type":"table","schema":"public","name":"things"}`)
+
+	err = db.QueryRow("SELECT count(*) FROM things").Scan(&n)
+	assert.ErrorContains(t, err, "no such table")
+}
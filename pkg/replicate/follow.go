@@ -0,0 +1,195 @@
+package replicate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/rs/zerolog/log"
+	"github.com/zknill/sqledge/pkg/feed"
+	"github.com/zknill/sqledge/pkg/hooks"
+	"github.com/zknill/sqledge/pkg/local"
+	"github.com/zknill/sqledge/pkg/metrics"
+	"github.com/zknill/sqledge/pkg/relay"
+	"github.com/zknill/sqledge/pkg/sqlgen"
+)
+
+// follow replicates from the parent sqledge's relay, instead of from
+// a slot on the upstream. The parent's database is downloaded if
+// there's no local database, and then the transactions the parent
+// applies after it are applied, and relayed on to any children.
+func (r *Replicator) follow(ctx context.Context) error {
+	cfg := r.cfg
+	client := relay.NewClient(cfg.Relay.Parent, cfg.Relay.ParentToken)
+
+	if _, err := os.Stat(cfg.Local.Path); errors.Is(err, os.ErrNotExist) {
+		if _, err := r.download(ctx, client); err != nil {
+			return err
+		}
+	}
+
+	db, err := local.OpenWriter(local.NewConfig(cfg))
+	if err != nil {
+		return fmt.Errorf("connect to local db: %w", err)
+	}
+	defer db.Close()
+
+	sqliteCfg := sqliteConfig(cfg)
+	driver := sqlgen.NewSqliteDriver(sqliteCfg, db)
+	gen := sqlgen.NewSqlite(sqliteCfg, nil)
+
+	h, err := hooks.New(cfg)
+	if err != nil {
+		return err
+	}
+
+	if h != nil {
+		if err := hooks.InitOutbox(db); err != nil {
+			return err
+		}
+	}
+
+	pos, err := driver.Pos()
+	if err != nil {
+		return fmt.Errorf("find starting pos: %w", err)
+	}
+
+	if pos == "" {
+		return fmt.Errorf("local database has no position to follow %s from, reset it to download a new snapshot", cfg.Relay.Parent)
+	}
+
+	lsn, err := pglogrepl.ParseLSN(pos)
+	if err != nil {
+		return fmt.Errorf("parse pos: %w", err)
+	}
+
+	schema, err := driver.CurrentSchema()
+	if err != nil {
+		return fmt.Errorf("get current schema: %w", err)
+	}
+
+	for table := range schema {
+		r.status.addTable(table)
+	}
+
+	go func() {
+		if err := local.Checkpoint(ctx, local.NewConfig(cfg)); err != nil && !errors.Is(err, context.Canceled) {
+			log.Warn().Err(err).Msg("checkpoint")
+		}
+	}()
+
+	r.feed.Start(lsn)
+
+	r.status.update(func(s *Status) {
+		s.Publication = cfg.Replication.Publication
+		s.Copied = true
+		s.Streaming = true
+		s.CurrentLSN = lsn
+	})
+	defer r.status.update(func(s *Status) { s.Streaming = false })
+
+	log.Info().Msgf("following %s from %s", cfg.Relay.Parent, lsn)
+
+	err = client.Stream(ctx, lsn, func(tx feed.Tx) error {
+		// local work is sent again from its position
+		if tx.LSN < lsn || tx.LSN == lsn && !tx.Local {
+			return nil
+		}
+
+		received := time.Now()
+		r.status.update(func(s *Status) { s.PendingSince = received })
+
+		outbox, err := h.Outbox(tx)
+		if err != nil {
+			return err
+		}
+
+		statements := make([]string, 0, len(tx.Statements)+4)
+		statements = append(statements, "BEGIN TRANSACTION;")
+		statements = append(statements, tx.Statements...)
+		statements = append(statements, outbox, gen.Pos(tx.LSN.String()), "COMMIT;")
+
+		start := time.Now()
+
+		if err := driver.Execute(strings.Join(statements, "\n")); err != nil {
+			if rbErr := driver.Execute("ROLLBACK;"); rbErr != nil {
+				log.Warn().Err(rbErr).Msg("rollback relayed transaction")
+			}
+
+			return fmt.Errorf("apply relayed transaction %s: %w", tx.LSN, err)
+		}
+
+		metrics.ApplyDuration.Observe(time.Since(start).Seconds())
+
+		lsn = tx.LSN
+
+		r.status.update(func(s *Status) {
+			s.CurrentLSN = tx.LSN
+			s.PendingSince = time.Time{}
+
+			// local work isn't an upstream commit
+			if !tx.Local {
+				s.LastCommitTime = tx.CommitTime
+				s.Lag = time.Since(tx.CommitTime)
+
+				metrics.LagSeconds.Set(s.Lag.Seconds())
+			}
+		})
+
+		r.feed.Publish(tx)
+
+		return r.messages.enqueue(ctx, tx)
+	})
+
+	if errors.Is(err, feed.ErrTooOld) {
+		return fmt.Errorf("follow %s: the parent no longer has the transactions after %s, reset the local database: %w", cfg.Relay.Parent, lsn, err)
+	}
+
+	if err != nil && ctx.Err() == nil {
+		return fmt.Errorf("follow %s: %w", cfg.Relay.Parent, err)
+	}
+
+	return ctx.Err()
+}
+
+// download installs the parent's database as the local database.
+func (r *Replicator) download(ctx context.Context, client *relay.Client) (pglogrepl.LSN, error) {
+	cfg := r.cfg
+
+	tmp := cfg.Local.Path + ".download"
+	defer os.Remove(tmp)
+
+	log.Info().Msgf("downloading snapshot from %s", cfg.Relay.Parent)
+
+	lsn, err := client.Snapshot(ctx, tmp)
+	if err != nil {
+		return 0, fmt.Errorf("download snapshot: %w", err)
+	}
+
+	pos, err := dbPos(cfg, tmp)
+	if err != nil {
+		return 0, err
+	}
+
+	if pos != lsn.String() {
+		return 0, fmt.Errorf("snapshot is at %q for this config, the parent sent %s: the upstream name, plugin and publication must match the parent's", pos, lsn)
+	}
+
+	for _, stale := range []string{cfg.Local.Path + "-wal", cfg.Local.Path + "-shm"} {
+		if err := os.Remove(stale); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return 0, fmt.Errorf("remove %s: %w", stale, err)
+		}
+	}
+
+	if err := os.Rename(tmp, cfg.Local.Path); err != nil {
+		return 0, fmt.Errorf("install snapshot: %w", err)
+	}
+
+	log.Info().Msgf("downloaded snapshot at %s", lsn)
+
+	return lsn, nil
+}
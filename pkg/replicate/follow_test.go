@@ -0,0 +1,241 @@
+package replicate
+
+import (
+	"context"
+	"database/sql"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zknill/sqledge/pkg/config"
+	"github.com/zknill/sqledge/pkg/feed"
+	"github.com/zknill/sqledge/pkg/local"
+	"github.com/zknill/sqledge/pkg/relay"
+	"github.com/zknill/sqledge/pkg/sqlgen"
+)
+
+// relayDB opens a database at 0/10 with the names table, as a
+// parent is, and as a child is once it's downloaded the parent's.
+func relayDB(t *testing.T, cfg *config.Config, path string) (*sql.DB, *sqlgen.SqliteDriver) {
+	t.Helper()
+
+	lcfg := local.NewConfig(cfg)
+	lcfg.Path = path
+
+	db, err := local.OpenWriter(lcfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	driver := sqlgen.NewSqliteDriver(sqliteConfig(cfg), db)
+	require.NoError(t, driver.InitPositionTable())
+	require.NoError(t, driver.InitIndexTable())
+	require.NoError(t, driver.InitSequenceTable())
+
+	gen := sqlgen.NewSqlite(sqliteConfig(cfg), nil)
+	require.NoError(t, driver.Execute(`CREATE TABLE names (id integer PRIMARY KEY, name text);
+	INSERT INTO names VALUES (1, 'a');`+gen.Pos("0/10")))
+
+	return db, driver
+}
+
+// follow runs the child until it's applied the transaction at lsn.
+func follow(t *testing.T, cfg *config.Config, publish func(hub *feed.Hub), lsn pglogrepl.LSN) *Replicator {
+	t.Helper()
+
+	hub := feed.NewHub(10)
+	hub.Start(0x10)
+
+	srv := httptest.NewServer(relay.Handler(hub, nil))
+	defer srv.Close()
+
+	cfg.Relay.Parent = srv.URL
+
+	r := NewReplicator(cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() { done <- r.follow(ctx) }()
+
+	publish(hub)
+
+	require.Eventually(t, func() bool { return r.Status().CurrentLSN == lsn }, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+
+	return r
+}
+
+func names(t *testing.T, db *sql.DB) map[int]string {
+	t.Helper()
+
+	rows, err := db.Query("SELECT id, name FROM names ORDER BY id")
+	require.NoError(t, err)
+	defer rows.Close()
+
+	got := map[int]string{}
+
+	for rows.Next() {
+		var (
+			id   int
+			name string
+		)
+
+		require.NoError(t, rows.Scan(&id, &name))
+		got[id] = name
+	}
+
+	require.NoError(t, rows.Err())
+
+	return got
+}
+
+func TestFollowLocal(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("SQLEDGE_LOCAL_DB_PATH", filepath.Join(dir, "child.db"))
+
+	cfg, err := config.Load()
+	require.NoError(t, err)
+
+	parentDB, parent := relayDB(t, cfg, filepath.Join(dir, "parent.db"))
+	childDB, _ := relayDB(t, cfg, cfg.Local.Path)
+	require.NoError(t, childDB.Close())
+
+	gen := sqlgen.NewSqlite(sqliteConfig(cfg), map[string]map[string]sqlgen.ColDef{})
+
+	// the parent resyncs names at 0/20, and then syncs its indexes
+	// and sequences, before the upstream transaction at 0/30
+	r := &resync{
+		req: resyncRequest{table: "names"},
+		result: &resyncResult{
+			table:    "names",
+			snapshot: 0x20,
+			defs: []sqlgen.ColDef{
+				{Name: "id", Type: sqlgen.PgColTypeInt4, PrimaryKey: true},
+				{Name: "name", Type: sqlgen.PgColTypeText},
+			},
+			rows: [][]any{{int32(1), "b"}, {int32(2), "c"}},
+		},
+	}
+
+	swap, err := r.swap("public", parent, gen)
+	require.NoError(t, err)
+
+	indexes, err := syncIndexes([]sqlgen.IndexDef{{Name: "names_name_idx", Table: "names", Columns: []string{"name"}}}, parent, gen)
+	require.NoError(t, err)
+
+	sequences, err := syncSequences([]sqlgen.SequenceDef{{Name: "names_id_seq", LastValue: 2, Increment: 1, Called: true}}, parent, gen)
+	require.NoError(t, err)
+
+	local := feed.Tx{LSN: 0x20, Statements: append(append(swap, indexes...), sequences), Local: true}
+	insert := "INSERT INTO names (id, name) VALUES (3, 'd');"
+
+	require.NoError(t, parent.Execute(insert))
+
+	child := follow(t, cfg, func(hub *feed.Hub) {
+		hub.Publish(
+			feed.Tx{LSN: 0x20, Statements: []string{"UPDATE names SET name = 'b' WHERE id = 1;"}},
+			local,
+			feed.Tx{LSN: 0x30, Statements: []string{insert}},
+		)
+	}, 0x30)
+
+	childDB, err = sql.Open("sqlite3", cfg.Local.Path)
+	require.NoError(t, err)
+	defer childDB.Close()
+
+	assert.Equal(t, map[int]string{1: "b", 2: "c", 3: "d"}, names(t, childDB))
+	assert.Equal(t, names(t, parentDB), names(t, childDB))
+
+	var index string
+	require.NoError(t, childDB.QueryRow("SELECT name FROM sqlite_schema WHERE type = 'index' AND tbl_name = 'names'").Scan(&index))
+	assert.Equal(t, "names_name_idx", index)
+
+	var last int64
+	require.NoError(t, childDB.QueryRow("SELECT last_value FROM postgres_sequences WHERE name = 'names_id_seq'").Scan(&last))
+	assert.Equal(t, int64(2), last)
+
+	// and relayed on to the child's children
+	sub, err := child.Feed().Subscribe(0x20)
+	require.NoError(t, err)
+	defer sub.Close()
+
+	got, err := sub.Next(context.Background())
+	require.NoError(t, err)
+	assert.True(t, got.Local)
+}
+
+func TestFollowRebuild(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("SQLEDGE_LOCAL_DB_PATH", filepath.Join(dir, "child.db"))
+
+	cfg, err := config.Load()
+	require.NoError(t, err)
+
+	parentDB, parent := relayDB(t, cfg, filepath.Join(dir, "parent.db"))
+	childDB, child := relayDB(t, cfg, cfg.Local.Path)
+
+	for _, driver := range []*sqlgen.SqliteDriver{parent, child} {
+		require.NoError(t, driver.Execute("CREATE INDEX names_name ON names (name);"))
+	}
+
+	require.NoError(t, childDB.Close())
+
+	schema, err := parent.CurrentSchema()
+	require.NoError(t, err)
+
+	gen := sqlgen.NewSqlite(sqliteConfig(cfg), schema)
+	gen.LookupObjects(parent.TableObjects)
+
+	// the primary key moves to name, which SQLite can only
+	// do by rebuilding the table on the parent
+	rebuild, err := gen.Relation(&pglogrepl.RelationMessageV2{RelationMessage: pglogrepl.RelationMessage{
+		RelationID:      1,
+		Namespace:       "public",
+		RelationName:    "names",
+		ReplicaIdentity: 'd',
+		ColumnNum:       2,
+		Columns: []*pglogrepl.RelationMessageColumn{
+			{Name: "id", DataType: pgtype.Int4OID},
+			{Flags: 1, Name: "name", DataType: pgtype.TextOID},
+		},
+	}})
+	require.NoError(t, err)
+
+	insert := "INSERT INTO names (id, name) VALUES (2, 'b');"
+	require.NoError(t, parent.Execute("BEGIN; "+rebuild+insert+" COMMIT;"))
+
+	follow(t, cfg, func(hub *feed.Hub) {
+		hub.Publish(feed.Tx{LSN: 0x20, Statements: []string{rebuild, insert}})
+	}, 0x20)
+
+	childDB, err = sql.Open("sqlite3", cfg.Local.Path)
+	require.NoError(t, err)
+	defer childDB.Close()
+
+	child = sqlgen.NewSqliteDriver(sqliteConfig(cfg), childDB)
+
+	assert.Equal(t, map[int]string{1: "a", 2: "b"}, names(t, childDB))
+	assert.Equal(t, names(t, parentDB), names(t, childDB))
+
+	want, err := parent.CurrentSchema()
+	require.NoError(t, err)
+
+	got, err := child.CurrentSchema()
+	require.NoError(t, err)
+
+	assert.Equal(t, want, got)
+	assert.True(t, got["names"]["name"].PrimaryKey)
+
+	// the index survives the rebuild on the child too
+	objects, err := child.TableObjects("names")
+	require.NoError(t, err)
+	require.Len(t, objects, 1)
+	assert.Equal(t, "names_name", objects[0].Name)
+}
@@ -0,0 +1,124 @@
+package replicate
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/zknill/sqledge/pkg/sqlgen"
+	"github.com/zknill/sqledge/pkg/tables"
+)
+
+type indexResult struct {
+	defs []sqlgen.IndexDef
+	err  error
+}
+
+func upstreamIndexes(connStr, schema string) ([]sqlgen.IndexDef, error) {
+	db, err := sql.Open("pgx", strings.Replace(connStr, "replication=database", "", 1))
+	if err != nil {
+		return nil, fmt.Errorf("open connection: %w", err)
+	}
+	defer db.Close()
+
+	defs, err := tables.Indexes(db, schema)
+	if err != nil {
+		return nil, fmt.Errorf("load indexes: %w", err)
+	}
+
+	return defs, nil
+}
+
+// syncIndexes creates, replaces and drops the local indexes that were
+// created from upstream indexes so they match the upstream, and returns
+// the statements that changed them. Indexes on tables that don't exist
+// locally yet are left for the next sync. Edge only indexes aren't
+// tracked, so they are never dropped.
+func syncIndexes(upstream []sqlgen.IndexDef, d DBDriver, gen SQLGen) ([]string, error) {
+	local, err := d.Indexes()
+	if err != nil {
+		return nil, fmt.Errorf("local indexes: %w", err)
+	}
+
+	keep := map[string]bool{}
+	applied := []string{}
+
+	for _, idx := range upstream {
+		ddl, err := gen.CreateIndex(idx)
+
+		switch {
+		case errors.Is(err, sqlgen.ErrUnknownTable):
+			log.Debug().Err(err).Msg("skip index")
+			keep[idx.Name] = true
+			continue
+		case err != nil:
+			log.Warn().Err(err).Msg("skip index")
+			continue
+		}
+
+		keep[idx.Name] = true
+
+		if local[idx.Name] == ddl {
+			continue
+		}
+
+		statements := []string{}
+
+		if _, ok := local[idx.Name]; ok {
+			statements = append(statements, gen.DropIndex(idx.Name))
+		}
+
+		statements = append(statements, ddl, gen.TrackIndex(idx.Name, ddl))
+
+		log.Debug().Msg(ddl)
+
+		query := strings.Join(statements, " ")
+
+		if err := d.Execute(query); err != nil {
+			log.Warn().Err(err).Msgf("create index %q", idx.Name)
+			continue
+		}
+
+		applied = append(applied, query)
+	}
+
+	for name := range local {
+		if keep[name] {
+			continue
+		}
+
+		log.Debug().Msgf("dropping index %q", name)
+
+		query := gen.DropIndex(name)
+
+		if err := d.Execute(query); err != nil {
+			log.Warn().Err(err).Msgf("drop index %q", name)
+			continue
+		}
+
+		applied = append(applied, query)
+	}
+
+	return applied, nil
+}
+
+// createLocalIndexes creates the edge only indexes from config that
+// haven't been created yet. They are retried on each index sync, as
+// their table might not have been replicated yet.
+func createLocalIndexes(statements []string, created map[string]bool, d DBDriver) {
+	for _, stmt := range statements {
+		stmt = strings.TrimSpace(stmt)
+		if stmt == "" || created[stmt] {
+			continue
+		}
+
+		if err := d.Execute(stmt); err != nil && !strings.Contains(err.Error(), "already exists") {
+			log.Debug().Err(err).Msgf("create local index %q", stmt)
+			continue
+		}
+
+		created[stmt] = true
+	}
+}
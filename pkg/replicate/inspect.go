@@ -0,0 +1,128 @@
+package replicate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/zknill/sqledge/pkg/config"
+	"github.com/zknill/sqledge/pkg/local"
+	"github.com/zknill/sqledge/pkg/sqlgen"
+)
+
+// Report is the replication state read from the local database and
+// the upstream, without replication having to be running.
+type Report struct {
+	Slot        string `json:"slot"`
+	SlotExists  bool   `json:"slot_exists"`
+	SlotActive  bool   `json:"slot_active"`
+	Temporary   bool   `json:"slot_temporary"`
+	Publication string `json:"publication"`
+
+	// LocalLSN is the position applied to the local database.
+	LocalLSN          string `json:"local_lsn"`
+	ServerLSN         string `json:"server_lsn"`
+	RestartLSN        string `json:"restart_lsn"`
+	ConfirmedFlushLSN string `json:"confirmed_flush_lsn"`
+
+	// LagBytes is how far the local database is behind the
+	// upstream, RetainedBytes is the WAL the slot is holding.
+	LagBytes      uint64 `json:"lag_bytes"`
+	RetainedBytes uint64 `json:"retained_bytes"`
+}
+
+// Inspect reads the local position, and the slot's state upstream.
+func Inspect(ctx context.Context, cfg *config.Config) (*Report, error) {
+	report := &Report{
+		Slot:        cfg.Replication.SlotName,
+		Publication: cfg.Replication.Publication,
+	}
+
+	pos, err := localPos(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	report.LocalLSN = pos
+
+	db, err := sql.Open("pgx", cfg.PostgresConnString())
+	if err != nil {
+		return nil, fmt.Errorf("open upstream: %w", err)
+	}
+	defer db.Close()
+
+	if err := db.QueryRowContext(ctx, "SELECT pg_current_wal_lsn()::text;").Scan(&report.ServerLSN); err != nil {
+		return nil, fmt.Errorf("read server lsn: %w", err)
+	}
+
+	var restart, confirmed sql.NullString
+
+	err = db.QueryRowContext(ctx, `SELECT active, temporary, restart_lsn::text, confirmed_flush_lsn::text
+	FROM pg_replication_slots
+	WHERE slot_name = $1;`, cfg.Replication.SlotName).Scan(&report.SlotActive, &report.Temporary, &restart, &confirmed)
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return nil, fmt.Errorf("read slot: %w", err)
+	default:
+		report.SlotExists = true
+		report.RestartLSN = restart.String
+		report.ConfirmedFlushLSN = confirmed.String
+	}
+
+	server, err := pglogrepl.ParseLSN(report.ServerLSN)
+	if err != nil {
+		return nil, fmt.Errorf("parse server lsn: %w", err)
+	}
+
+	report.LagBytes = bytesBehind(server, report.LocalLSN)
+	report.RetainedBytes = bytesBehind(server, report.RestartLSN)
+
+	return report, nil
+}
+
+// localPos is the position in the local database, or
+// empty if nothing has been copied yet.
+func localPos(cfg *config.Config) (string, error) {
+	return dbPos(cfg, cfg.Local.Path)
+}
+
+// dbPos is the position in the SQLite database at path.
+func dbPos(cfg *config.Config, path string) (string, error) {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+
+	lcfg := local.NewConfig(cfg)
+	lcfg.Path = path
+
+	db, err := local.OpenReader(lcfg)
+	if err != nil {
+		return "", fmt.Errorf("open local db: %w", err)
+	}
+	defer db.Close()
+
+	var tables int
+	if err := db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'postgres_pos';`).Scan(&tables); err != nil {
+		return "", fmt.Errorf("find position table: %w", err)
+	}
+
+	if tables == 0 {
+		return "", nil
+	}
+
+	return sqlgen.NewSqliteDriver(sqliteConfig(cfg), db).Pos()
+}
+
+func bytesBehind(server pglogrepl.LSN, pos string) uint64 {
+	lsn, err := pglogrepl.ParseLSN(pos)
+	if err != nil || lsn > server {
+		return 0
+	}
+
+	return uint64(server - lsn)
+}
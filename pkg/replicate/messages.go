@@ -0,0 +1,172 @@
+package replicate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/rs/zerolog/log"
+	"github.com/zknill/sqledge/pkg/feed"
+	"github.com/zknill/sqledge/pkg/metrics"
+)
+
+// Message is a message emitted upstream with pg_logical_emit_message.
+type Message struct {
+	// LSN is the commit LSN of a transactional message's
+	// transaction, or the message's own LSN.
+	LSN pglogrepl.LSN
+
+	feed.Message
+}
+
+// MessageHandler handles the messages with a prefix. Messages are
+// handled in order, after the changes before them are committed
+// locally, so a transactional message is handled once the rest of
+// its transaction is visible.
+type MessageHandler func(ctx context.Context, msg Message) error
+
+// messageQueue is how many messages can wait to be
+// handled before the stream waits for the handlers.
+const messageQueue = 1000
+
+// messages is the registry of message handlers, by prefix.
+type messages struct {
+	mu       sync.Mutex
+	handlers map[string][]MessageHandler
+
+	queue chan Message
+}
+
+func newMessages() *messages {
+	return &messages{
+		handlers: map[string][]MessageHandler{},
+		queue:    make(chan Message, messageQueue),
+	}
+}
+
+// HandleMessages calls h with the messages emitted upstream whose prefix
+// is prefix, or starts with prefix and a dot, e.g. app for app.cache.
+// The * prefix handles every message.
+func (r *Replicator) HandleMessages(prefix string, h MessageHandler) {
+	r.messages.mu.Lock()
+	defer r.messages.mu.Unlock()
+
+	r.messages.handlers[prefix] = append(r.messages.handlers[prefix], h)
+}
+
+// enqueue queues the messages in the transactions to be handled.
+func (m *messages) enqueue(ctx context.Context, txs ...feed.Tx) error {
+	for _, tx := range txs {
+		for _, msg := range tx.Messages {
+			select {
+			case m.queue <- Message{LSN: tx.LSN, Message: msg}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+
+	return nil
+}
+
+// run handles the queued messages until the context is done.
+func (m *messages) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-m.queue:
+			m.handle(ctx, msg)
+		}
+	}
+}
+
+func (m *messages) handle(ctx context.Context, msg Message) {
+	m.mu.Lock()
+
+	var handlers []MessageHandler
+
+	for prefix, hs := range m.handlers {
+		if msg.HasPrefix(prefix) {
+			handlers = append(handlers, hs...)
+		}
+	}
+
+	m.mu.Unlock()
+
+	if len(handlers) == 0 {
+		log.Debug().Msgf("no handler for message %q at %s", msg.Prefix, msg.LSN)
+		metrics.LogicalMessages.WithLabelValues(msg.Prefix, "unhandled").Inc()
+
+		return
+	}
+
+	for _, h := range handlers {
+		if err := h(ctx, msg); err != nil {
+			log.Error().Err(err).Msgf("handle message %q at %s", msg.Prefix, msg.LSN)
+			metrics.LogicalMessages.WithLabelValues(msg.Prefix, "error").Inc()
+
+			continue
+		}
+
+		metrics.LogicalMessages.WithLabelValues(msg.Prefix, "handled").Inc()
+	}
+}
+
+// handleBuiltin registers the sqledge.* handlers:
+//
+//	sqledge.resync        resync the table in the content
+//	sqledge.maintenance   turn maintenance mode on or off, readyz fails while it's on
+//	sqledge.barrier       record the content and LSN in the status, for tests to wait for
+//	sqledge.ddl           nothing, the DDL is applied by the stream
+func (r *Replicator) handleBuiltin() {
+	r.HandleMessages("sqledge.resync", func(ctx context.Context, msg Message) error {
+		table := strings.TrimSpace(msg.Content)
+		if table == "" {
+			return fmt.Errorf("no table to resync")
+		}
+
+		// the resync waits for the stream,
+		// don't hold up the other messages
+		go func() {
+			if err := r.Resync(ctx, table); err != nil {
+				log.Error().Err(err).Msgf("resync %q for message at %s", table, msg.LSN)
+			}
+		}()
+
+		return nil
+	})
+
+	r.HandleMessages("sqledge.maintenance", func(ctx context.Context, msg Message) error {
+		var on bool
+
+		switch strings.ToLower(strings.TrimSpace(msg.Content)) {
+		case "on", "true":
+			on = true
+		case "off", "false":
+		default:
+			return fmt.Errorf("invalid maintenance %q, want on or off", msg.Content)
+		}
+
+		log.Info().Msgf("maintenance mode: %t", on)
+
+		r.status.update(func(s *Status) { s.Maintenance = on })
+
+		return nil
+	})
+
+	r.HandleMessages("sqledge.barrier", func(ctx context.Context, msg Message) error {
+		r.status.update(func(s *Status) {
+			s.Barrier = msg.Content
+			s.BarrierLSN = msg.LSN
+		})
+
+		return nil
+	})
+
+	r.HandleMessages(ddlPrefix, func(ctx context.Context, msg Message) error {
+		return nil
+	})
+}
@@ -0,0 +1,60 @@
+package replicate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zknill/sqledge/pkg/config"
+	"github.com/zknill/sqledge/pkg/feed"
+)
+
+func TestHandleMessages(t *testing.T) {
+	r := NewReplicator(&config.Config{})
+
+	got := make(chan Message, 10)
+	handler := func(ctx context.Context, msg Message) error {
+		got <- msg
+		return nil
+	}
+
+	r.HandleMessages("app", handler)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go r.messages.run(ctx)
+
+	require.NoError(t, r.messages.enqueue(ctx,
+		feed.Tx{LSN: 10, Messages: []feed.Message{
+			{Prefix: "app.cache", Content: "users", Transactional: true},
+			{Prefix: "apple", Content: "not app"},
+			{Prefix: "sqledge.maintenance", Content: "on"},
+		}},
+		feed.Tx{LSN: 11, Messages: []feed.Message{
+			{Prefix: "app", Content: "bust"},
+			{Prefix: "sqledge.barrier", Content: "test-1"},
+		}},
+	))
+
+	msg := <-got
+	assert.Equal(t, pglogrepl.LSN(10), msg.LSN)
+	assert.Equal(t, "app.cache", msg.Prefix)
+	assert.True(t, msg.Transactional)
+
+	msg = <-got
+	assert.Equal(t, pglogrepl.LSN(11), msg.LSN)
+	assert.Equal(t, "bust", msg.Content)
+
+	assert.Eventually(t, func() bool {
+		return r.Status().BarrierLSN == 11
+	}, time.Second, time.Millisecond)
+
+	st := r.Status()
+	assert.True(t, st.Maintenance)
+	assert.Equal(t, "test-1", st.Barrier)
+	assert.Empty(t, got)
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/jackc/pglogrepl"
@@ -15,16 +16,49 @@ import (
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"github.com/zknill/sqledge/pkg/feed"
+	"github.com/zknill/sqledge/pkg/hooks"
+	"github.com/zknill/sqledge/pkg/metrics"
 	"github.com/zknill/sqledge/pkg/sqlgen"
 	"github.com/zknill/sqledge/pkg/tables"
+	"github.com/zknill/sqledge/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = tracing.Tracer("replicate")
+
 type Conn struct {
 	publication string
 	conn        *pgconn.PgConn
 	connStr     string
 
 	pos pglogrepl.LSN
+
+	// relation id -> table name, used to route
+	// changes to an in progress resync
+	relations map[uint32]string
+	resyncs   chan resyncRequest
+	verifies  chan verifyRequest
+
+	status *status
+
+	// feed is sent the transactions once they're committed locally
+	feed    *feed.Hub
+	changes *changes
+
+	// hooks queues the changes for the hooks in the
+	// transaction that applies them
+	hooks *hooks.Hooks
+
+	// messages handles the logical decoding messages
+	// once the changes before them are committed
+	messages *messages
+
+	pauseMu      sync.Mutex
+	paused       bool
+	pauseChanged chan struct{}
 }
 
 func NewConn(ctx context.Context, connString, publication string) (*Conn, error) {
@@ -34,9 +68,15 @@ func NewConn(ctx context.Context, connString, publication string) (*Conn, error)
 	}
 
 	c := &Conn{
-		publication: publication,
-		conn:        conn,
-		connStr:     connString,
+		publication:  publication,
+		conn:         conn,
+		connStr:      connString,
+		relations:    make(map[uint32]string),
+		changes:      newChanges(),
+		resyncs:      make(chan resyncRequest),
+		verifies:     make(chan verifyRequest),
+		status:       newStatus(),
+		pauseChanged: make(chan struct{}, 1),
 	}
 
 	if err := c.identify(); err != nil {
@@ -50,6 +90,27 @@ func (c *Conn) Close() error {
 	return c.conn.Close(context.Background())
 }
 
+// SetPaused pauses or resumes applying changes. The stream
+// pauses between upstream transactions, after committing the
+// batch, and keeps the replication connection alive.
+func (c *Conn) SetPaused(paused bool) {
+	c.pauseMu.Lock()
+	c.paused = paused
+	c.pauseMu.Unlock()
+
+	select {
+	case c.pauseChanged <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Conn) isPaused() bool {
+	c.pauseMu.Lock()
+	defer c.pauseMu.Unlock()
+
+	return c.paused
+}
+
 func (c *Conn) DropPublication() error {
 	// TODO: care for injection
 	result := c.conn.Exec(context.Background(), fmt.Sprintf("DROP PUBLICATION IF EXISTS %s;", c.publication))
@@ -79,20 +140,36 @@ type SlotConfig struct {
 	CreateSlotIfNoExists bool
 	Temporary            bool
 	Schema               string
+
+	IndexSyncInterval time.Duration
+	LocalIndexes      []string
+
+	// SequenceSyncInterval is how often the upstream
+	// sequences are read, zero only reads them on start.
+	SequenceSyncInterval time.Duration
+
+	Batch BatchConfig
+
+	// DrainTimeout bounds how long the upstream transaction being
+	// applied gets to finish when the stream is stopped.
+	DrainTimeout time.Duration
 }
 
 type DBDriver interface {
 	Pos() (string, error)
 	Execute(query string) error
+	ExecuteStmt(stmt sqlgen.Stmt) error
+	Invalidate(relationID uint32)
+	Indexes() (map[string]string, error)
 }
 
 type SQLGen interface {
 	Relation(*pglogrepl.RelationMessageV2) (string, error)
 	Begin(*pglogrepl.BeginMessage) (string, error)
 	Commit(*pglogrepl.CommitMessage) (string, error)
-	Insert(*pglogrepl.InsertMessageV2) (string, error)
-	Update(*pglogrepl.UpdateMessageV2) (string, error)
-	Delete(*pglogrepl.DeleteMessageV2) (string, error)
+	InsertStmt(*pglogrepl.InsertMessageV2) (sqlgen.Stmt, error)
+	UpdateStmt(*pglogrepl.UpdateMessageV2) (sqlgen.Stmt, error)
+	DeleteStmt(*pglogrepl.DeleteMessageV2) (sqlgen.Stmt, error)
 	Truncate(*pglogrepl.TruncateMessageV2) (string, error)
 	StreamStart(*pglogrepl.StreamStartMessageV2) (string, error)
 	StreamStop(*pglogrepl.StreamStopMessageV2) (string, error)
@@ -101,13 +178,25 @@ type SQLGen interface {
 
 	Pos(p string) string
 	CopyCreateTable(schema, tableName string, colDefs []sqlgen.ColDef) (string, error)
-	InsertCopyRow(schema, tableName string, colDefs []sqlgen.ColDef, rowValues []string) (string, error)
+	InsertCopyRow(schema, tableName string, colDefs []sqlgen.ColDef, rowValues []any) (string, error)
+
+	CreateIndex(idx sqlgen.IndexDef) (string, error)
+	TrackIndex(name, ddl string) string
+	DropIndex(name string) string
+
+	Sequences(defs []sqlgen.SequenceDef) string
+
+	DropTable(table string) string
+	RenameTable(from, to string) string
+	RenameColumn(table, from, to string) string
 }
 
-func (c *Conn) Stream(ctx context.Context, cfg SlotConfig, d DBDriver, gen SQLGen) error {
+// prepare finds the starting position, builds the slot, runs the
+// initial copy if nothing has been copied yet, and reads the sequences.
+func (c *Conn) prepare(ctx context.Context, cfg SlotConfig, d DBDriver, gen SQLGen) (*slot, bool, error) {
 	pos, err := d.Pos()
 	if err != nil {
-		return fmt.Errorf("find starting pos: %w", err)
+		return nil, false, fmt.Errorf("find starting pos: %w", err)
 	}
 
 	if pos != "" {
@@ -118,72 +207,411 @@ func (c *Conn) Stream(ctx context.Context, cfg SlotConfig, d DBDriver, gen SQLGe
 		case errors.Is(err, sql.ErrNoRows):
 			// no op
 		case err != nil:
-			return fmt.Errorf("parse pos: %w", err)
+			return nil, false, fmt.Errorf("parse pos: %w", err)
 
 		}
 	}
 
-	slot, err := c.slot(cfg.SlotName, cfg.OutputPlugin, cfg.CreateSlotIfNoExists, cfg.Temporary, c.pos)
+	slot, err := c.slot(cfg.SlotName, cfg.OutputPlugin, cfg.CreateSlotIfNoExists, cfg.Temporary, pos == "")
 	if err != nil {
-		return fmt.Errorf("build slot: %w", err)
+		return nil, false, fmt.Errorf("build slot: %w", err)
 	}
 
+	c.status.update(func(s *Status) {
+		s.Slot = cfg.SlotName
+		s.Publication = c.publication
+		s.Copied = false
+	})
+
 	if pos == "" {
 		log.Debug().Msg("starting copy")
 
 		if err := c.initialCopy(ctx, cfg.Schema, slot.startSnapshot, d, gen); err != nil {
-			return fmt.Errorf("copy: %w", err)
+			return nil, false, fmt.Errorf("copy: %w", err)
 		}
 
 		log.Debug().Msg("finished copy")
 
 		if err := d.Execute(gen.Pos(c.pos.String())); err != nil {
-			return fmt.Errorf("track position after copy: %w", err)
+			return nil, false, fmt.Errorf("track position after copy: %w", err)
 		}
 	}
 
+	// currval is served from the last synced values until
+	// the next sync, so replication doesn't wait for them
+	if seqs, err := upstreamSequences(c.connStr, cfg.Schema); err != nil {
+		log.Warn().Err(err).Msg("load sequences")
+	} else if _, err := syncSequences(seqs, d, gen); err != nil {
+		log.Warn().Err(err).Msg("sync sequences")
+	}
+
+	c.status.update(func(s *Status) { s.Copied = true })
+
+	return slot, pos == "", nil
+}
+
+func (c *Conn) Stream(ctx context.Context, cfg SlotConfig, d DBDriver, gen SQLGen) error {
+	slot, _, err := c.prepare(ctx, cfg, d, gen)
+	if err != nil {
+		return err
+	}
+
+	localIndexes := map[string]bool{}
+
+	upstream, err := upstreamIndexes(c.connStr, cfg.Schema)
+	if err != nil {
+		return fmt.Errorf("load indexes: %w", err)
+	}
+
+	synced, err := syncIndexes(upstream, d, gen)
+	if err != nil {
+		return fmt.Errorf("sync indexes: %w", err)
+	}
+
+	createLocalIndexes(cfg.LocalIndexes, localIndexes, d)
+
 	log.Debug().Msgf("starting slot from pos: %q", c.pos)
 
 	if err := slot.start(ctx); err != nil {
 		return fmt.Errorf("start slot: %w", err)
 	}
+	defer slot.close()
+
+	c.status.update(func(s *Status) {
+		s.Streaming = true
+		s.CurrentLSN = c.pos
+	})
+
+	if c.feed != nil {
+		c.feed.Start(c.pos)
+	}
+	defer c.status.update(func(s *Status) { s.Streaming = false })
 
 	var (
 		logicalMsg pglogrepl.Message
-		query      string
+
+		// commit LSN of the transaction being applied
+		txLSN   pglogrepl.LSN
+		txStart time.Time
+		txSpan  trace.Span
+		txRows  int
+		inTx    bool
+
+		// statements the transaction was applied with, and the
+		// transactions in the batch, sent to the feed once the
+		// batch is committed
+		txStmts    []string
+		txChanges  []feed.Change
+		txMessages []feed.Message
+		pending    []feed.Tx
+
+		done     = ctx.Done()
+		stopping bool
+		drained  <-chan time.Time
+
+		paused = c.isPaused()
+
+		active   *resync
+		resynced = make(chan *resyncResult, 1)
+		swapped  = swapped{}
+
+		// verify the stream is being held for, and the
+		// upstream transaction held back while it reads
+		verifying *verifyRequest
+		held      pglogrepl.Message
+		holding   bool
+
+		indexTick      <-chan time.Time
+		indexSyncing   bool
+		pendingIndexes *indexResult
+		indexes        = make(chan *indexResult, 1)
+
+		sequenceTick     <-chan time.Time
+		sequenceSyncing  bool
+		pendingSequences *sequenceResult
+		sequences        = make(chan *sequenceResult, 1)
+		storedSequences  string
 	)
 
-	stream := slot.stream()
+	if cfg.IndexSyncInterval > 0 {
+		ticker := time.NewTicker(cfg.IndexSyncInterval)
+		defer ticker.Stop()
 
-	for {
-		select {
-		case <-ctx.Done():
-			slot.close()
-			return ctx.Err()
-		case <-slot.errs:
-			return fmt.Errorf("slot error: %w", err)
-		case logicalMsg = <-stream:
+		indexTick = ticker.C
+	}
+
+	if cfg.SequenceSyncInterval > 0 {
+		ticker := time.NewTicker(cfg.SequenceSyncInterval)
+		defer ticker.Stop()
+
+		sequenceTick = ticker.C
+	}
+
+	// relayLocal sends the statements applied between upstream
+	// transactions to the feed, at the position of the last one, so
+	// children relaying from this one apply them too
+	relayLocal := func(statements []string) {
+		if c.feed == nil || len(statements) == 0 {
+			return
+		}
+
+		lsn := c.feed.Latest()
+		if len(pending) > 0 {
+			lsn = pending[len(pending)-1].LSN
+		}
+
+		pending = append(pending, feed.Tx{
+			LSN:        lsn,
+			CommitTime: time.Now(),
+			Statements: statements,
+			Local:      true,
+		})
+	}
+
+	relayLocal(synced)
+
+	applyIndexes := func(res *indexResult) []string {
+		indexSyncing = false
+
+		if res.err != nil {
+			log.Warn().Err(res.err).Msg("load upstream indexes")
+			return nil
+		}
+
+		synced, err := syncIndexes(res.defs, d, gen)
+		if err != nil {
+			log.Warn().Err(err).Msg("sync indexes")
+		}
+
+		// edge only indexes aren't relayed
+		createLocalIndexes(cfg.LocalIndexes, localIndexes, d)
+
+		return synced
+	}
+
+	syncIndexesAsync := func() {
+		if indexSyncing {
+			return
+		}
+
+		indexSyncing = true
+
+		go func() {
+			defs, err := upstreamIndexes(c.connStr, cfg.Schema)
+			indexes <- &indexResult{defs: defs, err: err}
+		}()
+	}
+
+	applySequences := func(res *sequenceResult) []string {
+		sequenceSyncing = false
+
+		if res.err != nil {
+			log.Warn().Err(res.err).Msg("load upstream sequences")
+			return nil
+		}
+
+		query, err := syncSequences(res.defs, d, gen)
+		if err != nil {
+			log.Warn().Err(err).Msg("sync sequences")
+			return nil
+		}
+
+		// only relayed when they've changed since the last sync
+		if query == storedSequences {
+			return nil
+		}
+
+		storedSequences = query
+
+		return []string{query}
+	}
+
+	syncSequencesAsync := func() {
+		if sequenceSyncing {
+			return
+		}
+
+		sequenceSyncing = true
+
+		go func() {
+			defs, err := upstreamSequences(c.connStr, cfg.Schema)
+			sequences <- &sequenceResult{defs: defs, err: err}
+		}()
+	}
+
+	finishResync := func() []string {
+		statements, err := c.finishResync(active, cfg.Schema, d, gen)
+		if err == nil {
+			swapped[active.req.table] = active.result.snapshot
+		}
+
+		active.req.done <- err
+		active = nil
+
+		// the indexes were dropped with the table
+		syncIndexesAsync()
+
+		return statements
+	}
+
+	b := newBatch(cfg.Batch)
+
+	// idle runs the work waiting for the gap between
+	// upstream transactions, after committing the batch
+	idle := func() error {
+		if pendingIndexes == nil && pendingSequences == nil && (active == nil || active.result == nil) {
+			return nil
+		}
+
+		if err := d.Execute(b.flush()); err != nil {
+			return fmt.Errorf("commit batch: %w", err)
 		}
 
+		var statements []string
+
+		if pendingIndexes != nil {
+			statements = append(statements, applyIndexes(pendingIndexes)...)
+			pendingIndexes = nil
+		}
+
+		if pendingSequences != nil {
+			statements = append(statements, applySequences(pendingSequences)...)
+			pendingSequences = nil
+		}
+
+		if active != nil && active.result != nil {
+			statements = append(statements, finishResync()...)
+		}
+
+		relayLocal(statements)
+
+		return nil
+	}
+
+	apply := func(logicalMsg pglogrepl.Message) error {
+		var (
+			query string
+			stmt  sqlgen.Stmt
+			err   error
+
+			// table changed by this message, if any
+			table string
+			op    string
+
+			// the change is already in a resynced table's copy, so
+			// it's only relayed, and local is applied instead
+			skip  bool
+			local string
+		)
+
+		metrics.MessagesReceived.WithLabelValues(strings.ToLower(logicalMsg.Type().String())).Inc()
+
 		switch logicalMsg := logicalMsg.(type) {
 		case *pglogrepl.RelationMessageV2:
+			c.relations[logicalMsg.RelationID] = logicalMsg.RelationName
+			c.changes.relation(logicalMsg)
+			c.status.addTable(logicalMsg.RelationName)
+			d.Invalidate(logicalMsg.RelationID)
+			table = logicalMsg.RelationName
 			query, err = gen.Relation(logicalMsg)
 		case *pglogrepl.BeginMessage:
-			query, err = gen.Begin(logicalMsg)
+			txLSN, inTx = logicalMsg.FinalLSN, true
+			swapped.passed(txLSN)
+			txStart, txRows = time.Now(), 0
+
+			txStmts, txChanges, txMessages = nil, nil, nil
+
+			_, txSpan = tracer.Start(ctx, "replicate.transaction", trace.WithAttributes(
+				attribute.String("sqledge.lsn", txLSN.String()),
+				attribute.Int64("sqledge.xid", int64(logicalMsg.Xid)),
+			))
+			query = b.begin()
 		case *pglogrepl.CommitMessage:
-			query, err = gen.Commit(logicalMsg)
+			inTx = false
+
+			tx := feed.Tx{
+				LSN:        txLSN,
+				CommitTime: logicalMsg.CommitTime,
+				Statements: txStmts,
+				Changes:    txChanges,
+				Messages:   txMessages,
+			}
+
+			var outbox string
+
+			outbox, err = c.hooks.Outbox(tx)
+			query = b.commit(outbox + gen.Pos(txLSN.String()))
+
+			pending = append(pending, tx)
+			txStmts, txChanges, txMessages = nil, nil, nil
+
+			c.status.update(func(s *Status) {
+				s.CurrentLSN = txLSN
+				s.LastCommitTime = logicalMsg.CommitTime
+				s.Lag = time.Since(logicalMsg.CommitTime)
+
+				metrics.LagSeconds.Set(s.Lag.Seconds())
+				metrics.LagBytes.Set(s.lagBytes())
+			})
 		case *pglogrepl.InsertMessageV2:
-			query, err = gen.Insert(logicalMsg)
+			table, op = c.relations[logicalMsg.RelationID], "insert"
+			stmt, err = gen.InsertStmt(logicalMsg)
 		case *pglogrepl.UpdateMessageV2:
-			query, err = gen.Update(logicalMsg)
+			table, op = c.relations[logicalMsg.RelationID], "update"
+			stmt, err = gen.UpdateStmt(logicalMsg)
 		case *pglogrepl.DeleteMessageV2:
-			query, err = gen.Delete(logicalMsg)
+			table, op = c.relations[logicalMsg.RelationID], "delete"
+			stmt, err = gen.DeleteStmt(logicalMsg)
 		case *pglogrepl.TruncateMessageV2:
 			query, err = gen.Truncate(logicalMsg)
+
+			if kept, ok := swapped.truncate(logicalMsg, c.relations, txLSN); ok && err == nil {
+				skip = true
+				local, err = gen.Truncate(kept)
+			}
+
+			if active != nil {
+				for _, id := range logicalMsg.RelationIDs {
+					if c.relations[id] == active.req.table {
+						active.buffered = append(active.buffered, bufferedQuery{
+							lsn:   txLSN,
+							query: fmt.Sprintf("DELETE FROM %s;", active.req.table),
+						})
+					}
+				}
+			}
 		case *pglogrepl.TypeMessageV2:
 		case *pglogrepl.OriginMessage:
 		case *pglogrepl.LogicalDecodingMessageV2:
 			log.Debug().Msgf("Logical decoding message: %q, %q, %d", logicalMsg.Prefix, logicalMsg.Content, logicalMsg.Xid)
+
+			msg := feed.Message{
+				Prefix:        logicalMsg.Prefix,
+				Content:       string(logicalMsg.Content),
+				Transactional: logicalMsg.Transactional,
+			}
+
+			if logicalMsg.Transactional && logicalMsg.Prefix == ddlPrefix {
+				// applied in order with the transaction's changes
+				var syncIdx bool
+
+				query, syncIdx, err = c.ddl(logicalMsg.Content, cfg.Schema, d, gen)
+				if syncIdx {
+					syncIndexesAsync()
+				}
+			}
+
+			if logicalMsg.Transactional {
+				txMessages = append(txMessages, msg)
+			} else {
+				// handled in order with the transactions
+				// around it, at its own position
+				pending = append(pending, feed.Tx{
+					LSN:        logicalMsg.LSN,
+					CommitTime: time.Now(),
+					Messages:   []feed.Message{msg},
+				})
+			}
 		case *pglogrepl.StreamStartMessageV2:
 			query, err = gen.StreamStart(logicalMsg)
 		case *pglogrepl.StreamStopMessageV2:
@@ -194,22 +622,357 @@ func (c *Conn) Stream(ctx context.Context, cfg SlotConfig, d DBDriver, gen SQLGe
 			query, err = gen.StreamAbort(logicalMsg)
 		default:
 			log.Debug().Msgf("Unknown message type in pgoutput stream: %T", logicalMsg)
+			return nil
+		}
+
+		if err != nil {
+			endTxSpan(txSpan, err)
+			return fmt.Errorf("generate sql: %w", err)
+		}
+
+		if op != "" && swapped.skip(table, txLSN) {
+			skip = true
+		}
+
+		if skip {
+			log.Debug().Msgf("skip change at %s, already in the resynced copy", txLSN)
+
+			if local != "" {
+				if err = d.Execute(local); err != nil {
+					endTxSpan(txSpan, err)
+					return fmt.Errorf("apply sql: %w", err)
+				}
+			}
+		} else if stmt.SQL != "" {
+			log.Debug().Msg(stmt.SQL)
+
+			if err = d.ExecuteStmt(stmt); err != nil {
+				endTxSpan(txSpan, err)
+				return fmt.Errorf("apply sql: %w", err)
+			}
+
+			b.applied(stmt.Size())
+		} else {
+			log.Debug().Msg(query)
+
+			if err = d.Execute(query); err != nil {
+				endTxSpan(txSpan, err)
+				return fmt.Errorf("apply sql: %w", err)
+			}
+
+			b.applied(len(query))
+		}
+
+		if op != "" && stmt.SQL != "" && !skip {
+			metrics.Rows.WithLabelValues(table, op).Inc()
+			txRows++
+		}
+
+		switch logicalMsg.(type) {
+		case *pglogrepl.BeginMessage, *pglogrepl.CommitMessage:
+		default:
+			switch {
+			case skip:
+				// children got the copy with the swap
+				if local != "" {
+					txStmts = append(txStmts, local)
+				}
+			case stmt.SQL != "":
+				txStmts = append(txStmts, stmt.String())
+			case query != "":
+				txStmts = append(txStmts, query)
+			}
+
+			// skipped row changes aren't sent
+			if op == "" || stmt.SQL != "" {
+				txChanges = append(txChanges, c.changes.decode(logicalMsg)...)
+			}
+		}
+
+		if _, ok := logicalMsg.(*pglogrepl.CommitMessage); ok {
+			metrics.ApplyDuration.Observe(time.Since(txStart).Seconds())
+
+			if txSpan != nil {
+				txSpan.SetAttributes(attribute.Int("sqledge.rows", txRows))
+				txSpan.End()
+				txSpan = nil
+			}
+		}
+
+		if active != nil && table != "" && table == active.req.table {
+			if stmt.SQL != "" {
+				query = stmt.String()
+			}
+
+			active.buffered = append(active.buffered, bufferedQuery{lsn: txLSN, query: query})
+		}
+
+		return nil
+	}
+
+	// publish sends the transactions in the
+	// batch to the feed, once it's committed
+	publish := func() {
+		if b.open || len(pending) == 0 {
+			return
+		}
+
+		slot.commit(pending[len(pending)-1].LSN)
+
+		if c.feed != nil {
+			c.feed.Publish(pending...)
+		}
+
+		if c.messages != nil {
+			if err := c.messages.enqueue(ctx, pending...); err != nil {
+				log.Warn().Err(err).Msg("queue logical decoding messages")
+			}
+		}
+
+		pending = nil
+	}
+
+	stop := func() error {
+		if active != nil {
+			active.req.done <- ctx.Err()
+		}
+
+		err := c.stop(ctx, slot, d, b)
+		if err == nil || errors.Is(err, ctx.Err()) {
+			publish()
+		}
+
+		return err
+	}
+
+	defer func() {
+		if verifying != nil && !holding {
+			verifying.reached <- ErrNotStreaming
+		}
+	}()
+
+	stream := slot.stream()
+
+	c.status.update(func(s *Status) { s.Paused = paused })
+
+	for {
+		publish()
+
+		// only pause between upstream transactions
+		msgs := stream
+		if paused && !inTx || holding {
+			msgs = nil
+		}
+
+		var verifyDone <-chan struct{}
+		if verifying != nil {
+			verifyDone = verifying.done
+		}
+
+		select {
+		case <-done:
+			if !inTx {
+				return stop()
+			}
+
+			// finish applying the upstream transaction, so
+			// that the batch can be committed
+			log.Info().Msgf("stopping after upstream transaction %s", txLSN)
+
+			done, stopping = nil, true
+			drained = time.After(cfg.DrainTimeout)
+
+			continue
+		case <-drained:
+			log.Warn().Msgf("upstream transaction %s not finished, rolling back", txLSN)
+
+			endTxSpan(txSpan, errors.New("rolled back on stop"))
+
+			slot.close()
+
+			if err := d.Execute(b.rollback()); err != nil {
+				return fmt.Errorf("rollback batch: %w", err)
+			}
+
+			return ctx.Err()
+		case err := <-slot.errs:
+			return fmt.Errorf("slot error: %w", err)
+		case req := <-c.resyncs:
+			if stopping {
+				req.done <- ctx.Err()
+				continue
+			}
+
+			if active != nil {
+				req.done <- ErrResyncInProgress
+				continue
+			}
+
+			log.Debug().Msgf("starting resync of %q", req.table)
+
+			active = &resync{req: req}
+
+			go func() {
+				resynced <- c.snapshotTable(ctx, cfg, req.table)
+			}()
+
+			continue
+		case req := <-c.verifies:
+			applied := txLSN
+			if applied == 0 {
+				applied = c.pos
+			}
+
+			switch {
+			case stopping:
+				req.reached <- ctx.Err()
+			case verifying != nil:
+				req.reached <- ErrVerifyInProgress
+			case applied >= req.lsn:
+				req.reached <- errVerifyPassed
+			default:
+				verifying = &req
+			}
+
+			continue
+		case <-verifyDone:
+			verifying, holding = nil, false
+
+			if held != nil {
+				logicalMsg, held = held, nil
+
+				if err := apply(logicalMsg); err != nil {
+					return err
+				}
+			}
+		case <-indexTick:
+			syncIndexesAsync()
+
+			continue
+		case res := <-indexes:
+			pendingIndexes = res
+		case <-sequenceTick:
+			syncSequencesAsync()
+
 			continue
+		case res := <-sequences:
+			pendingSequences = res
+		case res := <-resynced:
+			active.result = res
+		case <-b.expire():
+			b.expired = true
+
+			if !inTx {
+				if err := d.Execute(b.flush()); err != nil {
+					return fmt.Errorf("commit batch: %w", err)
+				}
+			}
+		case <-c.pauseChanged:
+			paused = c.isPaused()
+
+			log.Info().Msgf("replication paused: %t", paused)
+
+			c.status.update(func(s *Status) { s.Paused = paused })
+		case logicalMsg = <-msgs:
+			// hold the first transaction the verify's
+			// snapshot doesn't include, until it has read
+			if begin, ok := logicalMsg.(*pglogrepl.BeginMessage); ok && verifying != nil && begin.FinalLSN >= verifying.lsn {
+				if err := d.Execute(b.flush()); err != nil {
+					return fmt.Errorf("commit batch: %w", err)
+				}
+
+				held, holding = logicalMsg, true
+				verifying.reached <- nil
+
+				continue
+			}
+
+			if err := apply(logicalMsg); err != nil {
+				return err
+			}
 		}
 
-		log.Debug().Msg(query)
+		if inTx || holding {
+			continue
+		}
 
+		if stopping {
+			return stop()
+		}
+
+		if err := idle(); err != nil {
+			return err
+		}
+
+		if paused {
+			if err := d.Execute(b.flush()); err != nil {
+				return fmt.Errorf("commit batch: %w", err)
+			}
+		}
+	}
+}
+
+// stop commits the open batch, and confirms the
+// position it committed to the upstream.
+func (c *Conn) stop(ctx context.Context, slot *slot, d DBDriver, b *batch) error {
+	slot.close()
+
+	if err := d.Execute(b.flush()); err != nil {
+		return fmt.Errorf("commit batch: %w", err)
+	}
+
+	pos, err := d.Pos()
+	if err != nil {
+		return fmt.Errorf("find committed pos: %w", err)
+	}
+
+	if pos != "" {
+		lsn, err := pglogrepl.ParseLSN(pos)
 		if err != nil {
-			return fmt.Errorf("generate sql: %w", err)
+			return fmt.Errorf("parse pos: %w", err)
 		}
 
-		if err = d.Execute(query); err != nil {
-			return fmt.Errorf("apply sql: %w", err)
+		if err := slot.confirm(lsn); err != nil {
+			return fmt.Errorf("final status update: %w", err)
 		}
+
+		log.Info().Msgf("stopped at %s", lsn)
 	}
+
+	return ctx.Err()
 }
 
-func (c *Conn) slot(slotName, outputPlugin string, createSlot, temporary bool, pos pglogrepl.LSN) (*slot, error) {
+func endTxSpan(span trace.Span, err error) {
+	if span == nil {
+		return
+	}
+
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+	span.End()
+}
+
+func (c *Conn) finishResync(r *resync, schema string, d DBDriver, gen SQLGen) ([]string, error) {
+	if r.result.err != nil {
+		return nil, fmt.Errorf("resync %q: %w", r.req.table, r.result.err)
+	}
+
+	statements, err := r.swap(schema, d, gen)
+	if err != nil {
+		return nil, fmt.Errorf("resync %q: %w", r.req.table, err)
+	}
+
+	return statements, nil
+}
+
+// ErrSlotLost is returned when there's a local position to stream
+// from, but the slot that kept the changes after it doesn't exist,
+// like a temporary slot after a restart.
+var ErrSlotLost = errors.New("slot no longer exists, use a permanent slot, or reset the local database to copy it again")
+
+// slot reuses the slot if it exists, unless it's needed for the
+// snapshot to copy from, or else creates it for the copy.
+func (c *Conn) slot(slotName, outputPlugin string, createSlot, temporary, copying bool) (*slot, error) {
 	pluginArguments := []string{
 		"proto_version '2'",
 		fmt.Sprintf("publication_names '%s'", c.publication),
@@ -218,14 +981,29 @@ func (c *Conn) slot(slotName, outputPlugin string, createSlot, temporary bool, p
 	}
 
 	s := &slot{
-		conn: c.conn,
-		args: pluginArguments,
-		name: slotName,
-		pos:  c.pos,
+		conn:    c.conn,
+		args:    pluginArguments,
+		name:    slotName,
+		pos:     c.pos,
+		flushed: c.pos,
+		status:  c.status,
+	}
+
+	exists, err := c.slotExists(slotName)
+	if err != nil {
+		return nil, err
 	}
 
-	// TODO: automatically work out if slot exists
-	if createSlot {
+	switch {
+	case exists && copying:
+		return nil, fmt.Errorf("slot %q exists without a local position to stream from, reset it first", slotName)
+	case exists:
+		log.Debug().Msgf("slot %q exists, reusing it", slotName)
+	case !copying:
+		// a new slot starts at the current WAL position, the
+		// changes since the local position would be skipped
+		return nil, fmt.Errorf("slot %q to stream from %s: %w", slotName, c.pos, ErrSlotLost)
+	case createSlot:
 		res, err := pglogrepl.CreateReplicationSlot(
 			context.Background(),
 			c.conn,
@@ -243,6 +1021,20 @@ func (c *Conn) slot(slotName, outputPlugin string, createSlot, temporary bool, p
 	return s, nil
 }
 
+func (c *Conn) slotExists(slotName string) (bool, error) {
+	result := c.conn.Exec(context.Background(), fmt.Sprintf(
+		"SELECT 1 FROM pg_replication_slots WHERE slot_name = '%s';",
+		strings.ReplaceAll(slotName, "'", "''"),
+	))
+
+	results, err := result.ReadAll()
+	if err != nil {
+		return false, fmt.Errorf("find slot: %w", err)
+	}
+
+	return len(results) > 0 && len(results[0].Rows) > 0, nil
+}
+
 func (c *Conn) identify() error {
 	sysident, err := pglogrepl.IdentifySystem(context.Background(), c.conn)
 	if err != nil {
@@ -253,13 +1045,14 @@ func (c *Conn) identify() error {
 	return nil
 }
 
-func tableColDefs(connStr, schema string) (map[string][]sqlgen.ColDef, error) {
+func tableColDefs(connStr, schema string, filterTables []string) (map[string][]sqlgen.ColDef, error) {
 	db, err := sql.Open("pgx", strings.Replace(connStr, "replication=database", "", 1))
 	if err != nil {
 		return nil, fmt.Errorf("open connection: %w", err)
 	}
+	defer db.Close()
 
-	defs, err := tables.TableColDefs(db, schema, nil)
+	defs, err := tables.TableColDefs(db, schema, filterTables)
 	if err != nil {
 		return nil, fmt.Errorf("load col definitions: %w", err)
 	}
@@ -272,7 +1065,17 @@ func (c *Conn) initialCopy(ctx context.Context, schema, snapshotName string, dst
 		return fmt.Errorf("cannot copy for empty schema")
 	}
 
-	defs, err := tableColDefs(c.connStr, schema)
+	ctx, span := tracer.Start(ctx, "replicate.initial_copy")
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+
+		span.End()
+	}()
+
+	defs, err := tableColDefs(c.connStr, schema, nil)
 	if err != nil {
 		return fmt.Errorf("load col defs: %w", err)
 	}
@@ -282,15 +1085,11 @@ func (c *Conn) initialCopy(ctx context.Context, schema, snapshotName string, dst
 		return fmt.Errorf("pgconnect: %w", err)
 	}
 
-	query := `BEGIN TRANSACTION ISOLATION LEVEL REPEATABLE READ READ ONLY;`
-	if snapshotName != "" {
-		query += fmt.Sprintf("SET TRANSACTION SNAPSHOT '%s';", snapshotName)
+	if err := beginSnapshot(ctx, copyConn, snapshotName); err != nil {
+		copyConn.Close(ctx)
+		return fmt.Errorf("begin snapshot: %w", err)
 	}
 
-	log.Debug().Msg(query)
-
-	copyConn.Exec(ctx, query).Close()
-
 	defer func() {
 		defer copyConn.Close(ctx)
 
@@ -309,37 +1108,82 @@ func (c *Conn) initialCopy(ctx context.Context, schema, snapshotName string, dst
 		log.Debug().Msg("COMMIT")
 	}()
 
+	metrics.CopyTables.Set(float64(len(defs)))
+	metrics.CopyTablesDone.Set(0)
+
 	for table, columns := range defs {
-		var (
-			query string
-			vals  [][]string
-		)
+		if err = c.copyTable(ctx, schema, table, columns, copyConn, dst, gen); err != nil {
+			return err
+		}
+	}
 
-		query, err = gen.CopyCreateTable(schema, table, columns)
+	return nil
+}
 
-		if err = dst.Execute(query); err != nil {
-			return fmt.Errorf("execute inital copy: %w", err)
+func (c *Conn) copyTable(ctx context.Context, schema, table string, columns []sqlgen.ColDef, copyConn *pgconn.PgConn, dst DBDriver, gen SQLGen) (err error) {
+	ctx, span := tracer.Start(ctx, "replicate.copy_table", trace.WithAttributes(
+		attribute.String("sqledge.table", table),
+	))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
 		}
 
-		log.Debug().Msg(query)
-		vals, err = tables.Copy(ctx, table, columns, copyConn)
+		span.End()
+	}()
+
+	c.status.addTable(table)
+
+	query, err := gen.CopyCreateTable(schema, table, columns)
+	if err != nil {
+		return fmt.Errorf("generate sql: %w", err)
+	}
+
+	if err = dst.Execute(query); err != nil {
+		return fmt.Errorf("execute inital copy: %w", err)
+	}
+
+	log.Debug().Msg(query)
+
+	vals, err := tables.Copy(ctx, table, columns, copyConn)
+	if err != nil {
+		return fmt.Errorf("copy table: %w", err)
+	}
+
+	for _, row := range vals {
+		query, err = gen.InsertCopyRow(schema, table, columns, row)
 		if err != nil {
-			return fmt.Errorf("copy table: %w", err)
+			return fmt.Errorf("generate sql: %w", err)
+		}
 
+		log.Debug().Msg(query)
+
+		if err = dst.Execute(query); err != nil {
+			return fmt.Errorf("execute inital copy: %w", err)
 		}
+	}
 
-		for _, row := range vals {
-			query, err = gen.InsertCopyRow(schema, table, columns, row)
-			if err != nil {
-				return fmt.Errorf("generate sql: %w", err)
-			}
+	span.SetAttributes(attribute.Int("sqledge.rows", len(vals)))
 
-			log.Debug().Msg(query)
+	metrics.CopyRows.WithLabelValues(table).Add(float64(len(vals)))
+	metrics.CopyTablesDone.Inc()
 
-			if err = dst.Execute(query); err != nil {
-				return fmt.Errorf("execute inital copy: %w", err)
-			}
-		}
+	return nil
+}
+
+// beginSnapshot starts a read only transaction on the conn,
+// using the exported snapshot if one is given.
+func beginSnapshot(ctx context.Context, conn *pgconn.PgConn, snapshotName string) error {
+	query := `BEGIN TRANSACTION ISOLATION LEVEL REPEATABLE READ READ ONLY;`
+	if snapshotName != "" {
+		query += fmt.Sprintf("SET TRANSACTION SNAPSHOT '%s';", snapshotName)
+	}
+
+	log.Debug().Msg(query)
+
+	if _, err := conn.Exec(ctx, query).ReadAll(); err != nil {
+		return err
 	}
 
 	return nil
@@ -353,9 +1197,20 @@ type slot struct {
 	pos           pglogrepl.LSN
 	startSnapshot string
 
-	msgs chan pglogrepl.Message
-	errs chan error
-	done chan struct{}
+	// flushed is the last position committed locally, the
+	// upstream can discard the WAL before it
+	mu      sync.Mutex
+	flushed pglogrepl.LSN
+
+	status *status
+
+	msgs    chan pglogrepl.Message
+	errs    chan error
+	done    chan struct{}
+	stopped chan struct{}
+
+	// cancel interrupts a pending receive
+	cancel context.CancelFunc
 }
 
 func (s *slot) start(ctx context.Context) error {
@@ -378,8 +1233,12 @@ func (s *slot) start(ctx context.Context) error {
 	s.msgs = make(chan pglogrepl.Message)
 	s.errs = make(chan error)
 	s.done = make(chan struct{})
+	s.stopped = make(chan struct{})
+
+	listenCtx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
 
-	go s.listen()
+	go s.listen(listenCtx)
 
 	return nil
 }
@@ -392,7 +1251,9 @@ func (s *slot) stream() <-chan pglogrepl.Message {
 	return s.msgs
 }
 
-func (s *slot) listen() {
+func (s *slot) listen(listenCtx context.Context) {
+	defer close(s.stopped)
+
 	standbyMessageTimeout := time.Second * 10
 	nextStandbyMessageDeadline := time.Now().Add(standbyMessageTimeout)
 
@@ -406,24 +1267,19 @@ func (s *slot) listen() {
 		}
 
 		if time.Now().After(nextStandbyMessageDeadline) {
-			log.Trace().Msg("status heartbeat")
-			err := pglogrepl.SendStandbyStatusUpdate(
-				context.Background(),
-				s.conn,
-				pglogrepl.StandbyStatusUpdate{WALWritePosition: s.pos},
-			)
-			if err != nil {
-				go s.sendErr(err)
-			}
-
+			s.heartbeat()
 			nextStandbyMessageDeadline = time.Now().Add(standbyMessageTimeout)
 		}
 
-		ctx, cancel := context.WithDeadline(context.Background(), nextStandbyMessageDeadline)
+		ctx, cancel := context.WithDeadline(listenCtx, nextStandbyMessageDeadline)
 
 		rawMsg, err := s.conn.ReceiveMessage(ctx)
 		cancel()
 		if err != nil {
+			if listenCtx.Err() != nil {
+				return
+			}
+
 			if pgconn.Timeout(err) {
 				continue
 			}
@@ -450,6 +1306,11 @@ func (s *slot) listen() {
 				continue
 			}
 
+			s.status.update(func(st *Status) {
+				st.ServerLSN = pkm.ServerWALEnd
+				metrics.LagBytes.Set(st.lagBytes())
+			})
+
 			if pkm.ReplyRequested {
 				nextStandbyMessageDeadline = time.Time{}
 			}
@@ -479,9 +1340,33 @@ func (s *slot) listen() {
 
 			log.Trace().Msg("sending logical message")
 
+			sent := false
+
 			select {
 			case s.msgs <- logicalMsg:
-			case <-s.done:
+				sent = true
+			default:
+				// the stream hasn't finished with the previous change
+				received := time.Now()
+				s.status.update(func(st *Status) { st.PendingSince = received })
+			}
+
+			// keep the connection alive while the stream is
+			// paused, or is slow to take the message
+			for !sent {
+				select {
+				case s.msgs <- logicalMsg:
+					sent = true
+				case <-s.done:
+					return
+				case <-time.After(time.Until(nextStandbyMessageDeadline)):
+					s.heartbeat()
+					nextStandbyMessageDeadline = time.Now().Add(standbyMessageTimeout)
+				}
+
+				if sent {
+					s.status.update(func(st *Status) { st.PendingSince = time.Time{} })
+				}
 			}
 
 			s.pos = xld.WALStart + pglogrepl.LSN(len(xld.WALData))
@@ -489,8 +1374,83 @@ func (s *slot) listen() {
 	}
 }
 
+func (s *slot) heartbeat() {
+	log.Trace().Msg("status heartbeat")
+
+	flushed := s.committed()
+
+	// the transactions received after the flushed position
+	// can still be in an open batch, and be rolled back
+	err := pglogrepl.SendStandbyStatusUpdate(
+		context.Background(),
+		s.conn,
+		pglogrepl.StandbyStatusUpdate{
+			WALWritePosition: s.pos,
+			WALFlushPosition: flushed,
+			WALApplyPosition: flushed,
+		},
+	)
+	if err != nil {
+		go s.sendErr(err)
+		return
+	}
+
+	s.status.update(func(st *Status) { st.ConfirmedLSN = flushed })
+}
+
+// commit records the position committed locally,
+// to confirm to the upstream in the next heartbeat.
+func (s *slot) commit(lsn pglogrepl.LSN) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if lsn > s.flushed {
+		s.flushed = lsn
+	}
+}
+
+func (s *slot) committed() pglogrepl.LSN {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.flushed
+}
+
+// close stops the listener, and waits for it to
+// finish using the connection.
 func (s *slot) close() error {
+	if s.msgs == nil {
+		return nil
+	}
+
+	select {
+	case <-s.done:
+		return nil
+	default:
+	}
+
 	close(s.done)
+	s.cancel()
+
+	<-s.stopped
+
+	return nil
+}
+
+// confirm sends a standby status update for the position
+// committed locally. It's only safe once the slot is closed.
+func (s *slot) confirm(lsn pglogrepl.LSN) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := pglogrepl.SendStandbyStatusUpdate(ctx, s.conn, pglogrepl.StandbyStatusUpdate{WALWritePosition: lsn}); err != nil {
+		return err
+	}
+
+	s.commit(lsn)
+
+	s.status.update(func(st *Status) { st.ConfirmedLSN = lsn })
+
 	return nil
 }
 
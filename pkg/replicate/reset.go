@@ -0,0 +1,92 @@
+package replicate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/zknill/sqledge/pkg/config"
+)
+
+var ErrSlotActive = errors.New("slot is in use, stop sqledge first")
+
+type ResetResult struct {
+	SlotDropped        bool     `json:"slot_dropped"`
+	PublicationDropped bool     `json:"publication_dropped"`
+	FilesRemoved       []string `json:"files_removed"`
+}
+
+// Reset drops the slot and publication upstream, and removes the
+// local database, so the next run starts with a fresh copy. It
+// refuses to drop a slot that's being streamed from.
+func Reset(ctx context.Context, cfg *config.Config) (*ResetResult, error) {
+	res := &ResetResult{FilesRemoved: []string{}}
+
+	// a relay child has no slot or publication of its own
+	if cfg.Relay.Parent != "" {
+		if err := removeLocal(cfg, res); err != nil {
+			return nil, err
+		}
+
+		return res, nil
+	}
+
+	db, err := sql.Open("pgx", cfg.PostgresConnString())
+	if err != nil {
+		return nil, fmt.Errorf("open upstream: %w", err)
+	}
+	defer db.Close()
+
+	var active bool
+
+	err = db.QueryRowContext(ctx, `SELECT active FROM pg_replication_slots WHERE slot_name = $1;`, cfg.Replication.SlotName).Scan(&active)
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return nil, fmt.Errorf("read slot: %w", err)
+	case active:
+		return nil, fmt.Errorf("drop slot %q: %w", cfg.Replication.SlotName, ErrSlotActive)
+	default:
+		if _, err := db.ExecContext(ctx, `SELECT pg_drop_replication_slot($1);`, cfg.Replication.SlotName); err != nil {
+			return nil, fmt.Errorf("drop slot: %w", err)
+		}
+
+		res.SlotDropped = true
+	}
+
+	if err := db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM pg_publication WHERE pubname = $1);`,
+		cfg.Replication.Publication).Scan(&res.PublicationDropped); err != nil {
+		return nil, fmt.Errorf("read publication: %w", err)
+	}
+
+	if res.PublicationDropped {
+		if _, err := db.ExecContext(ctx, fmt.Sprintf("DROP PUBLICATION IF EXISTS %s;", cfg.Replication.Publication)); err != nil {
+			return nil, fmt.Errorf("drop publication: %w", err)
+		}
+	}
+
+	if err := removeLocal(cfg, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func removeLocal(cfg *config.Config, res *ResetResult) error {
+	for _, path := range []string{cfg.Local.Path, cfg.Local.Path + "-wal", cfg.Local.Path + "-shm"} {
+		err := os.Remove(path)
+
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			return fmt.Errorf("remove local db: %w", err)
+		default:
+			res.FilesRemoved = append(res.FilesRemoved, path)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,235 @@
+package replicate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/rs/zerolog/log"
+	"github.com/zknill/sqledge/pkg/metrics"
+	"github.com/zknill/sqledge/pkg/sqlgen"
+	"github.com/zknill/sqledge/pkg/tables"
+)
+
+var ErrResyncInProgress = errors.New("resync already in progress")
+
+type resyncRequest struct {
+	table string
+	done  chan error
+}
+
+type resyncResult struct {
+	table    string
+	snapshot pglogrepl.LSN
+	defs     []sqlgen.ColDef
+	rows     [][]any
+	err      error
+}
+
+// resync tracks a single table resync while the stream keeps
+// applying changes. Changes to the table that arrive while the
+// snapshot is being copied are buffered alongside the LSN of the
+// transaction they belong to, and replayed on top of the new copy
+// if they were committed after the snapshot was taken.
+type resync struct {
+	req      resyncRequest
+	result   *resyncResult
+	buffered []bufferedQuery
+}
+
+type bufferedQuery struct {
+	lsn   pglogrepl.LSN
+	query string
+}
+
+// Resync re-snapshots a single table and swaps its contents into
+// the local database, while replication keeps running.
+// Stream must be running for the resync to be picked up.
+func (c *Conn) Resync(ctx context.Context, table string) error {
+	req := resyncRequest{
+		table: table,
+		done:  make(chan error, 1),
+	}
+
+	select {
+	case c.resyncs <- req:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-req.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// snapshotTable copies the upstream table from a new temporary slot's
+// exported snapshot. The consistent point of that slot is the LSN
+// the copied data represents.
+func (c *Conn) snapshotTable(ctx context.Context, cfg SlotConfig, table string) *resyncResult {
+	res := &resyncResult{table: table}
+
+	slotConn, err := pgconn.Connect(ctx, c.connStr)
+	if err != nil {
+		res.err = fmt.Errorf("pgconnect: %w", err)
+		return res
+	}
+	defer slotConn.Close(context.Background())
+
+	slot, err := pglogrepl.CreateReplicationSlot(
+		ctx,
+		slotConn,
+		cfg.SlotName+"_resync",
+		cfg.OutputPlugin,
+		pglogrepl.CreateReplicationSlotOptions{
+			Temporary:      true,
+			SnapshotAction: "EXPORT_SNAPSHOT",
+		},
+	)
+	if err != nil {
+		res.err = fmt.Errorf("create resync slot: %w", err)
+		return res
+	}
+
+	res.snapshot, err = pglogrepl.ParseLSN(slot.ConsistentPoint)
+	if err != nil {
+		res.err = fmt.Errorf("parse consistent point: %w", err)
+		return res
+	}
+
+	defs, err := tableColDefs(c.connStr, cfg.Schema, []string{table})
+	if err != nil {
+		res.err = fmt.Errorf("load col defs: %w", err)
+		return res
+	}
+
+	res.defs = defs[table]
+
+	copyConn, err := pgconn.Connect(ctx, c.connStr)
+	if err != nil {
+		res.err = fmt.Errorf("pgconnect: %w", err)
+		return res
+	}
+	defer copyConn.Close(context.Background())
+
+	if err := beginSnapshot(ctx, copyConn, slot.SnapshotName); err != nil {
+		res.err = fmt.Errorf("begin snapshot: %w", err)
+		return res
+	}
+	defer copyConn.Exec(context.Background(), `ROLLBACK;`).Close()
+
+	res.rows, err = tables.Copy(ctx, table, res.defs, copyConn)
+	if err != nil {
+		res.err = fmt.Errorf("copy table: %w", err)
+		return res
+	}
+
+	metrics.CopyRows.WithLabelValues(table).Add(float64(len(res.rows)))
+
+	log.Debug().Msgf("resync copied %d rows from %q at %s", len(res.rows), table, res.snapshot)
+
+	return res
+}
+
+// swap atomically replaces the local table with the snapshot copy,
+// and replays any changes committed after the snapshot. SQLite DDL
+// is transactional, so readers never see the table half copied.
+// It returns the statements the table was replaced with.
+func (r *resync) swap(schema string, d DBDriver, gen SQLGen) ([]string, error) {
+	res := r.result
+
+	create, err := gen.CopyCreateTable(schema, res.table, res.defs)
+	if err != nil {
+		return nil, fmt.Errorf("generate create: %w", err)
+	}
+
+	statements := []string{
+		fmt.Sprintf("DROP TABLE IF EXISTS %s;", res.table),
+		create,
+	}
+
+	for _, row := range res.rows {
+		query, err := gen.InsertCopyRow(schema, res.table, res.defs, row)
+		if err != nil {
+			return nil, fmt.Errorf("generate insert: %w", err)
+		}
+
+		statements = append(statements, query)
+	}
+
+	replayed := 0
+
+	for _, q := range r.buffered {
+		if q.lsn <= res.snapshot {
+			// already part of the snapshot
+			continue
+		}
+
+		statements = append(statements, q.query)
+		replayed++
+	}
+
+	query := "BEGIN TRANSACTION;\n" + strings.Join(statements, "\n") + "\nCOMMIT;"
+
+	if err := d.Execute(query); err != nil {
+		if rbErr := d.Execute("ROLLBACK;"); rbErr != nil {
+			log.Warn().Err(rbErr).Msg("rollback resync")
+		}
+
+		return nil, fmt.Errorf("apply resync: %w", err)
+	}
+
+	log.Debug().Msgf("resynced %q, replayed %d buffered changes", res.table, replayed)
+
+	return statements, nil
+}
+
+// swapped are the resynced tables whose snapshot the stream hasn't
+// passed yet, by the snapshot's LSN. When the stream lags behind the
+// snapshot, the changes to the table committed at or before it
+// arrive after the swap, and are already in the copy.
+type swapped map[string]pglogrepl.LSN
+
+// skip reports if a change to the table, in the
+// transaction committed at the LSN, is in the copy.
+func (s swapped) skip(table string, lsn pglogrepl.LSN) bool {
+	snapshot, ok := s[table]
+	return ok && lsn <= snapshot
+}
+
+// passed forgets the tables whose snapshot is
+// before the transaction committed at the LSN.
+func (s swapped) passed(lsn pglogrepl.LSN) {
+	for table, snapshot := range s {
+		if lsn > snapshot {
+			delete(s, table)
+		}
+	}
+}
+
+// truncate removes the tables whose truncate is already in
+// their copy from the message. It reports if any were removed.
+func (s swapped) truncate(msg *pglogrepl.TruncateMessageV2, relations map[uint32]string, lsn pglogrepl.LSN) (*pglogrepl.TruncateMessageV2, bool) {
+	kept := []uint32{}
+
+	for _, id := range msg.RelationIDs {
+		if !s.skip(relations[id], lsn) {
+			kept = append(kept, id)
+		}
+	}
+
+	if len(kept) == len(msg.RelationIDs) {
+		return msg, false
+	}
+
+	out := *msg
+	out.RelationIDs = kept
+	out.RelationNum = uint32(len(kept))
+
+	return &out, true
+}
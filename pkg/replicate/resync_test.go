@@ -0,0 +1,109 @@
+package replicate
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zknill/sqledge/pkg/config"
+	"github.com/zknill/sqledge/pkg/local"
+	"github.com/zknill/sqledge/pkg/sqlgen"
+)
+
+func TestResyncLaggingChanges(t *testing.T) {
+	t.Setenv("SQLEDGE_LOCAL_DB_PATH", filepath.Join(t.TempDir(), "sqledge.db"))
+
+	cfg, err := config.Load()
+	require.NoError(t, err)
+
+	db, err := local.OpenWriter(local.NewConfig(cfg))
+	require.NoError(t, err)
+	defer db.Close()
+
+	driver := sqlgen.NewSqliteDriver(sqliteConfig(cfg), db)
+	require.NoError(t, driver.Execute(`CREATE TABLE names (id integer PRIMARY KEY, name text);
+	INSERT INTO names VALUES (1, 'a');`))
+
+	gen := sqlgen.NewSqlite(sqliteConfig(cfg), map[string]map[string]sqlgen.ColDef{})
+	defs := []sqlgen.ColDef{
+		{Name: "id", Type: sqlgen.PgColTypeInt4, PrimaryKey: true},
+		{Name: "name", Type: sqlgen.PgColTypeText},
+	}
+
+	// the snapshot at 0/100 already has the row inserted at 0/90,
+	// which the stream hasn't reached when the copy is swapped in
+	r := &resync{
+		req: resyncRequest{table: "names"},
+		result: &resyncResult{
+			table:    "names",
+			snapshot: 0x100,
+			defs:     defs,
+			rows:     [][]any{{int32(1), "a"}, {int32(2), "b"}},
+		},
+	}
+
+	_, err = r.swap("public", driver, gen)
+	require.NoError(t, err)
+
+	s := swapped{"names": r.result.snapshot}
+
+	stream := []struct {
+		lsn   pglogrepl.LSN
+		query string
+	}{
+		{lsn: 0x90, query: "INSERT INTO names (id, name) VALUES (2, 'b');"},
+		{lsn: 0x100, query: "UPDATE names SET name = 'old' WHERE id = 1;"},
+		{lsn: 0x110, query: "INSERT INTO names (id, name) VALUES (3, 'c');"},
+	}
+
+	for _, change := range stream {
+		s.passed(change.lsn)
+
+		if s.skip("names", change.lsn) {
+			continue
+		}
+
+		require.NoError(t, driver.Execute(change.query))
+	}
+
+	assert.Empty(t, s)
+
+	rows, err := db.Query("SELECT id, name FROM names ORDER BY id")
+	require.NoError(t, err)
+	defer rows.Close()
+
+	got := map[int]string{}
+
+	for rows.Next() {
+		var (
+			id   int
+			name string
+		)
+
+		require.NoError(t, rows.Scan(&id, &name))
+		got[id] = name
+	}
+
+	assert.Equal(t, map[int]string{1: "a", 2: "b", 3: "c"}, got)
+}
+
+func TestSwappedTruncate(t *testing.T) {
+	s := swapped{"names": 0x100}
+	relations := map[uint32]string{1: "names", 2: "things"}
+
+	msg := &pglogrepl.TruncateMessageV2{TruncateMessage: pglogrepl.TruncateMessage{
+		RelationNum: 2,
+		RelationIDs: []uint32{1, 2},
+	}}
+
+	kept, ok := s.truncate(msg, relations, 0x90)
+	assert.True(t, ok)
+	assert.Equal(t, []uint32{2}, kept.RelationIDs)
+	assert.Equal(t, []uint32{1, 2}, msg.RelationIDs)
+
+	kept, ok = s.truncate(msg, relations, 0x110)
+	assert.False(t, ok)
+	assert.Equal(t, msg, kept)
+}
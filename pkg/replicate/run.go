@@ -3,71 +3,279 @@ package replicate
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"os"
+	"sync"
 
-	_ "github.com/mattn/go-sqlite3"
 	"github.com/rs/zerolog/log"
+	"github.com/zknill/sqledge/pkg/cdc"
 	"github.com/zknill/sqledge/pkg/config"
+	"github.com/zknill/sqledge/pkg/feed"
+	"github.com/zknill/sqledge/pkg/hooks"
+	"github.com/zknill/sqledge/pkg/local"
+	"github.com/zknill/sqledge/pkg/metrics"
 	"github.com/zknill/sqledge/pkg/sqlgen"
 )
 
+var ErrNotStreaming = errors.New("replication is not streaming")
+
 func Run(ctx context.Context, cfg *config.Config) error {
-	connStr := cfg.PostgresConnString() + "&replication=database"
+	return NewReplicator(cfg).Run(ctx)
+}
+
+// Replicator runs replication, and controls it while it's running.
+// The status and whether replication is paused are kept when it is
+// restarted.
+type Replicator struct {
+	cfg      *config.Config
+	status   *status
+	feed     *feed.Hub
+	messages *messages
+
+	mu     sync.Mutex
+	conn   *Conn
+	paused bool
+
+	runs int
+}
+
+func NewReplicator(cfg *config.Config) *Replicator {
+	r := &Replicator{
+		cfg:      cfg,
+		status:   newStatus(),
+		feed:     feed.NewHub(cfg.Relay.Buffer),
+		messages: newMessages(),
+	}
+
+	r.handleBuiltin()
+
+	return r
+}
+
+// Feed is the transactions applied locally, for children to relay from.
+func (r *Replicator) Feed() *feed.Hub {
+	return r.feed
+}
+
+// Subscribe sends the row changes to the tables once they're
+// committed locally, for applications embedding sqledge.
+func (r *Replicator) Subscribe(ctx context.Context, opts cdc.Options) (*cdc.Subscription, error) {
+	return cdc.Subscribe(ctx, r.feed, opts)
+}
+
+func (r *Replicator) Status() Status {
+	return r.status.snapshot()
+}
+
+func (r *Replicator) Pause() {
+	r.setPaused(true)
+}
+
+func (r *Replicator) Resume() {
+	r.setPaused(false)
+}
+
+func (r *Replicator) setPaused(paused bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.paused = paused
+
+	if r.conn != nil {
+		r.conn.SetPaused(paused)
+	}
+}
+
+// Resync resyncs a single table, see Conn.Resync.
+func (r *Replicator) Resync(ctx context.Context, table string) error {
+	r.mu.Lock()
+	conn := r.conn
+	r.mu.Unlock()
+
+	if conn == nil {
+		return ErrNotStreaming
+	}
+
+	return conn.Resync(ctx, table)
+}
+
+func (r *Replicator) setConn(conn *Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if conn != nil {
+		conn.status = r.status
+		conn.feed = r.feed
+		conn.messages = r.messages
+		conn.SetPaused(r.paused)
+	}
+
+	r.conn = conn
+}
+
+func (r *Replicator) Run(ctx context.Context) error {
+	if r.runs++; r.runs > 1 {
+		metrics.Reconnects.Inc()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go r.messages.run(ctx)
+
+	if r.cfg.Relay.Parent != "" {
+		return r.follow(ctx)
+	}
+
+	sess, err := r.open(ctx)
+	if err != nil {
+		return err
+	}
+	defer sess.close()
+
+	go func() {
+		if err := local.Checkpoint(ctx, local.NewConfig(r.cfg)); err != nil && !errors.Is(err, context.Canceled) {
+			log.Warn().Err(err).Msg("checkpoint")
+		}
+	}()
+
+	r.setConn(sess.conn)
+	defer r.setConn(nil)
+
+	log.Debug().Msg("starting streaming")
+
+	if err := sess.conn.Stream(
+		ctx,
+		sess.slot,
+		sess.driver,
+		sess.gen,
+	); err != nil {
+		return fmt.Errorf("streaming failed: %w", err)
+	}
+
+	return nil
+}
+
+// session is the replication connection and local
+// database that replication runs against.
+type session struct {
+	conn   *Conn
+	db     *sql.DB
+	driver *sqlgen.SqliteDriver
+	gen    *sqlgen.Sqlite
+	slot   SlotConfig
+}
+
+func (s *session) close() {
+	s.db.Close()
+	s.conn.Close()
+}
+
+func (r *Replicator) open(ctx context.Context) (*session, error) {
+	cfg := r.cfg
+	connStr := cfg.ReplicationConnString()
+
+	if cfg.Local.Bootstrap != "" {
+		if _, err := os.Stat(cfg.Local.Path); errors.Is(err, os.ErrNotExist) {
+			if _, err := Bootstrap(ctx, cfg, cfg.Local.Bootstrap); err != nil {
+				return nil, fmt.Errorf("bootstrap: %w", err)
+			}
+		}
+	}
 
 	conn, err := replicateConnection(ctx, connStr, cfg.Replication.Publication)
 	if err != nil {
-		return fmt.Errorf("create replicate connection: %w", err)
+		return nil, fmt.Errorf("create replicate connection: %w", err)
 	}
-	defer conn.Close()
 
-	// TODO: this is shared across reader and writer
-	db, err := sql.Open("sqlite3", cfg.Local.Path)
+	db, err := local.OpenWriter(local.NewConfig(cfg))
 	if err != nil {
-		return fmt.Errorf("connect to local db: %w", err)
+		conn.Close()
+		return nil, fmt.Errorf("connect to local db: %w", err)
 	}
 
-	sqliteCfg := sqlgen.SqliteConfig{
-		SourceDB:    cfg.Upstream.DBName,
-		Plugin:      cfg.Replication.Plugin,
-		Publication: cfg.Replication.Publication,
+	sess := &session{conn: conn, db: db}
+
+	if err := r.init(sess); err != nil {
+		sess.close()
+		return nil, err
 	}
 
-	driver := sqlgen.NewSqliteDriver(sqliteCfg, db)
+	return sess, nil
+}
+
+func (r *Replicator) init(sess *session) error {
+	cfg := r.cfg
+
+	sqliteCfg := sqliteConfig(cfg)
+
+	sess.driver = sqlgen.NewSqliteDriver(sqliteCfg, sess.db)
 
-	if err := driver.InitPositionTable(); err != nil {
+	if err := sess.driver.InitPositionTable(); err != nil {
 		return fmt.Errorf("init position tracking: %w", err)
 	}
 
-	schema, err := driver.CurrentSchema()
+	if err := sess.driver.InitIndexTable(); err != nil {
+		return fmt.Errorf("init index tracking: %w", err)
+	}
+
+	if err := sess.driver.InitSequenceTable(); err != nil {
+		return fmt.Errorf("init sequence tracking: %w", err)
+	}
+
+	h, err := hooks.New(cfg)
 	if err != nil {
-		return fmt.Errorf("get current schema: %w", err)
+		return err
+	}
+
+	if h != nil {
+		if err := hooks.InitOutbox(sess.db); err != nil {
+			return err
+		}
 	}
 
-	sqlite := sqlgen.NewSqlite(sqliteCfg, schema)
+	sess.conn.hooks = h
+
+	schema, err := sess.driver.CurrentSchema()
 	if err != nil {
-		return fmt.Errorf("init sqlgen: %w", err)
+		return fmt.Errorf("get current schema: %w", err)
 	}
 
-	slot := SlotConfig{
+	for table := range schema {
+		r.status.addTable(table)
+	}
+
+	sess.gen = sqlgen.NewSqlite(sqliteCfg, schema)
+	sess.gen.LookupObjects(sess.driver.TableObjects)
+
+	sess.slot = SlotConfig{
 		SlotName:             cfg.Replication.SlotName,
 		OutputPlugin:         cfg.Replication.Plugin,
 		CreateSlotIfNoExists: cfg.Replication.CreateSlotIfNoExists,
 		Temporary:            cfg.Replication.Temporary,
 		Schema:               cfg.Upstream.Schema,
+		IndexSyncInterval:    cfg.Replication.IndexSyncInterval,
+		LocalIndexes:         cfg.Local.Indexes,
+		SequenceSyncInterval: cfg.Replication.SequenceSyncInterval,
+		Batch: BatchConfig{
+			MaxTx:    cfg.Replication.BatchMaxTx,
+			MaxBytes: cfg.Replication.BatchMaxBytes,
+			MaxDelay: cfg.Replication.BatchMaxDelay,
+		},
+		DrainTimeout: cfg.Supervisor.DrainTimeout,
 	}
 
-	log.Debug().Msg("starting streaming")
+	return nil
+}
 
-	if err := conn.Stream(
-		ctx,
-		slot,
-		driver,
-		sqlite,
-	); err != nil {
-		return fmt.Errorf("streaming failed: %w", err)
+func sqliteConfig(cfg *config.Config) sqlgen.SqliteConfig {
+	return sqlgen.SqliteConfig{
+		SourceDB:    cfg.Upstream.DBName,
+		Plugin:      cfg.Replication.Plugin,
+		Publication: cfg.Replication.Publication,
+		Keyless:     sqlgen.KeylessPolicy(cfg.Replication.Keyless),
 	}
-
-	return nil
 }
 
 func replicateConnection(ctx context.Context, connectionString, publication string) (*Conn, error) {
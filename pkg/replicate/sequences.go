@@ -0,0 +1,45 @@
+package replicate
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/zknill/sqledge/pkg/sqlgen"
+	"github.com/zknill/sqledge/pkg/tables"
+)
+
+type sequenceResult struct {
+	defs []sqlgen.SequenceDef
+	err  error
+}
+
+func upstreamSequences(connStr, schema string) ([]sqlgen.SequenceDef, error) {
+	db, err := sql.Open("pgx", strings.Replace(connStr, "replication=database", "", 1))
+	if err != nil {
+		return nil, fmt.Errorf("open connection: %w", err)
+	}
+	defer db.Close()
+
+	defs, err := tables.Sequences(db, schema)
+	if err != nil {
+		return nil, fmt.Errorf("load sequences: %w", err)
+	}
+
+	return defs, nil
+}
+
+// syncSequences stores the upstream sequences' values locally, and
+// returns the statement they were stored with. Sequences aren't
+// replicated by pgoutput, so they're read from the upstream after
+// the rows, and are at least the values used by the rows replicated
+// so far.
+func syncSequences(upstream []sqlgen.SequenceDef, d DBDriver, gen SQLGen) (string, error) {
+	query := gen.Sequences(upstream)
+
+	if err := d.Execute("BEGIN; " + query + " COMMIT;"); err != nil {
+		return "", fmt.Errorf("store sequences: %w", err)
+	}
+
+	return query, nil
+}
@@ -0,0 +1,87 @@
+package replicate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zknill/sqledge/pkg/relay"
+)
+
+var ErrTemporarySlot = errors.New("snapshot needs a permanent slot, not a temporary one")
+
+type SnapshotResult struct {
+	// Copied is false if the local database was already copied.
+	Copied          bool     `json:"copied"`
+	LSN             string   `json:"lsn"`
+	Slot            string   `json:"slot"`
+	Tables          []string `json:"tables"`
+	DurationSeconds float64  `json:"duration_seconds"`
+}
+
+// Snapshot runs the initial copy into the local database, and
+// creates the slot that replication streams from afterwards.
+// With a relay parent, the parent's database is downloaded instead.
+func (r *Replicator) Snapshot(ctx context.Context) (*SnapshotResult, error) {
+	if r.cfg.Relay.Parent != "" {
+		return r.snapshotFromParent(ctx)
+	}
+
+	if r.cfg.Replication.Temporary {
+		return nil, ErrTemporarySlot
+	}
+
+	start := time.Now()
+
+	sess, err := r.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.close()
+
+	sess.conn.status = r.status
+
+	slot, copied, err := sess.conn.prepare(ctx, sess.slot, sess.driver, sess.gen)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot: %w", err)
+	}
+	defer slot.close()
+
+	pos, err := sess.driver.Pos()
+	if err != nil {
+		return nil, fmt.Errorf("read pos: %w", err)
+	}
+
+	return &SnapshotResult{
+		Copied:          copied,
+		LSN:             pos,
+		Slot:            sess.slot.SlotName,
+		Tables:          r.Status().Tables,
+		DurationSeconds: time.Since(start).Seconds(),
+	}, nil
+}
+
+func (r *Replicator) snapshotFromParent(ctx context.Context) (*SnapshotResult, error) {
+	start := time.Now()
+
+	pos, err := localPos(r.cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &SnapshotResult{LSN: pos, Tables: []string{}}
+
+	if pos == "" {
+		lsn, err := r.download(ctx, relay.NewClient(r.cfg.Relay.Parent, r.cfg.Relay.ParentToken))
+		if err != nil {
+			return nil, fmt.Errorf("snapshot: %w", err)
+		}
+
+		res.Copied, res.LSN = true, lsn.String()
+	}
+
+	res.DurationSeconds = time.Since(start).Seconds()
+
+	return res, nil
+}
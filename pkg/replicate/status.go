@@ -0,0 +1,115 @@
+package replicate
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+)
+
+// Status is a snapshot of the replication state.
+type Status struct {
+	Slot        string
+	Publication string
+
+	// Copied is true once the initial copy has completed,
+	// and Streaming while changes are being received.
+	Copied    bool
+	Streaming bool
+	Paused    bool
+
+	// CurrentLSN is the commit LSN of the last upstream transaction
+	// applied, ConfirmedLSN is the last position confirmed to the
+	// upstream, and ServerLSN is the upstream's last reported WAL end.
+	CurrentLSN   pglogrepl.LSN
+	ConfirmedLSN pglogrepl.LSN
+	ServerLSN    pglogrepl.LSN
+
+	// LastCommitTime is the upstream commit time of the last
+	// transaction applied, and Lag is how long after that it
+	// was applied.
+	LastCommitTime time.Time
+	Lag            time.Duration
+
+	// PendingSince is when the change waiting to be applied
+	// was received, zero when nothing is waiting.
+	PendingSince time.Time
+
+	Tables []string
+
+	// Maintenance is set by a sqledge.maintenance message, and
+	// Barrier is the content of the last sqledge.barrier message.
+	Maintenance bool
+	Barrier     string
+	BarrierLSN  pglogrepl.LSN
+}
+
+// LagAt is the lag at the time, the lag of the last transaction
+// applied, or how long a change has been waiting to be applied if
+// that's longer. It keeps growing while applying is stalled.
+func (s Status) LagAt(now time.Time) time.Duration {
+	if !s.PendingSince.IsZero() && now.Sub(s.PendingSince) > s.Lag {
+		return now.Sub(s.PendingSince)
+	}
+
+	return s.Lag
+}
+
+// lagBytes is the WAL the upstream has reported
+// beyond the last transaction applied.
+func (s *Status) lagBytes() float64 {
+	if s.ServerLSN <= s.CurrentLSN {
+		return 0
+	}
+
+	return float64(s.ServerLSN - s.CurrentLSN)
+}
+
+// status is written by the stream and read by the admin API.
+type status struct {
+	mu     sync.Mutex
+	s      Status
+	tables map[string]bool
+}
+
+func newStatus() *status {
+	return &status{tables: map[string]bool{}}
+}
+
+func (s *status) update(f func(*Status)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f(&s.s)
+}
+
+func (s *status) addTable(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tables[name] = true
+}
+
+func (s *status) removeTable(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.tables, name)
+}
+
+func (s *status) snapshot() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := s.s
+	out.Tables = make([]string, 0, len(s.tables))
+
+	for t := range s.tables {
+		out.Tables = append(out.Tables, t)
+	}
+
+	sort.Strings(out.Tables)
+
+	return out
+}
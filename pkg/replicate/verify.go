@@ -0,0 +1,284 @@
+package replicate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/rs/zerolog/log"
+	"github.com/zknill/sqledge/pkg/local"
+	"github.com/zknill/sqledge/pkg/metrics"
+	"github.com/zknill/sqledge/pkg/tables"
+	"github.com/zknill/sqledge/pkg/verify"
+)
+
+var ErrVerifyInProgress = errors.New("verify already in progress")
+
+// errVerifyPassed is returned when the stream has already
+// applied changes beyond the snapshot being verified against.
+var errVerifyPassed = errors.New("stream is past the verify snapshot")
+
+const (
+	verifyAttempts = 3
+	verifyWait     = 30 * time.Second
+)
+
+// verifyRequest holds the stream at lsn, before the first upstream
+// transaction committed at or after it. reached is sent nil once
+// the stream is held, and the stream is released when done closes.
+type verifyRequest struct {
+	lsn     pglogrepl.LSN
+	reached chan error
+	done    chan struct{}
+}
+
+// Verify compares the local database with the upstream. Both are
+// read at the same LSN, the upstream from a temporary slot's exported
+// snapshot and the local database while the stream is held at the
+// slot's consistent point. With repair, the tables that differ are
+// resynced. Stream must be running for the verify to be picked up.
+func (r *Replicator) Verify(ctx context.Context, repair bool) (*verify.Report, error) {
+	r.mu.Lock()
+	conn := r.conn
+	r.mu.Unlock()
+
+	if conn == nil {
+		return nil, ErrNotStreaming
+	}
+
+	start := time.Now()
+
+	var (
+		report *verify.Report
+		err    error
+	)
+
+	for attempt := 1; attempt <= verifyAttempts; attempt++ {
+		report, err = r.verify(ctx, conn)
+		if !errors.Is(err, errVerifyPassed) {
+			break
+		}
+
+		log.Debug().Msgf("verify attempt %d: %s, retrying", attempt, err)
+	}
+
+	if err != nil {
+		metrics.VerifyRuns.WithLabelValues("error").Inc()
+		return nil, fmt.Errorf("verify: %w", err)
+	}
+
+	for _, t := range report.Tables {
+		metrics.VerifyRows.WithLabelValues(t.Table, "missing").Set(float64(t.Missing))
+		metrics.VerifyRows.WithLabelValues(t.Table, "extra").Set(float64(t.Extra))
+		metrics.VerifyRows.WithLabelValues(t.Table, "differing").Set(float64(t.Differing))
+	}
+
+	if report.Match {
+		metrics.VerifyRuns.WithLabelValues("match").Inc()
+	} else {
+		metrics.VerifyRuns.WithLabelValues("mismatch").Inc()
+	}
+
+	if repair {
+		for _, t := range report.Tables {
+			if t.Match || t.Error != "" {
+				continue
+			}
+
+			if err := conn.Resync(ctx, t.Table); err != nil {
+				return nil, fmt.Errorf("repair %q: %w", t.Table, err)
+			}
+
+			report.Repaired = append(report.Repaired, t.Table)
+		}
+	}
+
+	report.DurationSeconds = time.Since(start).Seconds()
+
+	return report, nil
+}
+
+func (r *Replicator) verify(ctx context.Context, conn *Conn) (*verify.Report, error) {
+	cfg := r.cfg
+
+	slotConn, err := pgconn.Connect(ctx, cfg.ReplicationConnString())
+	if err != nil {
+		return nil, fmt.Errorf("pgconnect: %w", err)
+	}
+	defer slotConn.Close(context.Background())
+
+	// the exported snapshot is usable while slotConn is open
+	slot, err := pglogrepl.CreateReplicationSlot(
+		ctx,
+		slotConn,
+		cfg.Replication.SlotName+"_verify",
+		cfg.Replication.Plugin,
+		pglogrepl.CreateReplicationSlotOptions{
+			Temporary:      true,
+			SnapshotAction: "EXPORT_SNAPSHOT",
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create verify slot: %w", err)
+	}
+
+	lsn, err := pglogrepl.ParseLSN(slot.ConsistentPoint)
+	if err != nil {
+		return nil, fmt.Errorf("parse consistent point: %w", err)
+	}
+
+	upstream, err := sql.Open("pgx", cfg.PostgresConnString())
+	if err != nil {
+		return nil, fmt.Errorf("open upstream: %w", err)
+	}
+	defer upstream.Close()
+
+	upstreamTx, err := upstream.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("begin upstream: %w", err)
+	}
+	defer upstreamTx.Rollback()
+
+	if _, err := upstreamTx.ExecContext(ctx, fmt.Sprintf("SET TRANSACTION SNAPSHOT '%s';", slot.SnapshotName)); err != nil {
+		return nil, fmt.Errorf("set snapshot: %w", err)
+	}
+
+	// a transaction after the snapshot, for the
+	// stream to be held at if the upstream is idle
+	if _, err := upstream.ExecContext(ctx, `SELECT pg_logical_emit_message(true, 'sqledge.verify', '');`); err != nil {
+		log.Debug().Err(err).Msg("emit verify message")
+	}
+
+	req := verifyRequest{
+		lsn:     lsn,
+		reached: make(chan error, 1),
+		done:    make(chan struct{}),
+	}
+
+	wait := time.NewTimer(verifyWait)
+	defer wait.Stop()
+
+	select {
+	case conn.verifies <- req:
+	case <-wait.C:
+		return nil, ErrNotStreaming
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	released := false
+	release := func() {
+		if !released {
+			released = true
+			close(req.done)
+		}
+	}
+	defer release()
+
+	select {
+	case err := <-req.reached:
+		if err != nil {
+			return nil, err
+		}
+	case <-wait.C:
+		return nil, fmt.Errorf("stream didn't reach %s within %s", lsn, verifyWait)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	db, err := local.OpenReader(local.NewConfig(cfg))
+	if err != nil {
+		return nil, fmt.Errorf("open local: %w", err)
+	}
+	defer db.Close()
+
+	localTx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("begin local: %w", err)
+	}
+	defer localTx.Rollback()
+
+	// SQLite takes the read snapshot on the first read
+	var n int
+	if err := localTx.QueryRowContext(ctx, `SELECT count(*) FROM sqlite_master;`).Scan(&n); err != nil {
+		return nil, fmt.Errorf("read local: %w", err)
+	}
+
+	release()
+
+	log.Debug().Msgf("verifying at %s", lsn)
+
+	defs, err := tables.TableColDefs(upstreamTx, cfg.Upstream.Schema, nil)
+	if err != nil {
+		return nil, fmt.Errorf("load col defs: %w", err)
+	}
+
+	report, err := verify.Compare(ctx, upstreamTx, localTx, cfg.Upstream.Schema, defs, verify.Options{
+		ChunkSize: cfg.Verify.ChunkSize,
+		Throttle:  cfg.Verify.Throttle,
+		MaxKeys:   cfg.Verify.MaxKeys,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report.LSN = lsn.String()
+
+	return report, nil
+}
+
+// RunVerify verifies every verify interval until the context is done.
+// Failed verifies are logged, and the next one is tried as usual.
+func (r *Replicator) RunVerify(ctx context.Context) error {
+	ticker := time.NewTicker(r.cfg.Verify.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		report, err := r.Verify(ctx, r.cfg.Verify.Repair)
+		if err != nil {
+			switch {
+			case ctx.Err() != nil:
+				return ctx.Err()
+			case errors.Is(err, ErrNotStreaming), errors.Is(err, ErrVerifyInProgress):
+				log.Debug().Err(err).Msg("verify skipped")
+			default:
+				log.Warn().Err(err).Msg("verify")
+			}
+
+			continue
+		}
+
+		if report.Match {
+			log.Info().Msgf("verified %d tables at %s", len(report.Tables), report.LSN)
+			continue
+		}
+
+		for _, t := range report.Tables {
+			switch {
+			case t.Error != "":
+				log.Warn().Msgf("verify %q at %s: %s", t.Table, report.LSN, t.Error)
+			case !t.Match && t.Keyless:
+				log.Warn().Msgf("verify %q at %s: %d upstream and %d local rows",
+					t.Table, report.LSN, t.UpstreamRows, t.LocalRows)
+			case !t.Match:
+				log.Warn().Msgf("verify %q at %s: %d missing, %d extra and %d differing rows",
+					t.Table, report.LSN, t.Missing, t.Extra, t.Differing)
+			}
+		}
+
+		if len(report.Repaired) > 0 {
+			log.Info().Msgf("repaired %s", strings.Join(report.Repaired, ", "))
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package sqlgen
+
+import "fmt"
+
+// DropTable drops a table that was dropped upstream.
+func (s *Sqlite) DropTable(table string) string {
+	delete(s.current, table)
+
+	return fmt.Sprintf("DROP TABLE IF EXISTS %s;", table)
+}
+
+// RenameTable renames a table that was renamed upstream. It returns no
+// SQL if the table doesn't exist locally, or the new name is taken.
+func (s *Sqlite) RenameTable(from, to string) string {
+	cols, ok := s.current[from]
+	if _, taken := s.current[to]; !ok || taken {
+		return ""
+	}
+
+	delete(s.current, from)
+	s.current[to] = cols
+
+	return fmt.Sprintf("ALTER TABLE %s RENAME TO %s;", from, to)
+}
+
+// RenameColumn renames a column that was renamed upstream, keeping
+// its data. It returns no SQL if the column doesn't exist locally,
+// or the new name is taken.
+func (s *Sqlite) RenameColumn(table, from, to string) string {
+	cols, ok := s.current[table]
+	if !ok {
+		return ""
+	}
+
+	col, ok := cols[from]
+	if _, taken := cols[to]; !ok || taken {
+		return ""
+	}
+
+	delete(cols, from)
+	col.Name = to
+	cols[to] = col
+
+	return fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s;", table, from, to)
+}
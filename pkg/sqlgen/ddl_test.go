@@ -0,0 +1,63 @@
+package sqlgen_test
+
+import (
+	"testing"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zknill/sqledge/pkg/sqlgen"
+)
+
+func TestRenameColumn(t *testing.T) {
+	gen := sqlgen.NewSqlite(sqlgen.SqliteConfig{}, map[string]map[string]sqlgen.ColDef{
+		"names": {
+			"id":    {Name: "id", Type: sqlgen.SQLiteColTypeInteger, PrimaryKey: true},
+			"first": {Name: "first", Type: sqlgen.SQLiteColTypeText},
+		},
+	})
+
+	assert.Equal(t, "ALTER TABLE names RENAME COLUMN first TO given;", gen.RenameColumn("names", "first", "given"))
+
+	// already renamed, or unknown
+	assert.Empty(t, gen.RenameColumn("names", "first", "given"))
+	assert.Empty(t, gen.RenameColumn("names", "id", "given"))
+	assert.Empty(t, gen.RenameColumn("other", "first", "given"))
+
+	// the relation after the rename doesn't drop and add the column
+	query, err := gen.Relation(&pglogrepl.RelationMessageV2{RelationMessage: pglogrepl.RelationMessage{
+		RelationID:      1,
+		RelationName:    "names",
+		ReplicaIdentity: 'd',
+		Columns: []*pglogrepl.RelationMessageColumn{
+			{Name: "id", DataType: pgtype.Int4OID, Flags: 1},
+			{Name: "given", DataType: pgtype.TextOID},
+		},
+	}})
+	require.NoError(t, err)
+	assert.Empty(t, query)
+}
+
+func TestRenameTable(t *testing.T) {
+	gen := sqlgen.NewSqlite(sqlgen.SqliteConfig{}, map[string]map[string]sqlgen.ColDef{
+		"names":  {"id": {Name: "id", Type: sqlgen.SQLiteColTypeInteger, PrimaryKey: true}},
+		"things": {"id": {Name: "id", Type: sqlgen.SQLiteColTypeInteger, PrimaryKey: true}},
+	})
+
+	assert.Empty(t, gen.RenameTable("names", "things"))
+	assert.Equal(t, "ALTER TABLE names RENAME TO people;", gen.RenameTable("names", "people"))
+	assert.Empty(t, gen.RenameTable("names", "people"))
+
+	assert.Equal(t, "DROP TABLE IF EXISTS people;", gen.DropTable("people"))
+
+	// a table created again after it's dropped is created from scratch
+	query, err := gen.Relation(&pglogrepl.RelationMessageV2{RelationMessage: pglogrepl.RelationMessage{
+		RelationID:      2,
+		RelationName:    "people",
+		ReplicaIdentity: 'd',
+		Columns:         []*pglogrepl.RelationMessageColumn{{Name: "id", DataType: pgtype.Int4OID, Flags: 1}},
+	}})
+	require.NoError(t, err)
+	assert.Contains(t, query, "CREATE TABLE")
+}
@@ -9,12 +9,16 @@ import (
 type SqliteDriver struct {
 	db  *sql.DB
 	cfg SqliteConfig
+
+	// relation id -> sql -> prepared statement
+	stmts map[uint32]map[string]*sql.Stmt
 }
 
 func NewSqliteDriver(cfg SqliteConfig, db *sql.DB) *SqliteDriver {
 	return &SqliteDriver{
-		cfg: cfg,
-		db:  db,
+		cfg:   cfg,
+		db:    db,
+		stmts: make(map[uint32]map[string]*sql.Stmt),
 	}
 }
 
@@ -23,6 +27,46 @@ func (s *SqliteDriver) Execute(query string) error {
 	return err
 }
 
+// ExecuteStmt binds the values of a row change to its statement.
+// Statements are prepared on first use, and cached for the relation
+// until it's invalidated.
+func (s *SqliteDriver) ExecuteStmt(stmt Stmt) error {
+	if stmt.SQL == "" {
+		return nil
+	}
+
+	cached, ok := s.stmts[stmt.RelationID]
+	if !ok {
+		cached = make(map[string]*sql.Stmt)
+		s.stmts[stmt.RelationID] = cached
+	}
+
+	prepared, ok := cached[stmt.SQL]
+	if !ok {
+		var err error
+
+		prepared, err = s.db.Prepare(stmt.SQL)
+		if err != nil {
+			return fmt.Errorf("prepare: %w", err)
+		}
+
+		cached[stmt.SQL] = prepared
+	}
+
+	_, err := prepared.Exec(stmt.Args...)
+	return err
+}
+
+// Invalidate closes the statements cached for the relation,
+// when its schema changes.
+func (s *SqliteDriver) Invalidate(relationID uint32) {
+	for _, stmt := range s.stmts[relationID] {
+		stmt.Close()
+	}
+
+	delete(s.stmts, relationID)
+}
+
 func (s *SqliteDriver) Pos() (string, error) {
 	query := `SELECT pos 
     FROM postgres_pos 
@@ -60,11 +104,147 @@ func (s *SqliteDriver) InitPositionTable() error {
 	return nil
 }
 
+// InitIndexTable creates the table tracking which
+// local indexes were created from upstream indexes.
+func (s *SqliteDriver) InitIndexTable() error {
+	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS postgres_indexes (
+		name text PRIMARY KEY,
+		ddl text
+	)`)
+	if err != nil {
+		return fmt.Errorf("create index table: %w", err)
+	}
+
+	return nil
+}
+
+// InitSequenceTable creates the table the
+// upstream sequences' values are stored in.
+func (s *SqliteDriver) InitSequenceTable() error {
+	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS postgres_sequences (
+		name text PRIMARY KEY,
+		last_value integer NOT NULL,
+		increment_by integer NOT NULL,
+		is_called boolean NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("create sequence table: %w", err)
+	}
+
+	return nil
+}
+
+// Indexes returns the DDL of the indexes created from upstream
+// indexes, keyed by index name. Indexes dropped along with their
+// table aren't returned, so that they are created again.
+func (s *SqliteDriver) Indexes() (map[string]string, error) {
+	rows, err := s.db.Query(`SELECT p.name, p.ddl
+	FROM postgres_indexes p
+	JOIN sqlite_schema s ON s.type = 'index' AND s.name = p.name;`)
+	if err != nil {
+		return nil, fmt.Errorf("query indexes: %w", err)
+	}
+	defer rows.Close()
+
+	out := make(map[string]string)
+
+	var name, ddl string
+
+	for rows.Next() {
+		if err := rows.Scan(&name, &ddl); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+
+		out[name] = ddl
+	}
+
+	return out, rows.Err()
+}
+
+// SchemaObject is an index or trigger on a table.
+type SchemaObject struct {
+	Type string
+	Name string
+	SQL  string
+
+	// Columns are the columns an index is on,
+	// without the expressions it's on.
+	Columns []string
+}
+
+// TableObjects returns the indexes and triggers on the table, with the
+// SQL that created them. The indexes sqlite creates for the primary
+// key and unique constraints have no SQL, and aren't returned.
+func (s *SqliteDriver) TableObjects(table string) ([]SchemaObject, error) {
+	rows, err := s.db.Query(`SELECT type, name, sql FROM sqlite_schema
+	WHERE tbl_name = ? AND type IN ('index', 'trigger') AND sql IS NOT NULL
+	ORDER BY type, name;`, table)
+	if err != nil {
+		return nil, fmt.Errorf("query schema objects: %w", err)
+	}
+	defer rows.Close()
+
+	out := []SchemaObject{}
+
+	for rows.Next() {
+		var obj SchemaObject
+
+		if err := rows.Scan(&obj.Type, &obj.Name, &obj.SQL); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+
+		out = append(out, obj)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range out {
+		if out[i].Type != "index" {
+			continue
+		}
+
+		if out[i].Columns, err = s.indexColumns(out[i].Name); err != nil {
+			return nil, err
+		}
+	}
+
+	return out, nil
+}
+
+func (s *SqliteDriver) indexColumns(index string) ([]string, error) {
+	rows, err := s.db.Query("SELECT name FROM pragma_index_info(?) WHERE name IS NOT NULL ORDER BY seqno;", index)
+	if err != nil {
+		return nil, fmt.Errorf("query index %q columns: %w", index, err)
+	}
+	defer rows.Close()
+
+	cols := []string{}
+
+	for rows.Next() {
+		var col string
+
+		if err := rows.Scan(&col); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+
+		cols = append(cols, col)
+	}
+
+	return cols, rows.Err()
+}
+
 func (s *SqliteDriver) CurrentSchema() (map[string]map[string]ColDef, error) {
 	// tableName -> colName -> colDef
 	out := make(map[string]map[string]ColDef)
 
-	query := `SELECT tbl_name, sql FROM sqlite_schema WHERE type = 'table';`
+	// sqlite's own tables, like sqlite_sequence, and the
+	// postgres_ bookkeeping tables aren't replicated
+	query := `SELECT tbl_name, sql FROM sqlite_schema
+	WHERE type = 'table'
+	AND tbl_name NOT LIKE 'sqlite\_%' ESCAPE '\'
+	AND tbl_name NOT LIKE 'postgres\_%' ESCAPE '\';`
 
 	type tableRow struct {
 		TableName string `db:"tbl_name"`
@@ -0,0 +1,41 @@
+package sqlgen_test
+
+import (
+	"database/sql"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zknill/sqledge/pkg/sqlgen"
+)
+
+func TestCurrentSchema(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+
+	db.SetMaxOpenConns(1)
+
+	driver := sqlgen.NewSqliteDriver(sqlgen.SqliteConfig{}, db)
+
+	// edge only tables can use anything sqlite supports,
+	// and AUTOINCREMENT creates sqlite_sequence
+	require.NoError(t, driver.Execute(`CREATE TABLE names (id integer PRIMARY KEY, name text NOT NULL);
+	CREATE TABLE edge_only (
+		id integer PRIMARY KEY AUTOINCREMENT,
+		name text COLLATE NOCASE CHECK (length(name) > 0),
+		name_id integer REFERENCES names (id)
+	);`))
+
+	schema, err := driver.CurrentSchema()
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]sqlgen.ColDef{
+		"id":   {Name: "id", Type: "integer", PrimaryKey: true},
+		"name": {Name: "name", Type: "text", NotNull: true},
+	}, schema["names"])
+
+	assert.Contains(t, schema, "edge_only")
+	assert.NotContains(t, schema, "sqlite_sequence")
+}
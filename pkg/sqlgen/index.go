@@ -0,0 +1,145 @@
+package sqlgen
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrUnknownTable        = errors.New("unknown table")
+	ErrUntranslatableIndex = errors.New("index cannot be translated to sqlite")
+)
+
+type IndexDef struct {
+	Name    string
+	Table   string
+	Columns []string
+	Unique  bool
+
+	// Where is the predicate of a partial index
+	Where string
+}
+
+// CreateIndex generates the SQLite DDL for an upstream index.
+// Indexes on expressions, or with predicates that use postgres
+// functions or operators, return ErrUntranslatableIndex.
+func (s *Sqlite) CreateIndex(idx IndexDef) (string, error) {
+	cols, ok := s.current[idx.Table]
+	if !ok {
+		return "", fmt.Errorf("index %q on %q: %w", idx.Name, idx.Table, ErrUnknownTable)
+	}
+
+	for _, c := range idx.Columns {
+		if c == "" {
+			return "", fmt.Errorf("index %q on expression: %w", idx.Name, ErrUntranslatableIndex)
+		}
+
+		if _, ok := cols[c]; !ok {
+			return "", fmt.Errorf("index %q on unknown column %q: %w", idx.Name, c, ErrUnknownTable)
+		}
+	}
+
+	buf := &strings.Builder{}
+
+	buf.WriteString("CREATE ")
+	if idx.Unique {
+		buf.WriteString("UNIQUE ")
+	}
+
+	fmt.Fprintf(buf, "INDEX IF NOT EXISTS %s ON %s (%s)", idx.Name, idx.Table, strings.Join(idx.Columns, ", "))
+
+	if idx.Where != "" {
+		where, err := translatePredicate(idx.Where)
+		if err != nil {
+			return "", fmt.Errorf("index %q: %w", idx.Name, err)
+		}
+
+		buf.WriteString(" WHERE " + where)
+	}
+
+	buf.WriteString(";")
+
+	return buf.String(), nil
+}
+
+// TrackIndex records an index that was created from the upstream,
+// so that it can be dropped when it's dropped upstream.
+func (s *Sqlite) TrackIndex(name, ddl string) string {
+	return fmt.Sprintf(
+		"INSERT OR REPLACE INTO postgres_indexes (name, ddl) VALUES ('%s', '%s');",
+		name, strings.ReplaceAll(ddl, "'", "''"),
+	)
+}
+
+func (s *Sqlite) DropIndex(name string) string {
+	return fmt.Sprintf(
+		"DROP INDEX IF EXISTS %s; DELETE FROM postgres_indexes WHERE name = '%s';",
+		name, name,
+	)
+}
+
+var (
+	predicateToken = regexp.MustCompile(`^(?:'(?:[^']|'')*'|[A-Za-z_][A-Za-z0-9_]*|[0-9]+(?:\.[0-9]+)?|<>|!=|<=|>=|[-=<>(),]|\s+)`)
+	predicateCast  = regexp.MustCompile(`::(?:character varying|double precision|timestamp with(?:out)? time zone|[A-Za-z_][A-Za-z0-9_]*)(?:\[\])?`)
+	predicateIdent = regexp.MustCompile(`^[A-Za-z_]`)
+
+	predicateKeywords = map[string]bool{
+		"AND": true, "OR": true, "NOT": true, "IS": true,
+		"NULL": true, "IN": true, "BETWEEN": true,
+	}
+)
+
+// translatePredicate translates the partial index predicate, as
+// printed by pg_get_expr, to SQLite. Type casts are dropped, and
+// only comparisons of columns with literals are allowed through.
+func translatePredicate(pred string) (string, error) {
+	pred = predicateCast.ReplaceAllString(pred, "")
+
+	var (
+		tokens []string
+		rest   = pred
+	)
+
+	for rest != "" {
+		t := predicateToken.FindString(rest)
+		if t == "" {
+			return "", fmt.Errorf("predicate %q: %w", pred, ErrUntranslatableIndex)
+		}
+
+		rest = rest[len(t):]
+
+		if strings.TrimSpace(t) != "" {
+			tokens = append(tokens, t)
+		}
+	}
+
+	for i, t := range tokens {
+		upper := strings.ToUpper(t)
+
+		switch {
+		case upper == "TRUE" || upper == "FALSE":
+			// booleans are stored as text, and aren't
+			// consistently formatted between copy and replication
+			return "", fmt.Errorf("predicate %q uses boolean: %w", pred, ErrUntranslatableIndex)
+		case predicateKeywords[upper]:
+		case predicateIdent.MatchString(t):
+			if i+1 < len(tokens) && tokens[i+1] == "(" {
+				return "", fmt.Errorf("predicate %q calls %s: %w", pred, t, ErrUntranslatableIndex)
+			}
+		}
+	}
+
+	out := &strings.Builder{}
+
+	for i, t := range tokens {
+		if i > 0 && t != ")" && t != "," && tokens[i-1] != "(" {
+			out.WriteString(" ")
+		}
+
+		out.WriteString(t)
+	}
+
+	return out.String(), nil
+}
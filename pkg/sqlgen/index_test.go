@@ -0,0 +1,81 @@
+package sqlgen_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zknill/sqledge/pkg/sqlgen"
+)
+
+func TestCreateIndex(t *testing.T) {
+	gen := sqlgen.NewSqlite(sqlgen.SqliteConfig{}, map[string]map[string]sqlgen.ColDef{
+		"names": {
+			"id":         {Name: "id", Type: sqlgen.SQLiteColTypeInteger, PrimaryKey: true},
+			"name":       {Name: "name", Type: sqlgen.SQLiteColTypeText},
+			"status":     {Name: "status", Type: sqlgen.SQLiteColTypeText},
+			"deleted_at": {Name: "deleted_at", Type: sqlgen.SQLiteColTypeText},
+		},
+	})
+
+	tests := []struct {
+		name    string
+		idx     sqlgen.IndexDef
+		want    string
+		wantErr error
+	}{
+		{
+			name: "plain",
+			idx:  sqlgen.IndexDef{Name: "names_name_idx", Table: "names", Columns: []string{"name"}},
+			want: "CREATE INDEX IF NOT EXISTS names_name_idx ON names (name);",
+		},
+		{
+			name: "unique multi column",
+			idx:  sqlgen.IndexDef{Name: "names_name_status_idx", Table: "names", Columns: []string{"name", "status"}, Unique: true},
+			want: "CREATE UNIQUE INDEX IF NOT EXISTS names_name_status_idx ON names (name, status);",
+		},
+		{
+			name: "partial",
+			idx: sqlgen.IndexDef{
+				Name:    "names_active_idx",
+				Table:   "names",
+				Columns: []string{"name"},
+				Where:   "(((status)::text = 'active'::text) AND (deleted_at IS NULL))",
+			},
+			want: "CREATE INDEX IF NOT EXISTS names_active_idx ON names (name) WHERE (((status) = 'active') AND (deleted_at IS NULL));",
+		},
+		{
+			name: "partial with function",
+			idx: sqlgen.IndexDef{
+				Name:    "names_recent_idx",
+				Table:   "names",
+				Columns: []string{"name"},
+				Where:   "(deleted_at > now())",
+			},
+			wantErr: sqlgen.ErrUntranslatableIndex,
+		},
+		{
+			name:    "expression",
+			idx:     sqlgen.IndexDef{Name: "names_lower_idx", Table: "names", Columns: []string{""}},
+			wantErr: sqlgen.ErrUntranslatableIndex,
+		},
+		{
+			name:    "unknown table",
+			idx:     sqlgen.IndexDef{Name: "other_idx", Table: "other", Columns: []string{"id"}},
+			wantErr: sqlgen.ErrUnknownTable,
+		},
+	}
+
+	for i := range tests {
+		test := tests[i]
+		t.Run(test.name, func(t *testing.T) {
+			got, err := gen.CreateIndex(test.idx)
+			if test.wantErr != nil {
+				assert.ErrorIs(t, err, test.wantErr)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
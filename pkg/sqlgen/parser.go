@@ -23,6 +23,7 @@ const (
 	stepColumnDefsCloseBracket
 	stepColumnDefPrimaryKey
 	stepColumnDefConstraints
+	stepColumnDefUnique
 )
 
 type ColDef struct {
@@ -30,6 +31,15 @@ type ColDef struct {
 	Type       ColType
 	PrimaryKey bool
 	Array      bool
+	NotNull    bool
+
+	// Default is the column default expression,
+	// only literal defaults are created in SQLite.
+	Default string
+
+	// Unique is the names of the unique
+	// constraints the column is part of.
+	Unique []string
 }
 
 type ColType string
@@ -104,6 +114,9 @@ func (p *Parser) Parse() (string, []ColDef, error) {
 				p.popWhitespace()
 				p.step = stepSchemaTableName
 			}
+		case stepIfNoExists:
+			p.popWhitespace()
+			p.step = stepSchemaTableName
 		case stepSchemaTableName:
 			log.Trace().Msg("enter stepSchemaTableName")
 			p.table = p.pop()
@@ -127,10 +140,27 @@ func (p *Parser) Parse() (string, []ColDef, error) {
 				p.pop()
 				p.step = stepColumnDefPrimaryKey
 				continue
+			case "UNIQUE":
+				p.pop()
+				p.step = stepColumnDefUnique
+				continue
+			}
+
+			// other table constraints don't change the columns
+			switch strings.ToUpper(p.peek()) {
+			case "CONSTRAINT", "CHECK", "FOREIGN":
+				p.skipTableConstraint()
+				continue
 			}
+
 			colName := p.pop()
 			log.Trace().Msgf("col name: %q\n", colName)
-			typeName := p.pop()
+
+			// sqlite columns can be declared without a type
+			var typeName string
+			if peeked := p.peek(); peeked != "," && peeked != ")" {
+				typeName = p.pop()
+			}
 			log.Trace().Msgf("type name: %q\n", typeName)
 			p.cols = append(p.cols, ColDef{
 				Name: colName,
@@ -149,18 +179,31 @@ func (p *Parser) Parse() (string, []ColDef, error) {
 			}
 		case stepColumnDefConstraints:
 			log.Trace().Msg("enter stepColumnDefConstraints")
-			switch p.peek() {
+			switch peeked := p.peek(); peeked {
 			case "NOT NULL":
 				p.pop()
+				p.cols[len(p.cols)-1].NotNull = true
 			case "PRIMARY KEY":
 				p.pop()
 				p.cols[len(p.cols)-1].PrimaryKey = true
+			case "UNIQUE":
+				p.pop()
+				col := &p.cols[len(p.cols)-1]
+				col.Unique = append(col.Unique, p.table+"_"+col.Name+"_key")
+			case "DEFAULT":
+				p.pop()
+				p.cols[len(p.cols)-1].Default = p.popLiteral()
 			case ",":
 				p.pop()
 				p.step = stepColumnDefsOpenBracket
 			case ")":
 				p.pop()
 				p.step = stepColumnDefsCloseBracket
+			default:
+				// constraints that don't change the column
+				// definition, e.g. CHECK, REFERENCES, COLLATE
+				log.Trace().Msgf("skip column constraint: %q", peeked)
+				p.skip()
 			}
 
 		case stepColumnDefPrimaryKey:
@@ -176,16 +219,102 @@ func (p *Parser) Parse() (string, []ColDef, error) {
 				colName := p.pop()
 				p.makeColPK(colName)
 			case ")":
+				p.pop()
 				p.step = stepColumnDefConstraints
 			default:
 				return p.table, p.cols, errors.New("unknown column def PK")
 			}
+		case stepColumnDefUnique:
+			log.Trace().Msg("enter stepColumnDefUnique")
+			if p.pop() != "(" {
+				return p.table, p.cols, errors.New("unknown column def UNIQUE")
+			}
+
+			cols := []string{}
+
+			for {
+				cols = append(cols, p.pop())
+
+				if p.peek() != "," {
+					break
+				}
+
+				p.pop()
+			}
+
+			if p.peek() != ")" {
+				return p.table, p.cols, errors.New("unknown column def UNIQUE")
+			}
+
+			p.pop()
+
+			name := p.table + "_" + strings.Join(cols, "_") + "_key"
+			for _, c := range cols {
+				p.makeColUnique(c, name)
+			}
+
+			p.step = stepColumnDefConstraints
 		case stepColumnDefsCloseBracket:
 			return p.table, p.cols, nil
 		}
 	}
 }
 
+// skip pops a token, or a parenthesised expression. Characters
+// that aren't part of a token are skipped one at a time.
+func (p *Parser) skip() {
+	if p.peek() != "(" {
+		if _, l := p.peekWithLength(); l > 0 {
+			p.i += l
+		} else {
+			p.i++
+		}
+
+		p.popWhitespace()
+
+		return
+	}
+
+	depth := 0
+
+	for ; p.i < len(p.sql); p.i++ {
+		switch p.sql[p.i] {
+		case '\'':
+			p.popLiteral()
+			p.i--
+		case '(':
+			depth++
+		case ')':
+			depth--
+		}
+
+		if depth == 0 {
+			break
+		}
+	}
+
+	p.i = min(len(p.sql), p.i+1)
+	p.popWhitespace()
+}
+
+// skipTableConstraint skips a table constraint the
+// parser doesn't use, up to the next column definition.
+func (p *Parser) skipTableConstraint() {
+	for p.i < len(p.sql) {
+		switch p.peek() {
+		case ",":
+			p.pop()
+			return
+		case ")":
+			p.pop()
+			p.step = stepColumnDefsCloseBracket
+			return
+		}
+
+		p.skip()
+	}
+}
+
 func (p *Parser) makeColPK(name string) {
 	for i := range p.cols {
 		if p.cols[i].Name == name {
@@ -195,6 +324,55 @@ func (p *Parser) makeColPK(name string) {
 	}
 }
 
+func (p *Parser) makeColUnique(name, constraint string) {
+	for i := range p.cols {
+		if p.cols[i].Name == name {
+			p.cols[i].Unique = append(p.cols[i].Unique, constraint)
+			return
+		}
+	}
+}
+
+// popLiteral pops a quoted string or
+// numeric literal, quotes are kept.
+func (p *Parser) popLiteral() string {
+	if p.i >= len(p.sql) || p.sql[p.i] != '\'' {
+		start := p.i
+		if p.i < len(p.sql) && p.sql[p.i] == '-' {
+			p.i++
+		}
+
+		_, l := p.peekIdentifierWithLength()
+		p.i += l
+		lit := p.sql[start:p.i]
+		p.popWhitespace()
+
+		return lit
+	}
+
+	start := p.i
+
+	for p.i++; p.i < len(p.sql); p.i++ {
+		if p.sql[p.i] != '\'' {
+			continue
+		}
+
+		// escaped quote
+		if p.i+1 < len(p.sql) && p.sql[p.i+1] == '\'' {
+			p.i++
+			continue
+		}
+
+		break
+	}
+
+	p.i = min(len(p.sql), p.i+1)
+	lit := p.sql[start:p.i]
+	p.popWhitespace()
+
+	return lit
+}
+
 func (p *Parser) peek() string {
 	token, _ := p.peekWithLength()
 	return token
@@ -224,7 +402,7 @@ func (p *Parser) pop() string {
 
 var tokens = []string{
 	"CREATE TABLE", "IF NOT EXISTS", "AS", "(", ")", ";",
-	"PRIMARY KEY", ",",
+	"PRIMARY KEY", ",", "NOT NULL", "UNIQUE", "DEFAULT",
 }
 
 func (p *Parser) peekWithLength() (string, int) {
@@ -232,11 +410,23 @@ func (p *Parser) peekWithLength() (string, int) {
 		return "", 0
 	}
 
+	// SQLite quotes the new name of a renamed table
+	if p.sql[p.i] == '"' {
+		return p.peekQuotedWithLength()
+	}
+
 	for _, token := range tokens {
 		t := strings.ToUpper(p.sql[p.i:min(len(p.sql), p.i+len(token))])
-		if token == t {
-			return t, len(t)
+		if token != t {
+			continue
+		}
+
+		// keywords must not be the prefix of an identifier
+		if end := p.i + len(t); pattern.MatchString(t[len(t)-1:]) && end < len(p.sql) && pattern.MatchString(p.sql[end:end+1]) {
+			continue
 		}
+
+		return t, len(t)
 	}
 
 	return p.peekIdentifierWithLength()
@@ -253,6 +443,28 @@ func (p *Parser) peekIdentifierWithLength() (string, int) {
 	return p.sql[p.i:], len(p.sql[p.i:])
 }
 
+// peekQuotedWithLength returns the unquoted identifier,
+// and the length of it with its quotes.
+func (p *Parser) peekQuotedWithLength() (string, int) {
+	i := p.i + 1
+
+	for ; i < len(p.sql); i++ {
+		if p.sql[i] != '"' {
+			continue
+		}
+
+		// escaped quote
+		if i+1 < len(p.sql) && p.sql[i+1] == '"' {
+			i++
+			continue
+		}
+
+		return strings.ReplaceAll(p.sql[p.i+1:i], `""`, `"`), i + 1 - p.i
+	}
+
+	return p.sql[p.i+1:], len(p.sql) - p.i
+}
+
 func (p *Parser) popWhitespace() {
 	for ; p.i < len(p.sql) && p.sql[p.i] == ' '; p.i++ {
 	}
@@ -45,6 +45,85 @@ func TestParseSql(t *testing.T) {
 				{Name: "other", Type: "BLOB", PrimaryKey: false},
 			},
 		},
+		{
+
+			name: "constraints",
+			sql: `CREATE TABLE IF NOT EXISTS my_table (
+                    id integer NOT NULL,
+                    name text NOT NULL DEFAULT 'it''s',
+                    score real DEFAULT -1.5,
+                    email text UNIQUE,
+                    a text,
+                    b text,
+                    PRIMARY KEY (id),
+                    UNIQUE (a, b)
+                  );`,
+			wantTable: "my_table",
+			wantCols: []sqlgen.ColDef{
+				{Name: "id", Type: "integer", PrimaryKey: true, NotNull: true},
+				{Name: "name", Type: "text", NotNull: true, Default: "'it''s'"},
+				{Name: "score", Type: "real", Default: "-1.5"},
+				{Name: "email", Type: "text", Unique: []string{"my_table_email_key"}},
+				{Name: "a", Type: "text", Unique: []string{"my_table_a_b_key"}},
+				{Name: "b", Type: "text", Unique: []string{"my_table_a_b_key"}},
+			},
+		},
+		{
+
+			name: "keyword prefixed column names",
+			sql: `CREATE TABLE my_table (
+                    aspect text,
+                    unique_code text,
+                    defaulted integer
+                  );`,
+			wantTable: "my_table",
+			wantCols: []sqlgen.ColDef{
+				{Name: "aspect", Type: "text"},
+				{Name: "unique_code", Type: "text"},
+				{Name: "defaulted", Type: "integer"},
+			},
+		},
+		{
+
+			name: "unknown constraints",
+			sql: `CREATE TABLE my_table (
+                    id integer PRIMARY KEY AUTOINCREMENT,
+                    name text COLLATE NOCASE NOT NULL,
+                    score real CHECK (score >= 0 AND name <> 'a (b'),
+                    parent integer REFERENCES my_table(id) ON DELETE CASCADE,
+                    untyped,
+                    CONSTRAINT positive CHECK (id > 0),
+                    FOREIGN KEY (parent) REFERENCES my_table (id)
+                  );`,
+			wantTable: "my_table",
+			wantCols: []sqlgen.ColDef{
+				{Name: "id", Type: "integer", PrimaryKey: true},
+				{Name: "name", Type: "text", NotNull: true},
+				{Name: "score", Type: "real"},
+				{Name: "parent", Type: "integer"},
+				{Name: "untyped", Type: ""},
+			},
+		},
+		{
+
+			name:      "renamed table",
+			sql:       `CREATE TABLE "names" (id integer, "first ""name""" text, PRIMARY KEY ("first ""name"""))`,
+			wantTable: "names",
+			wantCols: []sqlgen.ColDef{
+				{Name: "id", Type: "integer"},
+				{Name: `first "name"`, Type: "text", PrimaryKey: true},
+			},
+		},
+		{
+
+			name:      "sqlite_sequence",
+			sql:       `CREATE TABLE sqlite_sequence(name,seq)`,
+			wantTable: "sqlite_sequence",
+			wantCols: []sqlgen.ColDef{
+				{Name: "name", Type: ""},
+				{Name: "seq", Type: ""},
+			},
+		},
 	}
 
 	for i := range tests {
@@ -0,0 +1,45 @@
+package sqlgen
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SequenceDef is the state of an upstream sequence.
+type SequenceDef struct {
+	Name      string
+	LastValue int64
+	Increment int64
+
+	// Called is false until nextval is first called on the
+	// sequence, and the next value is LastValue itself.
+	Called bool
+}
+
+// Next is the value nextval would return upstream.
+func (s SequenceDef) Next() int64 {
+	if !s.Called {
+		return s.LastValue
+	}
+
+	return s.LastValue + s.Increment
+}
+
+// Sequences replaces the sequences stored locally with the upstream's,
+// so sequences dropped upstream are removed. It's run in a transaction,
+// so readers never see the sequences missing.
+func (s *Sqlite) Sequences(defs []SequenceDef) string {
+	buf := &strings.Builder{}
+
+	buf.WriteString("DELETE FROM postgres_sequences;")
+
+	for _, def := range defs {
+		fmt.Fprintf(
+			buf,
+			" INSERT INTO postgres_sequences (name, last_value, increment_by, is_called) VALUES ('%s', %d, %d, %t);",
+			strings.ReplaceAll(def.Name, "'", "''"), def.LastValue, def.Increment, def.Called,
+		)
+	}
+
+	return buf.String()
+}
@@ -0,0 +1,29 @@
+package sqlgen_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zknill/sqledge/pkg/sqlgen"
+)
+
+func TestSequences(t *testing.T) {
+	gen := sqlgen.NewSqlite(sqlgen.SqliteConfig{}, nil)
+
+	got := gen.Sequences([]sqlgen.SequenceDef{
+		{Name: "names_id_seq", LastValue: 42, Increment: 1, Called: true},
+		{Name: "it's_seq", LastValue: 100, Increment: -10},
+	})
+
+	assert.Equal(t, "DELETE FROM postgres_sequences;"+
+		" INSERT INTO postgres_sequences (name, last_value, increment_by, is_called) VALUES ('names_id_seq', 42, 1, true);"+
+		" INSERT INTO postgres_sequences (name, last_value, increment_by, is_called) VALUES ('it''s_seq', 100, -10, false);", got)
+
+	assert.Equal(t, "DELETE FROM postgres_sequences;", gen.Sequences(nil))
+}
+
+func TestSequenceNext(t *testing.T) {
+	assert.Equal(t, int64(43), sqlgen.SequenceDef{LastValue: 42, Increment: 1, Called: true}.Next())
+	assert.Equal(t, int64(90), sqlgen.SequenceDef{LastValue: 100, Increment: -10, Called: true}.Next())
+	assert.Equal(t, int64(1), sqlgen.SequenceDef{LastValue: 1, Increment: 1}.Next())
+}
@@ -4,16 +4,33 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/jackc/pglogrepl"
 	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/rs/zerolog/log"
+)
+
+const rebuildTablePrefix = "_sqledge_rebuild_"
+
+var ErrNoReplicaIdentity = errors.New("no replica identity")
+
+// KeylessPolicy is how updates and deletes are handled for
+// tables that have no replica identity to match rows on.
+type KeylessPolicy string
+
+const (
+	KeylessError KeylessPolicy = "error"
+	KeylessSkip  KeylessPolicy = "skip"
 )
 
 type SqliteConfig struct {
 	SourceDB    string
 	Plugin      string
 	Publication string
+	Keyless     KeylessPolicy
 }
 
 type Sqlite struct {
@@ -24,6 +41,10 @@ type Sqlite struct {
 
 	cfg SqliteConfig
 
+	// objects looks up the indexes and triggers on a table,
+	// to create them again when the table is rebuilt
+	objects func(table string) ([]SchemaObject, error)
+
 	// TODO: move these to the parent
 	// tx  bool
 	pos pglogrepl.LSN
@@ -40,6 +61,12 @@ func NewSqlite(cfg SqliteConfig, current map[string]map[string]ColDef) *Sqlite {
 	return s
 }
 
+// LookupObjects sets how the indexes and triggers on a table are found,
+// usually SqliteDriver.TableObjects. Without it a rebuilt table loses them.
+func (s *Sqlite) LookupObjects(f func(table string) ([]SchemaObject, error)) {
+	s.objects = f
+}
+
 // SQLite data types:
 // NULL.    The value is a NULL value.
 // INTEGER. The value is a signed integer,
@@ -70,82 +97,107 @@ var mappedSqLiteTypes = map[ColType]ColType{
 	PgColTypeBool:   SQLiteColTypeText,
 }
 
+// SQLiteType is the SQLite type a postgres column is copied
+// as, arrays and unknown types are copied as text.
+func SQLiteType(col ColDef) ColType {
+	if t, ok := mappedSqLiteTypes[col.Type]; ok && !col.Array {
+		return t
+	}
+
+	return SQLiteColTypeText
+}
+
 func (s *Sqlite) Relation(msg *pglogrepl.RelationMessageV2) (string, error) {
 	s.relations[msg.RelationID] = msg
 
+	cols, err := s.relationCols(msg)
+	if err != nil {
+		return "", err
+	}
+
 	ccols, exists := s.current[msg.RelationName]
 	if !exists {
-		// TODO (bug): tables created in the initial copy
-		// aren't updated in this objects column definitions
 		// CREATE TABLE
 		// doesn't exist as current table
-		currentCols := map[string]ColDef{}
+		return s.createTable(msg.RelationName, cols), nil
+	}
 
-		buf := &bytes.Buffer{}
-		pk := []string{}
+	// with REPLICA IDENTITY FULL or NOTHING the key flags don't
+	// describe the primary key, so keep the current primary key
+	keyed := hasKeyFlags(msg)
 
-		for idx, col := range msg.Columns {
-			dt, ok := s.typeMap.TypeForOID(col.DataType)
-			if !ok {
-				return "", errors.New("unknown type")
-			}
+	rebuild := false
 
-			mappedType := SQLiteColTypeText
-
-			if mt, ok := mappedSqLiteTypes[ColType(dt.Name)]; ok {
-				mappedType = mt
-			}
+	for i, col := range cols {
+		ccol, ok := ccols[col.Name]
+		if !ok {
+			continue
+		}
 
-			cd := ColDef{
-				Type: mappedType,
-			}
+		if !keyed {
+			cols[i].PrimaryKey = ccol.PrimaryKey
+		}
 
-			if col.Flags == 1 {
-				pk = append(pk, col.Name)
-				cd.PrimaryKey = true
-			}
+		// keep the constraints only known from the initial copy
+		cols[i].NotNull = ccol.NotNull
+		cols[i].Default = ccol.Default
+		cols[i].Unique = ccol.Unique
 
-			fmt.Fprintf(buf, "%s %s", col.Name, mappedType)
+		if cols[i].PrimaryKey != ccol.PrimaryKey || !strings.EqualFold(string(ccol.Type), string(col.Type)) {
+			rebuild = true
+		}
+	}
 
-			if idx < len(msg.Columns)-1 {
-				buf.WriteString(", ")
-			}
+	dropped := []string{}
 
-			currentCols[col.Name] = cd
+	for name, ccol := range ccols {
+		if containsCol(cols, name) {
+			continue
 		}
 
-		var pks string
-
-		if len(pk) != 0 {
-			pks = ", PRIMARY KEY (" + strings.Join(pk, ", ") + ") "
+		// SQLite can't drop primary key or unique columns
+		if ccol.PrimaryKey || len(ccol.Unique) > 0 {
+			rebuild = true
 		}
 
-		s.current[msg.RelationName] = currentCols
+		dropped = append(dropped, name)
+	}
 
-		return fmt.Sprintf(
-			"CREATE TABLE IF NOT EXISTS %s (%s%s);",
-			msg.RelationName,
-			buf.String(),
-			pks,
-		), nil
+	if rebuild {
+		return s.rebuildTable(msg.RelationName, cols, ccols)
 	}
 
 	// ALTER TABLE
 	statements := []string{}
 
-	colsCovered := map[string]ColDef{}
+	for _, col := range cols {
+		if _, ok := ccols[col.Name]; ok {
+			continue
+		}
 
-	for k, v := range ccols {
-		v := v
-		colsCovered[k] = v
+		statements = append(statements, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", msg.RelationName, col.Name, col.Type))
+		ccols[col.Name] = col
 	}
 
-	for _, col := range msg.Columns {
-		delete(colsCovered, col.Name)
+	sort.Strings(dropped)
+
+	for _, name := range dropped {
+		statements = append(statements, fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s;", msg.RelationName, name))
+		delete(ccols, name)
+	}
 
+	return strings.Join(statements, " "), nil
+}
+
+// relationCols maps the relation columns to SQLite column definitions.
+func (s *Sqlite) relationCols(msg *pglogrepl.RelationMessageV2) ([]ColDef, error) {
+	keyed := hasKeyFlags(msg)
+	cols := make([]ColDef, 0, len(msg.Columns))
+
+	for _, col := range msg.Columns {
 		dt, ok := s.typeMap.TypeForOID(col.DataType)
 		if !ok {
-			return "", errors.New("unknown type")
+			return nil, errors.New("unknown type")
 		}
 
 		mappedType := SQLiteColTypeText
@@ -154,63 +206,148 @@ func (s *Sqlite) Relation(msg *pglogrepl.RelationMessageV2) (string, error) {
 			mappedType = mt
 		}
 
-		ccol, ok := ccols[col.Name]
-		if !ok {
-			statements = append(statements, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", msg.RelationName, col.Name, mappedType))
-			ccols[col.Name] = ColDef{
-				Name: col.Name,
-				Type: mappedType,
-			}
-			continue
+		cols = append(cols, ColDef{
+			Name:       col.Name,
+			Type:       mappedType,
+			PrimaryKey: keyed && col.Flags == 1,
+		})
+	}
+
+	return cols, nil
+}
+
+// hasKeyFlags reports if the column flags of the relation mark the
+// primary key (or replica identity index) columns.
+func hasKeyFlags(msg *pglogrepl.RelationMessageV2) bool {
+	return msg.ReplicaIdentity == 'd' || msg.ReplicaIdentity == 'i'
+}
+
+func containsCol(cols []ColDef, name string) bool {
+	for _, c := range cols {
+		if c.Name == name {
+			return true
 		}
+	}
 
-		pk := col.Flags == 1
+	return false
+}
 
-		if ccol.PrimaryKey && !pk {
-			// TODO: DROP PK
+// rebuildTable changes the schema of a table in ways that ALTER TABLE
+// in SQLite doesn't support, like changing the primary key or column
+// types, following the SQLite generalized ALTER TABLE procedure. A new
+// table is created, the data copied and cast to the new column types,
+// the old table is dropped and the new one renamed, and the indexes and
+// triggers dropped with it are created again. Indexes on columns that
+// no longer exist are left out. The statements run in the transaction
+// applying the relation message.
+func (s *Sqlite) rebuildTable(table string, cols []ColDef, old map[string]ColDef) (string, error) {
+	tmp := rebuildTablePrefix + table
+
+	var objects []SchemaObject
+
+	if s.objects != nil {
+		var err error
+
+		// read before the drop, as they go with the table
+		if objects, err = s.objects(table); err != nil {
+			return "", fmt.Errorf("rebuild %s: %w", table, err)
 		}
+	}
+
+	names := []string{}
+	values := []string{}
 
-		if pk && !ccol.PrimaryKey {
-			// TODO: ADD PK
+	for _, col := range cols {
+		ccol, ok := old[col.Name]
+		if !ok {
+			// new columns take their default
+			continue
 		}
 
-		if ccol.Type != mappedType {
-			// TODO: Change col type, not supported in SQLite
+		names = append(names, col.Name)
+
+		if strings.EqualFold(string(ccol.Type), string(col.Type)) {
+			values = append(values, col.Name)
+			continue
 		}
+
+		values = append(values, fmt.Sprintf("CAST(%s AS %s)", col.Name, col.Type))
+	}
+
+	statements := []string{
+		fmt.Sprintf("DROP TABLE IF EXISTS %s;", tmp),
+		tableDDL(tmp, cols),
+		fmt.Sprintf(
+			"INSERT INTO %s (%s) SELECT %s FROM %s;",
+			tmp, strings.Join(names, ", "), strings.Join(values, ", "), table,
+		),
+		fmt.Sprintf("DROP TABLE %s;", table),
+		fmt.Sprintf("ALTER TABLE %s RENAME TO %s;", tmp, table),
 	}
 
-	for k, v := range colsCovered {
-		if v.PrimaryKey {
-			// dropping PK cols not supported in sqlite
+	for _, obj := range objects {
+		if !hasCols(cols, obj.Columns) {
+			log.Warn().Msgf("not creating %s %q again after rebuilding %s, its columns were dropped", obj.Type, obj.Name, table)
 			continue
 		}
 
-		statements = append(statements, fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s;", msg.RelationName, k))
+		statements = append(statements, strings.TrimSuffix(strings.TrimSpace(obj.SQL), ";")+";")
 	}
 
+	s.setCurrent(table, cols)
+
 	return strings.Join(statements, " "), nil
 }
 
+func hasCols(cols []ColDef, names []string) bool {
+	for _, name := range names {
+		if !containsCol(cols, name) {
+			return false
+		}
+	}
+
+	return true
+}
+
 // Insert represents a single row insert.
 // Multiple VALUES (...) inserted at once
 // would be multiple calls to this Insert method.
 func (s *Sqlite) Insert(msg *pglogrepl.InsertMessageV2) (string, error) {
+	stmt, err := s.InsertStmt(msg)
+	return stmt.String(), err
+}
+
+func (s *Sqlite) Update(msg *pglogrepl.UpdateMessageV2) (string, error) {
+	stmt, err := s.UpdateStmt(msg)
+	return stmt.String(), err
+}
+
+func (s *Sqlite) Delete(msg *pglogrepl.DeleteMessageV2) (string, error) {
+	stmt, err := s.DeleteStmt(msg)
+	return stmt.String(), err
+}
+
+func (s *Sqlite) InsertStmt(msg *pglogrepl.InsertMessageV2) (Stmt, error) {
 	rel, ok := s.relations[msg.RelationID]
 	if !ok {
-		return "", errors.New("unknown relation")
+		return Stmt{}, errors.New("unknown relation")
 	}
 
 	cols, err := s.parseColums(rel, msg.Tuple.Columns)
 	if err != nil {
-		return "", fmt.Errorf("insert: %w", err)
+		return Stmt{}, fmt.Errorf("insert: %w", err)
 	}
 
+	stmt := Stmt{RelationID: rel.RelationID}
+
 	cBuf := &bytes.Buffer{}
 	vBuf := &bytes.Buffer{}
 
 	for idx, col := range cols {
 		cBuf.WriteString(col.name)
-		fmt.Fprintf(vBuf, "%v", col.val())
+		vBuf.WriteString("?")
+
+		stmt.Args = append(stmt.Args, col.value)
 
 		if idx < len(cols)-1 {
 			cBuf.WriteString(", ")
@@ -218,89 +355,134 @@ func (s *Sqlite) Insert(msg *pglogrepl.InsertMessageV2) (string, error) {
 		}
 	}
 
-	return fmt.Sprintf(
+	stmt.SQL = fmt.Sprintf(
 		"INSERT INTO %s (%s) VALUES (%s);",
 		rel.RelationName,
 		cBuf.String(),
 		vBuf.String(),
-	), nil
+	)
+
+	return stmt, nil
 }
 
-func (s *Sqlite) Update(msg *pglogrepl.UpdateMessageV2) (string, error) {
+// UpdateStmt represents a single row update. Unchanged TOAST
+// values aren't sent by postgres, so they're left as they are.
+func (s *Sqlite) UpdateStmt(msg *pglogrepl.UpdateMessageV2) (Stmt, error) {
 	rel, ok := s.relations[msg.RelationID]
 	if !ok {
-		return "", errors.New("unknown relation")
+		return Stmt{}, errors.New("unknown relation")
 	}
 
 	cols, err := s.parseColums(rel, msg.NewTuple.Columns)
 	if err != nil {
-		return "", fmt.Errorf("new: %w", err)
+		return Stmt{}, fmt.Errorf("new: %w", err)
 	}
 
-	whereCols := cols
+	stmt := Stmt{RelationID: rel.RelationID}
+	set := []string{}
 
-	if msg.OldTuple != nil {
-		// what happens on delete col?
-		whereCols, err = s.parseColums(rel, msg.OldTuple.Columns)
-		if err != nil {
-			return "", fmt.Errorf("old: %w", err)
-		}
-	}
-
-	buf := &bytes.Buffer{}
 	for _, col := range cols {
 		if col.key && msg.OldTuple == nil {
+			// key is unchanged, and used in the where clause
 			continue
 		}
 
-		fmt.Fprint(buf, col.kvSql(), ",")
-
+		set = append(set, col.name+"=?")
+		stmt.Args = append(stmt.Args, col.value)
 	}
 
-	kBuf := &bytes.Buffer{}
-
-	for _, col := range whereCols {
-		if !col.key {
-			continue
-		}
-
-		fmt.Fprint(kBuf, col.kvSql(), " AND ")
+	where, whereArgs, err := s.where(rel, msg.OldTuple, cols)
+	if err != nil || where == "" || len(set) == 0 {
+		return Stmt{}, err
 	}
 
-	return fmt.Sprintf(
+	stmt.SQL = fmt.Sprintf(
 		"UPDATE %s SET %s WHERE %s;",
 		rel.RelationName,
-		buf.String()[:len(buf.String())-1],
-		kBuf.String()[:len(kBuf.String())-5],
-	), nil
+		strings.Join(set, ", "),
+		where,
+	)
+	stmt.Args = append(stmt.Args, whereArgs...)
+
+	return stmt, nil
 }
 
-func (s *Sqlite) Delete(msg *pglogrepl.DeleteMessageV2) (string, error) {
+func (s *Sqlite) DeleteStmt(msg *pglogrepl.DeleteMessageV2) (Stmt, error) {
 	rel, ok := s.relations[msg.RelationID]
 	if !ok {
-		return "", errors.New("unknown relation")
+		return Stmt{}, errors.New("unknown relation")
 	}
 
-	cols, err := s.parseColums(rel, msg.OldTuple.Columns)
-	if err != nil {
-		return "", fmt.Errorf("new: %w", err)
+	where, args, err := s.where(rel, msg.OldTuple, nil)
+	if err != nil || where == "" {
+		return Stmt{}, err
+	}
+
+	return Stmt{
+		RelationID: rel.RelationID,
+		SQL:        fmt.Sprintf("DELETE FROM %s WHERE %s;", rel.RelationName, where),
+		Args:       args,
+	}, nil
+}
+
+// where builds the WHERE clause matching the row changed by an
+// update or delete. With REPLICA IDENTITY FULL the old tuple has all
+// the columns, and the first row matching all of them is changed.
+// Otherwise the old tuple, if sent, has the key columns. If it isn't
+// sent, the key is unchanged and read from the new tuple.
+func (s *Sqlite) where(rel *pglogrepl.RelationMessageV2, old *pglogrepl.TupleData, cols []*column) (string, []any, error) {
+	if old != nil {
+		var err error
+
+		cols, err = s.parseColums(rel, old.Columns)
+		if err != nil {
+			return "", nil, fmt.Errorf("old: %w", err)
+		}
 	}
 
-	kBuf := &bytes.Buffer{}
+	conds := []string{}
+	args := []any{}
 
 	for _, col := range cols {
 		if !col.key {
 			continue
 		}
 
-		fmt.Fprint(kBuf, col.kvSql(), " AND ")
+		if col.value == nil {
+			conds = append(conds, col.name+" IS NULL")
+			continue
+		}
+
+		conds = append(conds, col.name+"=?")
+		args = append(args, col.value)
 	}
 
-	return fmt.Sprintf(
-		"DELETE FROM %s WHERE %s;",
-		rel.RelationName,
-		kBuf.String()[:len(kBuf.String())-5],
-	), nil
+	if len(conds) == 0 {
+		where, err := s.keyless(rel)
+		return where, nil, err
+	}
+
+	if rel.ReplicaIdentity == 'f' {
+		// tables without a key can have duplicate rows
+		return fmt.Sprintf(
+			"rowid = (SELECT rowid FROM %s WHERE %s LIMIT 1)",
+			rel.RelationName,
+			strings.Join(conds, " AND "),
+		), args, nil
+	}
+
+	return strings.Join(conds, " AND "), args, nil
+}
+
+// keyless handles updates and deletes to tables with no replica
+// identity to match the changed row on.
+func (s *Sqlite) keyless(rel *pglogrepl.RelationMessageV2) (string, error) {
+	if s.cfg.Keyless == KeylessSkip {
+		log.Warn().Msgf("skipping change to %q, table has no replica identity", rel.RelationName)
+		return "", nil
+	}
+
+	return "", fmt.Errorf("%q: %w", rel.RelationName, ErrNoReplicaIdentity)
 }
 
 func (s *Sqlite) Truncate(msg *pglogrepl.TruncateMessageV2) (string, error) {
@@ -356,97 +538,171 @@ func (s *Sqlite) Pos(p string) string {
 }
 
 func (s *Sqlite) CopyCreateTable(schema, tableName string, colDefs []ColDef) (string, error) {
-	query := `CREATE TABLE IF NOT EXISTS ` + tableName + ` ( `
+	cols := make([]ColDef, 0, len(colDefs))
+
+	for _, col := range colDefs {
+		def, _ := sqliteDefault(col.Default)
+
+		cols = append(cols, ColDef{
+			Name:       col.Name,
+			Type:       SQLiteType(col),
+			PrimaryKey: col.PrimaryKey,
+			NotNull:    col.NotNull,
+			Default:    def,
+			Unique:     col.Unique,
+		})
+	}
 
-	for i, col := range colDefs {
+	return s.createTable(tableName, cols), nil
+}
 
-		mt := SQLiteColTypeText
+// createTable builds the CREATE TABLE statement for cols, which
+// must already have SQLite types, and tracks the table as current.
+func (s *Sqlite) createTable(tableName string, cols []ColDef) string {
+	s.setCurrent(tableName, cols)
 
-		if t, ok := mappedSqLiteTypes[col.Type]; ok && !col.Array {
-			mt = t
-		}
+	return tableDDL(tableName, cols)
+}
 
-		query += fmt.Sprintf("%s %s", col.Name, mt)
-		if i < len(colDefs)-1 {
-			query += ", "
-		}
-	}
+func (s *Sqlite) setCurrent(tableName string, cols []ColDef) {
+	currentCols := map[string]ColDef{}
 
-	query += ");"
+	for _, col := range cols {
+		currentCols[col.Name] = col
+	}
 
-	return query, nil
+	s.current[tableName] = currentCols
 }
 
-func (s *Sqlite) InsertCopyRow(schema, tableName string, colDefs []ColDef, rowValues []string) (string, error) {
-	query := `INSERT INTO %s VALUES ( %s );`
+func tableDDL(tableName string, cols []ColDef) string {
+	buf := &bytes.Buffer{}
+	pk := []string{}
 
-	var row string
-	for i, v := range rowValues {
-		if v == "null" {
-			row += "null"
-		} else {
-			row += "'" + v + "'"
+	// constraint name -> columns
+	unique := map[string][]string{}
+	uniqueNames := []string{}
+
+	for idx, col := range cols {
+		fmt.Fprintf(buf, "%s %s", col.Name, col.Type)
+
+		if col.NotNull {
+			buf.WriteString(" NOT NULL")
+		}
+
+		if col.Default != "" {
+			buf.WriteString(" DEFAULT " + col.Default)
+		}
+
+		if idx < len(cols)-1 {
+			buf.WriteString(", ")
+		}
+
+		if col.PrimaryKey {
+			pk = append(pk, col.Name)
 		}
 
-		if i < len(rowValues)-1 {
-			row += ","
+		for _, u := range col.Unique {
+			if _, ok := unique[u]; !ok {
+				uniqueNames = append(uniqueNames, u)
+			}
+
+			unique[u] = append(unique[u], col.Name)
 		}
 	}
 
-	return fmt.Sprintf(query, tableName, row), nil
-}
+	if len(pk) != 0 {
+		buf.WriteString(", PRIMARY KEY (" + strings.Join(pk, ", ") + ")")
+	}
 
-type column struct {
-	name   string
-	value  interface{}
-	binary []byte
-	key    bool
+	for _, u := range uniqueNames {
+		buf.WriteString(", UNIQUE (" + strings.Join(unique[u], ", ") + ")")
+	}
+
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", tableName, buf.String())
 }
 
-func (c *column) kvSql() string {
-	return c.name + "=" + c.val()
+var (
+	pgCastLiteral = regexp.MustCompile(`^('(?:[^']|'')*')::[a-zA-Z0-9_ "\[\]]+$`)
+	pgNumLiteral  = regexp.MustCompile(`^\(?(-?[0-9]+(?:\.[0-9]+)?)\)?$`)
+)
+
+// sqliteDefault translates a postgres column default expression
+// into an SQLite default. Only literal values can be translated,
+// function calls like nextval() or now() are not.
+func sqliteDefault(expr string) (string, bool) {
+	expr = strings.TrimSpace(expr)
+
+	switch {
+	case expr == "":
+		return "", false
+	case expr == "true" || expr == "false":
+		return "'" + expr + "'", true
+	case pgNumLiteral.MatchString(expr):
+		return pgNumLiteral.FindStringSubmatch(expr)[1], true
+	case pgCastLiteral.MatchString(expr):
+		return pgCastLiteral.FindStringSubmatch(expr)[1], true
+	}
+
+	return "", false
 }
 
-func (c *column) val() string {
-	if c.value == "null" {
-		return "null"
+// InsertCopyRow inserts a row from the initial copy, the
+// values are strings, or nil for NULL. Bytea values are
+// inserted as blobs.
+func (s *Sqlite) InsertCopyRow(schema, tableName string, colDefs []ColDef, rowValues []any) (string, error) {
+	if len(rowValues) != len(colDefs) {
+		return "", fmt.Errorf("row has %d values, table %q has %d columns", len(rowValues), tableName, len(colDefs))
 	}
 
-	if c.binary != nil {
-		return fmt.Sprintf("x'%v'", c.binary)
+	values := make([]string, len(rowValues))
+
+	for i, v := range rowValues {
+		if str, ok := v.(string); ok && SQLiteType(colDefs[i]) == SQLiteColTypeBlob {
+			v = []byte(str)
+		}
+
+		values[i] = literal(v)
 	}
 
-	return fmt.Sprintf("'%v'", c.value)
+	return fmt.Sprintf("INSERT INTO %s VALUES ( %s );", tableName, strings.Join(values, ",")), nil
+}
+
+type column struct {
+	name string
+	// value is nil, a string for text values,
+	// or a []byte for binary values
+	value any
+	key   bool
 }
 
+// parseColums parses the tuple columns that have a value, unchanged
+// TOAST values aren't sent by postgres and are left out.
 func (s *Sqlite) parseColums(rel *pglogrepl.RelationMessageV2, cols []*pglogrepl.TupleDataColumn) ([]*column, error) {
-	out := make([]*column, len(cols))
+	out := make([]*column, 0, len(cols))
 
 	for idx, col := range cols {
+		if idx >= len(rel.Columns) {
+			return nil, fmt.Errorf("tuple has %d columns, relation %q has %d", len(cols), rel.RelationName, len(rel.Columns))
+		}
+
+		c := &column{
+			name: rel.Columns[idx].Name,
+			key:  rel.Columns[idx].Flags == 1,
+		}
+
 		switch col.DataType {
 		case 'n':
-			out[idx] = &column{
-				name:  rel.Columns[idx].Name,
-				value: "null",
-				key:   rel.Columns[idx].Flags == 1,
-			}
+			c.value = nil
 		case 'u':
 			// unchanged
+			continue
 		case 't':
-			data := col.Data
-
-			out[idx] = &column{
-				name:  rel.Columns[idx].Name,
-				value: string(data),
-				key:   rel.Columns[idx].Flags == 1,
-			}
+			c.value = string(col.Data)
 		case 'b':
-			out[idx] = &column{
-				name:   rel.Columns[idx].Name,
-				binary: col.Data,
-				key:    rel.Columns[idx].Flags == 1,
-			}
+			c.value = col.Data
 		}
+
+		out = append(out, c)
 	}
 
 	return out, nil
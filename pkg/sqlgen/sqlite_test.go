@@ -0,0 +1,356 @@
+package sqlgen_test
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zknill/sqledge/pkg/sqlgen"
+)
+
+func TestCopyCreateTable(t *testing.T) {
+	gen := sqlgen.NewSqlite(sqlgen.SqliteConfig{}, map[string]map[string]sqlgen.ColDef{})
+
+	defs := []sqlgen.ColDef{
+		{Name: "id", Type: sqlgen.PgColTypeInt4, PrimaryKey: true, NotNull: true, Default: "nextval('names_id_seq'::regclass)"},
+		{Name: "name", Type: sqlgen.PgColTypeText, NotNull: true, Default: "'anon'::text"},
+		{Name: "score", Type: sqlgen.PgColTypeNum, Default: "'-1.5'::numeric"},
+		{Name: "active", Type: sqlgen.PgColTypeBool, Default: "true"},
+		{Name: "email", Type: sqlgen.PgColTypeText, Unique: []string{"names_email_key"}},
+		{Name: "tags", Type: sqlgen.PgColTypeText, Array: true},
+	}
+
+	got, err := gen.CopyCreateTable("public", "names", defs)
+	assert.NoError(t, err)
+
+	want := "CREATE TABLE IF NOT EXISTS names (" +
+		"id integer NOT NULL, " +
+		"name text NOT NULL DEFAULT 'anon', " +
+		"score real DEFAULT '-1.5', " +
+		"active text DEFAULT 'true', " +
+		"email text, " +
+		"tags text, " +
+		"PRIMARY KEY (id), UNIQUE (email));"
+
+	assert.Equal(t, want, got)
+
+	// the generated table must parse back to the same schema
+	table, cols, err := sqlgen.NewParser(got).Parse()
+	assert.NoError(t, err)
+	assert.Equal(t, "names", table)
+	assert.Equal(t, []sqlgen.ColDef{
+		{Name: "id", Type: "integer", PrimaryKey: true, NotNull: true},
+		{Name: "name", Type: "text", NotNull: true, Default: "'anon'"},
+		{Name: "score", Type: "real", Default: "'-1.5'"},
+		{Name: "active", Type: "text", Default: "'true'"},
+		{Name: "email", Type: "text", Unique: []string{"names_email_key"}},
+		{Name: "tags", Type: "text"},
+	}, cols)
+}
+
+func TestInsertCopyRow(t *testing.T) {
+	gen := sqlgen.NewSqlite(sqlgen.SqliteConfig{}, map[string]map[string]sqlgen.ColDef{})
+
+	defs := []sqlgen.ColDef{
+		{Name: "id", Type: sqlgen.PgColTypeInt4},
+		{Name: "name", Type: sqlgen.PgColTypeText},
+		{Name: "nickname", Type: sqlgen.PgColTypeText},
+		{Name: "note", Type: sqlgen.PgColTypeText},
+		{Name: "avatar", Type: sqlgen.PgColTypeBytea},
+	}
+
+	got, err := gen.InsertCopyRow("public", "names", defs, []any{"1", "O'Brien", "null", nil, "ab"})
+	assert.NoError(t, err)
+	assert.Equal(t, "INSERT INTO names VALUES ( '1','O''Brien','null',null,x'6162' );", got)
+
+	_, err = gen.InsertCopyRow("public", "names", defs, []any{"1"})
+	assert.Error(t, err)
+}
+
+func relation(identity uint8, cols ...*pglogrepl.RelationMessageColumn) *pglogrepl.RelationMessageV2 {
+	msg := &pglogrepl.RelationMessageV2{}
+	msg.RelationID = 1
+	msg.RelationName = "names"
+	msg.ReplicaIdentity = identity
+	msg.Columns = cols
+
+	return msg
+}
+
+func TestRelationRebuild(t *testing.T) {
+	current := func() map[string]map[string]sqlgen.ColDef {
+		return map[string]map[string]sqlgen.ColDef{
+			"names": {
+				"id":   {Name: "id", Type: sqlgen.SQLiteColTypeInteger, PrimaryKey: true, NotNull: true},
+				"name": {Name: "name", Type: sqlgen.SQLiteColTypeText},
+				"age":  {Name: "age", Type: sqlgen.SQLiteColTypeText},
+			},
+		}
+	}
+
+	id := &pglogrepl.RelationMessageColumn{Flags: 1, Name: "id", DataType: pgtype.Int4OID}
+	name := &pglogrepl.RelationMessageColumn{Name: "name", DataType: pgtype.TextOID}
+	age := &pglogrepl.RelationMessageColumn{Name: "age", DataType: pgtype.TextOID}
+
+	tests := []struct {
+		name string
+		msg  *pglogrepl.RelationMessageV2
+		want string
+	}{
+		{
+			name: "add and drop column",
+			msg:  relation('d', id, name, &pglogrepl.RelationMessageColumn{Name: "email", DataType: pgtype.TextOID}),
+			want: "ALTER TABLE names ADD COLUMN email text; ALTER TABLE names DROP COLUMN age;",
+		},
+		{
+			name: "change column type",
+			msg:  relation('d', id, name, &pglogrepl.RelationMessageColumn{Name: "age", DataType: pgtype.Int4OID}),
+			want: "DROP TABLE IF EXISTS _sqledge_rebuild_names; " +
+				"CREATE TABLE IF NOT EXISTS _sqledge_rebuild_names (id integer NOT NULL, name text, age integer, PRIMARY KEY (id)); " +
+				"INSERT INTO _sqledge_rebuild_names (id, name, age) SELECT id, name, CAST(age AS integer) FROM names; " +
+				"DROP TABLE names; " +
+				"ALTER TABLE _sqledge_rebuild_names RENAME TO names;",
+		},
+		{
+			name: "change primary key",
+			msg:  relation('d', id, &pglogrepl.RelationMessageColumn{Flags: 1, Name: "name", DataType: pgtype.TextOID}, age),
+			want: "DROP TABLE IF EXISTS _sqledge_rebuild_names; " +
+				"CREATE TABLE IF NOT EXISTS _sqledge_rebuild_names (id integer NOT NULL, name text, age text, PRIMARY KEY (id, name)); " +
+				"INSERT INTO _sqledge_rebuild_names (id, name, age) SELECT id, name, age FROM names; " +
+				"DROP TABLE names; " +
+				"ALTER TABLE _sqledge_rebuild_names RENAME TO names;",
+		},
+		{
+			name: "drop primary key column",
+			msg:  relation('d', &pglogrepl.RelationMessageColumn{Flags: 1, Name: "name", DataType: pgtype.TextOID}, age),
+			want: "DROP TABLE IF EXISTS _sqledge_rebuild_names; " +
+				"CREATE TABLE IF NOT EXISTS _sqledge_rebuild_names (name text, age text, PRIMARY KEY (name)); " +
+				"INSERT INTO _sqledge_rebuild_names (name, age) SELECT name, age FROM names; " +
+				"DROP TABLE names; " +
+				"ALTER TABLE _sqledge_rebuild_names RENAME TO names;",
+		},
+		{
+			name: "replica identity full keeps primary key",
+			msg: relation('f',
+				id,
+				&pglogrepl.RelationMessageColumn{Flags: 1, Name: "name", DataType: pgtype.TextOID},
+				&pglogrepl.RelationMessageColumn{Flags: 1, Name: "age", DataType: pgtype.TextOID},
+			),
+			want: "",
+		},
+	}
+
+	for i := range tests {
+		test := tests[i]
+		t.Run(test.name, func(t *testing.T) {
+			gen := sqlgen.NewSqlite(sqlgen.SqliteConfig{}, current())
+
+			got, err := gen.Relation(test.msg)
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+
+	t.Run("keeps indexes and triggers", func(t *testing.T) {
+		db, err := sql.Open("sqlite3", ":memory:")
+		require.NoError(t, err)
+		defer db.Close()
+
+		db.SetMaxOpenConns(1)
+
+		driver := sqlgen.NewSqliteDriver(sqlgen.SqliteConfig{}, db)
+		require.NoError(t, driver.Execute(`CREATE TABLE names (id integer NOT NULL, name text, age text, PRIMARY KEY (id));
+		CREATE TABLE audit (id integer);
+		CREATE INDEX names_name ON names (name);
+		CREATE INDEX names_age ON names (age);
+		CREATE INDEX names_lower_name ON names (lower(name));
+		CREATE TRIGGER names_audit AFTER INSERT ON names BEGIN INSERT INTO audit VALUES (new.id); END;
+		INSERT INTO names VALUES (1, 'ann', '30');`))
+
+		schema, err := driver.CurrentSchema()
+		require.NoError(t, err)
+
+		gen := sqlgen.NewSqlite(sqlgen.SqliteConfig{}, schema)
+		gen.LookupObjects(driver.TableObjects)
+
+		// age changes type, and the primary key moves to name
+		query, err := gen.Relation(relation('d',
+			&pglogrepl.RelationMessageColumn{Name: "id", DataType: pgtype.Int4OID},
+			&pglogrepl.RelationMessageColumn{Flags: 1, Name: "name", DataType: pgtype.TextOID},
+		))
+		require.NoError(t, err)
+		require.NoError(t, driver.Execute("BEGIN; "+query+" COMMIT;"))
+
+		// SQLite quotes the name of the renamed table
+		schema, err = driver.CurrentSchema()
+		require.NoError(t, err)
+		assert.True(t, schema["names"]["name"].PrimaryKey)
+
+		objects, err := driver.TableObjects("names")
+		require.NoError(t, err)
+
+		names := []string{}
+		for _, obj := range objects {
+			names = append(names, obj.Name)
+		}
+
+		// the index on the dropped age column goes with it
+		assert.Equal(t, []string{"names_lower_name", "names_name", "names_audit"}, names)
+
+		require.NoError(t, driver.Execute("INSERT INTO names VALUES (2, 'bob');"))
+
+		var audited int
+		require.NoError(t, db.QueryRow("SELECT count(*) FROM audit").Scan(&audited))
+		assert.Equal(t, 2, audited)
+	})
+}
+
+func tuple(cols ...*pglogrepl.TupleDataColumn) *pglogrepl.TupleData {
+	return &pglogrepl.TupleData{ColumnNum: uint16(len(cols)), Columns: cols}
+}
+
+func text(v string) *pglogrepl.TupleDataColumn {
+	return &pglogrepl.TupleDataColumn{DataType: 't', Data: []byte(v)}
+}
+
+var (
+	null      = &pglogrepl.TupleDataColumn{DataType: 'n'}
+	unchanged = &pglogrepl.TupleDataColumn{DataType: 'u'}
+)
+
+func TestUpdateDelete(t *testing.T) {
+	key := func(name string) *pglogrepl.RelationMessageColumn {
+		return &pglogrepl.RelationMessageColumn{Flags: 1, Name: name, DataType: pgtype.TextOID}
+	}
+
+	col := func(name string) *pglogrepl.RelationMessageColumn {
+		return &pglogrepl.RelationMessageColumn{Name: name, DataType: pgtype.TextOID}
+	}
+
+	tests := []struct {
+		name       string
+		rel        *pglogrepl.RelationMessageV2
+		keyless    sqlgen.KeylessPolicy
+		old        *pglogrepl.TupleData
+		new        *pglogrepl.TupleData
+		wantUpdate string
+		wantDelete string
+		wantErr    error
+	}{
+		{
+			name:       "default identity",
+			rel:        relation('d', key("id"), col("name"), col("doc")),
+			new:        tuple(text("1"), text("a"), text("{}")),
+			wantUpdate: "UPDATE names SET name='a', doc='{}' WHERE id='1';",
+		},
+		{
+			name:       "default identity, key changed",
+			rel:        relation('d', key("id"), col("name"), col("doc")),
+			old:        tuple(text("1"), null, null),
+			new:        tuple(text("2"), text("a"), null),
+			wantUpdate: "UPDATE names SET id='2', name='a', doc=null WHERE id='1';",
+			wantDelete: "DELETE FROM names WHERE id='1';",
+		},
+		{
+			name:       "default identity, unchanged toast",
+			rel:        relation('d', key("id"), col("name"), col("doc")),
+			new:        tuple(text("1"), text("a"), unchanged),
+			wantUpdate: "UPDATE names SET name='a' WHERE id='1';",
+		},
+		{
+			name:       "index identity",
+			rel:        relation('i', col("id"), key("email"), col("name")),
+			old:        tuple(null, text("a@b.c"), null),
+			new:        tuple(text("1"), text("a@b.c"), text("a")),
+			wantUpdate: "UPDATE names SET id='1', email='a@b.c', name='a' WHERE email='a@b.c';",
+			wantDelete: "DELETE FROM names WHERE email='a@b.c';",
+		},
+		{
+			name:       "full identity",
+			rel:        relation('f', key("id"), key("name"), key("doc")),
+			old:        tuple(text("1"), null, unchanged),
+			new:        tuple(text("1"), text("a"), unchanged),
+			wantUpdate: "UPDATE names SET id='1', name='a' WHERE rowid = (SELECT rowid FROM names WHERE id='1' AND name IS NULL LIMIT 1);",
+			wantDelete: "DELETE FROM names WHERE rowid = (SELECT rowid FROM names WHERE id='1' AND name IS NULL LIMIT 1);",
+		},
+		{
+			name:    "nothing identity",
+			rel:     relation('n', col("id"), col("name")),
+			new:     tuple(text("1"), text("a")),
+			wantErr: sqlgen.ErrNoReplicaIdentity,
+		},
+		{
+			name:    "nothing identity, skipped",
+			rel:     relation('n', col("id"), col("name")),
+			keyless: sqlgen.KeylessSkip,
+			new:     tuple(text("1"), text("a")),
+		},
+	}
+
+	for i := range tests {
+		test := tests[i]
+		t.Run(test.name, func(t *testing.T) {
+			gen := sqlgen.NewSqlite(sqlgen.SqliteConfig{Keyless: test.keyless}, map[string]map[string]sqlgen.ColDef{})
+
+			_, err := gen.Relation(test.rel)
+			assert.NoError(t, err)
+
+			update := &pglogrepl.UpdateMessageV2{}
+			update.RelationID = test.rel.RelationID
+			update.NewTuple = test.new
+			update.OldTuple = test.old
+
+			got, err := gen.Update(update)
+			if test.wantErr != nil {
+				assert.ErrorIs(t, err, test.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, test.wantUpdate, got)
+			}
+
+			if test.old == nil {
+				return
+			}
+
+			del := &pglogrepl.DeleteMessageV2{}
+			del.RelationID = test.rel.RelationID
+			del.OldTuple = test.old
+
+			got, err = gen.Delete(del)
+			if test.wantErr != nil {
+				assert.ErrorIs(t, err, test.wantErr)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.wantDelete, got)
+		})
+	}
+}
+
+func TestInsertStmt(t *testing.T) {
+	gen := sqlgen.NewSqlite(sqlgen.SqliteConfig{}, map[string]map[string]sqlgen.ColDef{})
+
+	rel := relation('d',
+		&pglogrepl.RelationMessageColumn{Flags: 1, Name: "id", DataType: pgtype.Int4OID},
+		&pglogrepl.RelationMessageColumn{Name: "name", DataType: pgtype.TextOID},
+		&pglogrepl.RelationMessageColumn{Name: "nickname", DataType: pgtype.TextOID},
+	)
+
+	_, err := gen.Relation(rel)
+	assert.NoError(t, err)
+
+	insert := &pglogrepl.InsertMessageV2{}
+	insert.RelationID = rel.RelationID
+	insert.Tuple = tuple(text("1"), text("it's"), null)
+
+	stmt, err := gen.InsertStmt(insert)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "INSERT INTO names (id, name, nickname) VALUES (?, ?, ?);", stmt.SQL)
+	assert.Equal(t, []any{"1", "it's", nil}, stmt.Args)
+	assert.Equal(t, "INSERT INTO names (id, name, nickname) VALUES ('1', 'it''s', null);", stmt.String())
+}
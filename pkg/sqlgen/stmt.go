@@ -0,0 +1,67 @@
+package sqlgen
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Stmt is a parameterised statement for a row change, the
+// values are bound to the ? placeholders in the SQL. The SQL
+// only depends on the relation and the columns changed, so it
+// can be prepared once and reused.
+type Stmt struct {
+	RelationID uint32
+	SQL        string
+	Args       []any
+}
+
+// String renders the statement with the values inlined as literals.
+func (s Stmt) String() string {
+	if len(s.Args) == 0 {
+		return s.SQL
+	}
+
+	buf := &strings.Builder{}
+	arg := 0
+
+	for _, r := range s.SQL {
+		if r != '?' || arg >= len(s.Args) {
+			buf.WriteRune(r)
+			continue
+		}
+
+		buf.WriteString(literal(s.Args[arg]))
+		arg++
+	}
+
+	return buf.String()
+}
+
+// Size approximates the bytes of the statement and its values.
+func (s Stmt) Size() int {
+	n := len(s.SQL)
+
+	for _, a := range s.Args {
+		switch a := a.(type) {
+		case string:
+			n += len(a)
+		case []byte:
+			n += len(a)
+		}
+	}
+
+	return n
+}
+
+func literal(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case []byte:
+		return fmt.Sprintf("x'%x'", v)
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	}
+
+	return fmt.Sprintf("'%v'", v)
+}
@@ -0,0 +1,179 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type Policy string
+
+const (
+	// PolicyNever stops everything when a component fails.
+	PolicyNever Policy = "never"
+	// PolicyOnFailure restarts a failed component with backoff,
+	// and stops everything once it has failed MaxRestarts times.
+	PolicyOnFailure Policy = "on-failure"
+
+	maxBackoff = time.Minute
+)
+
+var ErrShutdownTimeout = errors.New("shutdown timed out")
+
+// Config is the restart policy. A component that ran for ResetAfter
+// before failing is healthy again, its restarts and backoff start over.
+type Config struct {
+	Policy          Policy
+	MaxRestarts     int
+	Backoff         time.Duration
+	ResetAfter      time.Duration
+	ShutdownTimeout time.Duration
+}
+
+// Permanent marks an error restarting the component won't
+// fix, so the component isn't restarted whatever the policy.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Component is a long running part of the process. Run should block
+// until the context is done, and then return once it has stopped.
+type Component struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+type Supervisor struct {
+	cfg        Config
+	components []Component
+}
+
+func New(cfg Config, components ...Component) (*Supervisor, error) {
+	switch cfg.Policy {
+	case PolicyNever, PolicyOnFailure:
+	default:
+		return nil, fmt.Errorf("unknown restart policy %q", cfg.Policy)
+	}
+
+	return &Supervisor{cfg: cfg, components: components}, nil
+}
+
+// Run runs the components until the context is done, or until one
+// fails and can't be restarted. Either way all the components are
+// stopped before it returns. It returns the error from the failed
+// component, or nil if it was stopped by the context.
+func (s *Supervisor) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg      sync.WaitGroup
+		errOnce sync.Once
+		failed  error
+	)
+
+	for _, c := range s.components {
+		wg.Add(1)
+
+		go func(c Component) {
+			defer wg.Done()
+
+			if err := s.supervise(ctx, c); err != nil {
+				errOnce.Do(func() { failed = err })
+				cancel()
+			}
+		}(c)
+	}
+
+	<-ctx.Done()
+
+	log.Info().Msg("stopping")
+
+	stopped := make(chan struct{})
+
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+
+	var timeout <-chan time.Time
+	if s.cfg.ShutdownTimeout > 0 {
+		timeout = time.After(s.cfg.ShutdownTimeout)
+	}
+
+	select {
+	case <-stopped:
+	case <-timeout:
+		return ErrShutdownTimeout
+	}
+
+	log.Info().Msg("stopped")
+
+	return failed
+}
+
+// supervise runs the component, restarting it according to the
+// policy. It returns nil once the context is done.
+func (s *Supervisor) supervise(ctx context.Context, c Component) error {
+	backoff := s.cfg.Backoff
+	restarts := 0
+
+	for {
+		log.Info().Msgf("starting %s", c.Name)
+
+		started := time.Now()
+		err := c.Run(ctx)
+
+		if ctx.Err() != nil {
+			if err != nil && !errors.Is(err, context.Canceled) {
+				log.Warn().Err(err).Msgf("%s stopped", c.Name)
+			} else {
+				log.Info().Msgf("%s stopped", c.Name)
+			}
+
+			return nil
+		}
+
+		if err == nil {
+			err = errors.New("stopped unexpectedly")
+		}
+
+		err = fmt.Errorf("%s: %w", c.Name, err)
+
+		if s.cfg.ResetAfter > 0 && time.Since(started) >= s.cfg.ResetAfter {
+			restarts, backoff = 0, s.cfg.Backoff
+		}
+
+		var permanent *permanentError
+
+		if s.cfg.Policy == PolicyNever || restarts >= s.cfg.MaxRestarts || errors.As(err, &permanent) {
+			log.Error().Err(err).Msgf("%s failed, stopping", c.Name)
+			return err
+		}
+
+		log.Warn().Err(err).Msgf("%s failed, restarting in %s", c.Name, backoff)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+
+		restarts++
+
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
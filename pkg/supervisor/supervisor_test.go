@@ -0,0 +1,203 @@
+package supervisor_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zknill/sqledge/pkg/supervisor"
+)
+
+func blocking(stopped *atomic.Bool) supervisor.Component {
+	return supervisor.Component{
+		Name: "blocking",
+		Run: func(ctx context.Context) error {
+			<-ctx.Done()
+			stopped.Store(true)
+			return ctx.Err()
+		},
+	}
+}
+
+func failing(runs *atomic.Int32) supervisor.Component {
+	return supervisor.Component{
+		Name: "failing",
+		Run: func(ctx context.Context) error {
+			runs.Add(1)
+			return errors.New("boom")
+		},
+	}
+}
+
+func TestSupervisor(t *testing.T) {
+	t.Run("stops on context", func(t *testing.T) {
+		var stopped atomic.Bool
+
+		s, err := supervisor.New(supervisor.Config{Policy: supervisor.PolicyNever}, blocking(&stopped))
+		assert.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		assert.NoError(t, s.Run(ctx))
+		assert.True(t, stopped.Load())
+	})
+
+	t.Run("never restarts", func(t *testing.T) {
+		var (
+			stopped atomic.Bool
+			runs    atomic.Int32
+		)
+
+		s, err := supervisor.New(
+			supervisor.Config{Policy: supervisor.PolicyNever, MaxRestarts: 3},
+			blocking(&stopped), failing(&runs),
+		)
+		assert.NoError(t, err)
+
+		err = s.Run(context.Background())
+		assert.ErrorContains(t, err, "failing: boom")
+		assert.Equal(t, int32(1), runs.Load())
+		assert.True(t, stopped.Load())
+	})
+
+	t.Run("restarts on failure", func(t *testing.T) {
+		var (
+			stopped atomic.Bool
+			runs    atomic.Int32
+		)
+
+		s, err := supervisor.New(
+			supervisor.Config{Policy: supervisor.PolicyOnFailure, MaxRestarts: 2, Backoff: time.Millisecond},
+			blocking(&stopped), failing(&runs),
+		)
+		assert.NoError(t, err)
+
+		err = s.Run(context.Background())
+		assert.ErrorContains(t, err, "failing: boom")
+		assert.Equal(t, int32(3), runs.Load())
+		assert.True(t, stopped.Load())
+	})
+
+	t.Run("permanent failure", func(t *testing.T) {
+		var runs atomic.Int32
+
+		lost := supervisor.Component{
+			Name: "lost",
+			Run: func(ctx context.Context) error {
+				runs.Add(1)
+				return supervisor.Permanent(errors.New("slot lost"))
+			},
+		}
+
+		s, err := supervisor.New(
+			supervisor.Config{Policy: supervisor.PolicyOnFailure, MaxRestarts: 2, Backoff: time.Millisecond},
+			lost,
+		)
+		assert.NoError(t, err)
+
+		assert.ErrorContains(t, s.Run(context.Background()), "lost: slot lost")
+		assert.Equal(t, int32(1), runs.Load())
+	})
+
+	t.Run("slot and restart policy", func(t *testing.T) {
+		// the replicator's slot is only kept across a dropped
+		// connection if it's permanent, a temporary one is lost
+		replicator := func(permanentSlot bool, runs *atomic.Int32) supervisor.Component {
+			return supervisor.Component{
+				Name: "replicate",
+				Run: func(ctx context.Context) error {
+					switch {
+					case runs.Add(1) == 1:
+						return errors.New("connection reset")
+					case !permanentSlot:
+						return supervisor.Permanent(errors.New("slot lost"))
+					}
+
+					<-ctx.Done()
+					return ctx.Err()
+				},
+			}
+		}
+
+		cfg := supervisor.Config{Policy: supervisor.PolicyOnFailure, MaxRestarts: 5, Backoff: time.Millisecond}
+
+		var runs atomic.Int32
+
+		s, err := supervisor.New(cfg, replicator(true, &runs))
+		assert.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		assert.NoError(t, s.Run(ctx))
+		assert.Equal(t, int32(2), runs.Load())
+
+		runs.Store(0)
+
+		s, err = supervisor.New(cfg, replicator(false, &runs))
+		assert.NoError(t, err)
+
+		assert.ErrorContains(t, s.Run(context.Background()), "replicate: slot lost")
+		assert.Equal(t, int32(2), runs.Load())
+	})
+
+	t.Run("resets after a healthy run", func(t *testing.T) {
+		var runs atomic.Int32
+
+		// the third run is healthy for long enough to
+		// reset the restarts, the rest fail straight away
+		flaky := supervisor.Component{
+			Name: "flaky",
+			Run: func(ctx context.Context) error {
+				if runs.Add(1) == 3 {
+					time.Sleep(20 * time.Millisecond)
+				}
+
+				return errors.New("boom")
+			},
+		}
+
+		s, err := supervisor.New(
+			supervisor.Config{
+				Policy:      supervisor.PolicyOnFailure,
+				MaxRestarts: 2,
+				Backoff:     time.Millisecond,
+				ResetAfter:  10 * time.Millisecond,
+			},
+			flaky,
+		)
+		assert.NoError(t, err)
+
+		assert.ErrorContains(t, s.Run(context.Background()), "flaky: boom")
+		assert.Equal(t, int32(5), runs.Load())
+	})
+
+	t.Run("shutdown timeout", func(t *testing.T) {
+		stuck := supervisor.Component{
+			Name: "stuck",
+			Run: func(ctx context.Context) error {
+				select {}
+			},
+		}
+
+		s, err := supervisor.New(
+			supervisor.Config{Policy: supervisor.PolicyNever, ShutdownTimeout: 10 * time.Millisecond},
+			stuck,
+		)
+		assert.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		assert.ErrorIs(t, s.Run(ctx), supervisor.ErrShutdownTimeout)
+	})
+
+	t.Run("unknown policy", func(t *testing.T) {
+		_, err := supervisor.New(supervisor.Config{Policy: "sometimes"})
+		assert.Error(t, err)
+	})
+}
@@ -17,7 +17,9 @@ type Conn interface {
 	Exec(ctx context.Context, sql string) *pgconn.MultiResultReader
 }
 
-func Copy(ctx context.Context, table string, def []sqlgen.ColDef, c Conn) ([][]string, error) {
+// Copy copies the table's rows, the values are decoded
+// to strings, or nil for NULL.
+func Copy(ctx context.Context, table string, def []sqlgen.ColDef, c Conn) ([][]any, error) {
 	var err error
 	// no position stored
 	// copy the entire database
@@ -45,7 +47,7 @@ func Copy(ctx context.Context, table string, def []sqlgen.ColDef, c Conn) ([][]s
 		return nil, fmt.Errorf("build decs: %w", err)
 	}
 
-	cols := [][]string{}
+	cols := [][]any{}
 
 	for {
 		if buf.peekNextByte(0xff, 2) {
@@ -53,7 +55,7 @@ func Copy(ctx context.Context, table string, def []sqlgen.ColDef, c Conn) ([][]s
 			break
 		}
 
-		row := []string{}
+		row := []any{}
 
 		nFields := buf.popInt16()
 
@@ -65,7 +67,7 @@ func Copy(ctx context.Context, table string, def []sqlgen.ColDef, c Conn) ([][]s
 			if buf.peekNextByte(0xff, 4) {
 				_ = buf.popBytes(4)
 
-				row = append(row, "null")
+				row = append(row, nil)
 
 				continue
 			}
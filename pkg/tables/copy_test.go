@@ -82,7 +82,7 @@ func TestCopy(t *testing.T) {
 	cols, err := tables.Copy(context.Background(), "alltypes", def, conn)
 	assert.NoError(t, err)
 
-	want := [][]string{{
+	want := [][]any{{
 		"1",
 		"2",
 		"3",
@@ -11,29 +11,38 @@ type Querier interface {
 	Query(query string, args ...any) (*sql.Rows, error)
 }
 
-func ColDefs(db Querier, table string) ([]sqlgen.ColDef, error) {
+func ColDefs(db Querier, schema, table string) ([]sqlgen.ColDef, error) {
 	query := `
-	SELECT column_name column, udt_name as type
-    FROM information_schema.columns 
-	WHERE table_name = $1
-	ORDER BY ordinal_position;
+	SELECT a.attname, t.typname, a.attnotnull,
+		coalesce(pg_get_expr(d.adbin, d.adrelid), '')
+	FROM pg_catalog.pg_attribute a
+	JOIN pg_catalog.pg_class c ON c.oid = a.attrelid
+	JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+	JOIN pg_catalog.pg_type t ON t.oid = a.atttypid
+	LEFT JOIN pg_catalog.pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+	WHERE n.nspname = $1
+	AND c.relname = $2
+	AND a.attnum > 0
+	AND NOT a.attisdropped
+	ORDER BY a.attnum;
 	`
 
-	rows, err := db.Query(query, table)
+	rows, err := db.Query(query, schema, table)
 	if err != nil {
 		return nil, fmt.Errorf("query schema: %w", err)
 	}
+	defer rows.Close()
 
-	var n, t string
-	var arr bool
+	var n, t, def string
+	var arr, notNull bool
 
 	defs := []sqlgen.ColDef{}
 
 	for rows.Next() {
 		arr = false
 
-		if err := rows.Scan(&n, &t); err != nil {
-			return nil, fmt.Errorf("scan")
+		if err := rows.Scan(&n, &t, &notNull, &def); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
 		}
 
 		if t[0] == '_' {
@@ -42,16 +51,70 @@ func ColDefs(db Querier, table string) ([]sqlgen.ColDef, error) {
 		}
 
 		defs = append(defs, sqlgen.ColDef{
-			Name:       n,
-			Type:       sqlgen.ColType(t),
-			PrimaryKey: false,
-			Array:      arr,
+			Name:    n,
+			Type:    sqlgen.ColType(t),
+			Array:   arr,
+			NotNull: notNull,
+			Default: def,
 		})
 	}
 
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("read schema: %w", err)
+	}
+
+	if err := constraints(db, schema, table, defs); err != nil {
+		return nil, fmt.Errorf("constraints: %w", err)
+	}
+
 	return defs, nil
 }
 
+// constraints marks the primary key and unique columns in defs.
+func constraints(db Querier, schema, table string, defs []sqlgen.ColDef) error {
+	query := `
+	SELECT con.conname, con.contype, a.attname
+	FROM pg_catalog.pg_constraint con
+	JOIN pg_catalog.pg_class c ON c.oid = con.conrelid
+	JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+	JOIN LATERAL unnest(con.conkey) WITH ORDINALITY AS k(attnum, ord) ON true
+	JOIN pg_catalog.pg_attribute a ON a.attrelid = c.oid AND a.attnum = k.attnum
+	WHERE n.nspname = $1
+	AND c.relname = $2
+	AND con.contype IN ('p', 'u')
+	ORDER BY con.conname, k.ord;
+	`
+
+	rows, err := db.Query(query, schema, table)
+	if err != nil {
+		return fmt.Errorf("query constraints: %w", err)
+	}
+	defer rows.Close()
+
+	var name, kind, col string
+
+	for rows.Next() {
+		if err := rows.Scan(&name, &kind, &col); err != nil {
+			return fmt.Errorf("scan: %w", err)
+		}
+
+		for i := range defs {
+			if defs[i].Name != col {
+				continue
+			}
+
+			switch kind {
+			case "p":
+				defs[i].PrimaryKey = true
+			case "u":
+				defs[i].Unique = append(defs[i].Unique, name)
+			}
+		}
+	}
+
+	return rows.Err()
+}
+
 func TableColDefs(db Querier, schema string, filterTables []string) (map[string][]sqlgen.ColDef, error) {
 	tables := make([]string, len(filterTables))
 	copy(tables, filterTables)
@@ -68,7 +131,7 @@ func TableColDefs(db Querier, schema string, filterTables []string) (map[string]
 	out := make(map[string][]sqlgen.ColDef)
 
 	for _, t := range tables {
-		defs, err := ColDefs(db, t)
+		defs, err := ColDefs(db, schema, t)
 		if err != nil {
 			return nil, fmt.Errorf("col definitions for %q.%q: %w", schema, t, err)
 		}
@@ -0,0 +1,64 @@
+package tables
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zknill/sqledge/pkg/sqlgen"
+)
+
+// Indexes reads the btree index definitions in the schema. Primary
+// keys and indexes backing unique constraints are skipped, they
+// are part of the table definition. Indexes on expressions have
+// an empty column name in their Columns.
+func Indexes(db Querier, schema string) ([]sqlgen.IndexDef, error) {
+	query := `
+	SELECT i.relname, t.relname, ix.indisunique,
+		coalesce(pg_get_expr(ix.indpred, ix.indrelid), ''),
+		array_to_string(array(
+			SELECT coalesce(a.attname, '')
+			FROM unnest(ix.indkey::int2[]) WITH ORDINALITY AS k(attnum, ord)
+			LEFT JOIN pg_catalog.pg_attribute a ON a.attrelid = ix.indrelid AND a.attnum = k.attnum
+			WHERE k.ord <= ix.indnkeyatts
+			ORDER BY k.ord
+		), ',')
+	FROM pg_catalog.pg_index ix
+	JOIN pg_catalog.pg_class i ON i.oid = ix.indexrelid
+	JOIN pg_catalog.pg_class t ON t.oid = ix.indrelid
+	JOIN pg_catalog.pg_namespace n ON n.oid = t.relnamespace
+	JOIN pg_catalog.pg_am am ON am.oid = i.relam
+	WHERE n.nspname = $1
+	AND am.amname = 'btree'
+	AND NOT ix.indisprimary
+	AND NOT EXISTS (
+		SELECT 1 FROM pg_catalog.pg_constraint con
+		WHERE con.conindid = ix.indexrelid
+	)
+	ORDER BY t.relname, i.relname;
+	`
+
+	rows, err := db.Query(query, schema)
+	if err != nil {
+		return nil, fmt.Errorf("query indexes: %w", err)
+	}
+	defer rows.Close()
+
+	var (
+		out  []sqlgen.IndexDef
+		cols string
+	)
+
+	for rows.Next() {
+		var idx sqlgen.IndexDef
+
+		if err := rows.Scan(&idx.Name, &idx.Table, &idx.Unique, &idx.Where, &cols); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+
+		idx.Columns = strings.Split(cols, ",")
+
+		out = append(out, idx)
+	}
+
+	return out, rows.Err()
+}
@@ -0,0 +1,39 @@
+package tables
+
+import (
+	"fmt"
+
+	"github.com/zknill/sqledge/pkg/sqlgen"
+)
+
+// Sequences reads the sequences in the schema. The last value is null
+// until nextval is first called, or if the user can't read the
+// sequence, and then the start value is the next value.
+func Sequences(db Querier, schema string) ([]sqlgen.SequenceDef, error) {
+	query := `
+	SELECT sequencename, coalesce(last_value, start_value), increment_by, last_value IS NOT NULL
+	FROM pg_catalog.pg_sequences
+	WHERE schemaname = $1
+	ORDER BY sequencename;
+	`
+
+	rows, err := db.Query(query, schema)
+	if err != nil {
+		return nil, fmt.Errorf("query sequences: %w", err)
+	}
+	defer rows.Close()
+
+	var out []sqlgen.SequenceDef
+
+	for rows.Next() {
+		var seq sqlgen.SequenceDef
+
+		if err := rows.Scan(&seq.Name, &seq.LastValue, &seq.Increment, &seq.Called); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+
+		out = append(out, seq)
+	}
+
+	return out, rows.Err()
+}
@@ -0,0 +1,90 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type Config struct {
+	Endpoint    string
+	Insecure    bool
+	SampleRatio float64
+}
+
+// Setup installs the global tracer provider, exporting spans to the
+// OTLP endpoint. Without an endpoint spans aren't recorded. The
+// returned func flushes and stops the exporter.
+func Setup(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	if cfg.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.Endpoint)}
+	if cfg.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("otlp exporter: %w", err)
+	}
+
+	res, err := resource.Merge(
+		resource.Default(),
+		resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName("sqledge")),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+func Tracer(name string) trace.Tracer {
+	return otel.Tracer("github.com/zknill/sqledge/pkg/" + name)
+}
+
+// sqlcommenter style comment, e.g. /*traceparent='00-...-01'*/
+var commentTraceparent = regexp.MustCompile(`(?i)/\*.*\btraceparent='([^']+)'.*\*/`)
+
+// FromTraceparent returns the context with the remote span
+// from a W3C traceparent, or the context as is if it isn't valid.
+func FromTraceparent(ctx context.Context, traceparent string) context.Context {
+	if traceparent == "" {
+		return ctx
+	}
+
+	carrier := propagation.MapCarrier{"traceparent": traceparent}
+
+	return otel.GetTextMapPropagator().Extract(ctx, carrier)
+}
+
+// FromComment returns the context with the remote span from a
+// traceparent in a SQL comment, if the query has one.
+func FromComment(ctx context.Context, query string) context.Context {
+	m := commentTraceparent.FindStringSubmatch(query)
+	if m == nil {
+		return ctx
+	}
+
+	return FromTraceparent(ctx, m[1])
+}
@@ -0,0 +1,54 @@
+package tracing_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zknill/sqledge/pkg/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestFromComment(t *testing.T) {
+	_, err := tracing.Setup(context.Background(), tracing.Config{})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		query   string
+		traceID string
+	}{
+		{
+			name:    "sqlcommenter",
+			query:   "select * from names /*traceparent='00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01'*/",
+			traceID: "4bf92f3577b34da6a3ce929d0e0e4736",
+		},
+		{
+			name:    "with other tags",
+			query:   "select 1 /*application='app',traceparent='00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01'*/",
+			traceID: "4bf92f3577b34da6a3ce929d0e0e4736",
+		},
+		{
+			name:  "no comment",
+			query: "select * from names",
+		},
+		{
+			name:  "invalid traceparent",
+			query: "select 1 /*traceparent='nope'*/",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sc := trace.SpanContextFromContext(tracing.FromComment(context.Background(), tc.query))
+
+			if tc.traceID == "" {
+				assert.False(t, sc.IsValid())
+				return
+			}
+
+			assert.True(t, sc.IsRemote())
+			assert.Equal(t, tc.traceID, sc.TraceID().String())
+		})
+	}
+}
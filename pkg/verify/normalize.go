@@ -0,0 +1,158 @@
+package verify
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/zknill/sqledge/pkg/sqlgen"
+)
+
+// null can't be confused with a value, postgres text can't hold a NUL.
+const null = "\x00null"
+
+// Normalize formats a value read from postgres, as text, or from
+// SQLite, so the same value formats the same on both sides. Values
+// are compared as the SQLite type the column is copied as.
+func Normalize(col sqlgen.ColDef, v any) string {
+	if v == nil {
+		return null
+	}
+
+	switch sqlgen.SQLiteType(col) {
+	case sqlgen.SQLiteColTypeInteger:
+		return normalizeInteger(v)
+	case sqlgen.SQLiteColTypeReal:
+		return normalizeReal(v)
+	case sqlgen.SQLiteColTypeBlob:
+		return normalizeBlob(v)
+	}
+
+	s := text(v)
+
+	switch {
+	case col.Array:
+		return normalizeArray(col, s)
+	case col.Type == sqlgen.PgColTypeBool:
+		return normalizeBool(s)
+	}
+
+	return s
+}
+
+func text(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+
+	return fmt.Sprint(v)
+}
+
+func normalizeInteger(v any) string {
+	switch v := v.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		if v == float64(int64(v)) {
+			return strconv.FormatInt(int64(v), 10)
+		}
+	}
+
+	s := strings.TrimSpace(text(v))
+
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return strconv.FormatInt(i, 10)
+	}
+
+	return s
+}
+
+func normalizeReal(v any) string {
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case int64:
+		return strconv.FormatFloat(float64(v), 'g', -1, 64)
+	}
+
+	s := strings.TrimSpace(text(v))
+
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+
+	return s
+}
+
+// normalizeBlob formats bytes as hex. Postgres' text format for
+// bytea, \x and then hex, is read from the upstream and is what
+// changes from replication are stored as.
+func normalizeBlob(v any) string {
+	if b, ok := v.([]byte); ok {
+		return hex.EncodeToString(b)
+	}
+
+	s := text(v)
+
+	if h, ok := strings.CutPrefix(s, `\x`); ok {
+		return strings.ToLower(h)
+	}
+
+	return hex.EncodeToString([]byte(s))
+}
+
+func normalizeBool(s string) string {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "t", "true":
+		return "true"
+	case "f", "false":
+		return "false"
+	}
+
+	return s
+}
+
+// normalizeArray formats a one dimensional array without the
+// spaces or quotes, the copy and postgres' text format differ.
+func normalizeArray(col sqlgen.ColDef, s string) string {
+	s = strings.TrimSpace(s)
+
+	inner, ok := strings.CutPrefix(s, "{")
+	if !ok {
+		return s
+	}
+
+	inner, ok = strings.CutSuffix(inner, "}")
+	if !ok {
+		return s
+	}
+
+	if inner == "" {
+		return "{}"
+	}
+
+	elem := sqlgen.ColDef{Type: col.Type}
+	elems := strings.Split(inner, ",")
+
+	for i, e := range elems {
+		e = strings.Trim(strings.TrimSpace(e), `"`)
+		e = strings.ReplaceAll(e, `\\`, `\`)
+
+		switch {
+		case strings.EqualFold(e, "null"):
+			e = "null"
+		case col.Type == sqlgen.PgColTypeBool:
+			e = normalizeBool(e)
+		default:
+			e = Normalize(elem, e)
+		}
+
+		elems[i] = e
+	}
+
+	return "{" + strings.Join(elems, ",") + "}"
+}
@@ -0,0 +1,60 @@
+package verify_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zknill/sqledge/pkg/sqlgen"
+	"github.com/zknill/sqledge/pkg/verify"
+)
+
+func TestNormalize(t *testing.T) {
+	tcs := map[string]struct {
+		col      sqlgen.ColDef
+		upstream any
+		local    any
+	}{
+		"integer": {
+			col:      sqlgen.ColDef{Type: sqlgen.PgColTypeInt8},
+			upstream: "42",
+			local:    int64(42),
+		},
+		"numeric": {
+			col:      sqlgen.ColDef{Type: sqlgen.PgColTypeNum},
+			upstream: "1.50",
+			local:    1.5,
+		},
+		"bool from the stream": {
+			col:      sqlgen.ColDef{Type: sqlgen.PgColTypeBool},
+			upstream: "true",
+			local:    "t",
+		},
+		"bytea from the copy": {
+			col:      sqlgen.ColDef{Type: sqlgen.PgColTypeBytea},
+			upstream: `\x6162`,
+			local:    []byte("ab"),
+		},
+		"bytea from the stream": {
+			col:      sqlgen.ColDef{Type: sqlgen.PgColTypeBytea},
+			upstream: `\x6162`,
+			local:    `\x6162`,
+		},
+		"array": {
+			col:      sqlgen.ColDef{Type: sqlgen.PgColTypeText, Array: true},
+			upstream: `{a,"b c",NULL}`,
+			local:    `{"a", "b c", null}`,
+		},
+		"null": {
+			col: sqlgen.ColDef{Type: sqlgen.PgColTypeText},
+		},
+	}
+
+	for name, tc := range tcs {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, verify.Normalize(tc.col, tc.upstream), verify.Normalize(tc.col, tc.local))
+		})
+	}
+
+	col := sqlgen.ColDef{Type: sqlgen.PgColTypeText}
+	assert.NotEqual(t, verify.Normalize(col, nil), verify.Normalize(col, "null"))
+}
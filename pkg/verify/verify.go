@@ -0,0 +1,438 @@
+package verify
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zknill/sqledge/pkg/sqlgen"
+)
+
+// Querier is a postgres or SQLite transaction,
+// reading from a snapshot at the same LSN.
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+type Options struct {
+	// ChunkSize is the rows compared at once, ordered by
+	// primary key. Throttle is the pause between chunks.
+	ChunkSize int
+	Throttle  time.Duration
+
+	// MaxKeys bounds the keys listed for each kind of difference.
+	MaxKeys int
+}
+
+type Report struct {
+	LSN             string        `json:"lsn"`
+	Match           bool          `json:"match"`
+	Tables          []TableReport `json:"tables"`
+	Repaired        []string      `json:"repaired,omitempty"`
+	DurationSeconds float64       `json:"duration_seconds"`
+}
+
+type TableReport struct {
+	Table        string `json:"table"`
+	Match        bool   `json:"match"`
+	UpstreamRows int64  `json:"upstream_rows"`
+	LocalRows    int64  `json:"local_rows"`
+
+	// Keyless tables only have their row counts compared.
+	Keyless bool `json:"keyless,omitempty"`
+
+	Chunks          int `json:"chunks"`
+	ChunksDiffering int `json:"chunks_differing"`
+
+	Missing   int `json:"missing"`
+	Extra     int `json:"extra"`
+	Differing int `json:"differing"`
+
+	MissingKeys   []string `json:"missing_keys,omitempty"`
+	ExtraKeys     []string `json:"extra_keys,omitempty"`
+	DifferingKeys []string `json:"differing_keys,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// Compare compares every table in defs, upstream in the schema
+// with the local copy. A table that can't be compared is reported
+// with its error, only the context being done stops the compare.
+func Compare(ctx context.Context, upstream, local Querier, schema string, defs map[string][]sqlgen.ColDef, opts Options) (*Report, error) {
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = 1000
+	}
+
+	report := &Report{Match: true, Tables: []TableReport{}}
+
+	for _, table := range sortedTables(defs) {
+		t := &tableCompare{
+			upstream: upstream,
+			local:    local,
+			schema:   schema,
+			table:    table,
+			cols:     defs[table],
+			opts:     opts,
+			report:   TableReport{Table: table},
+		}
+
+		if err := t.compare(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+
+			t.report.Error = err.Error()
+		}
+
+		r := t.report
+		r.Match = r.Error == "" && r.UpstreamRows == r.LocalRows &&
+			r.Missing == 0 && r.Extra == 0 && r.Differing == 0
+
+		report.Match = report.Match && r.Match
+		report.Tables = append(report.Tables, r)
+	}
+
+	return report, nil
+}
+
+type tableCompare struct {
+	upstream, local Querier
+	schema, table   string
+	cols            []sqlgen.ColDef
+	opts            Options
+
+	// indexes of the primary key columns
+	pk []int
+
+	report TableReport
+}
+
+type row struct {
+	// raw is the upstream text of the key, to query from
+	raw    []any
+	key    string
+	values []string
+}
+
+func (t *tableCompare) compare(ctx context.Context) error {
+	for i, col := range t.cols {
+		if col.PrimaryKey {
+			t.pk = append(t.pk, i)
+		}
+	}
+
+	if len(t.pk) == 0 {
+		t.report.Keyless = true
+		return t.counts(ctx)
+	}
+
+	var lower *row
+
+	for {
+		up, err := t.upstreamChunk(ctx, lower)
+		if err != nil {
+			return fmt.Errorf("read upstream: %w", err)
+		}
+
+		last := len(up) < t.opts.ChunkSize
+
+		var upper *row
+		if !last {
+			upper = up[len(up)-1]
+		}
+
+		loc, err := t.localChunk(ctx, lower, upper)
+		if err != nil {
+			return fmt.Errorf("read local: %w", err)
+		}
+
+		t.diff(up, loc)
+
+		if last {
+			return nil
+		}
+
+		lower = upper
+
+		if t.opts.Throttle > 0 {
+			select {
+			case <-time.After(t.opts.Throttle):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+func (t *tableCompare) counts(ctx context.Context) error {
+	if err := queryRow(ctx, t.upstream, fmt.Sprintf(`SELECT count(*) FROM %s.%s;`, quote(t.schema), quote(t.table)), &t.report.UpstreamRows); err != nil {
+		return fmt.Errorf("count upstream: %w", err)
+	}
+
+	if err := queryRow(ctx, t.local, fmt.Sprintf(`SELECT count(*) FROM %s;`, quote(t.table)), &t.report.LocalRows); err != nil {
+		return fmt.Errorf("count local: %w", err)
+	}
+
+	return nil
+}
+
+// upstreamChunk reads the next chunk of rows after lower. Text
+// keys are ordered by byte, the same as SQLite's binary collation.
+func (t *tableCompare) upstreamChunk(ctx context.Context, lower *row) ([]*row, error) {
+	exprs := make([]string, len(t.cols))
+
+	for i, col := range t.cols {
+		if sqlgen.SQLiteType(col) == sqlgen.SQLiteColTypeBlob {
+			exprs[i] = fmt.Sprintf(`'\x' || encode(%s, 'hex')`, quote(col.Name))
+		} else {
+			exprs[i] = quote(col.Name) + "::text"
+		}
+	}
+
+	keys := make([]string, len(t.pk))
+	params := make([]string, len(t.pk))
+
+	for i, idx := range t.pk {
+		col := t.cols[idx]
+		keys[i] = quote(col.Name)
+
+		if sqlgen.SQLiteType(col) == sqlgen.SQLiteColTypeText {
+			keys[i] += ` COLLATE "C"`
+		}
+
+		params[i] = fmt.Sprintf("$%d::%s", i+1, quote(string(col.Type)))
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s.%s", strings.Join(exprs, ", "), quote(t.schema), quote(t.table))
+
+	var args []any
+
+	if lower != nil {
+		query += fmt.Sprintf(" WHERE (%s) > (%s)", strings.Join(keys, ", "), strings.Join(params, ", "))
+		args = lower.raw
+	}
+
+	query += fmt.Sprintf(" ORDER BY %s LIMIT %d;", strings.Join(keys, ", "), t.opts.ChunkSize)
+
+	rows, err := t.read(ctx, t.upstream, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	t.report.UpstreamRows += int64(len(rows))
+
+	return rows, nil
+}
+
+// localChunk reads the rows after lower, up to and including upper.
+func (t *tableCompare) localChunk(ctx context.Context, lower, upper *row) ([]*row, error) {
+	cols := make([]string, len(t.cols))
+	for i, col := range t.cols {
+		cols[i] = quote(col.Name)
+	}
+
+	keys := make([]string, len(t.pk))
+	params := make([]string, len(t.pk))
+
+	for i, idx := range t.pk {
+		keys[i] = quote(t.cols[idx].Name)
+		params[i] = "?"
+	}
+
+	var (
+		where []string
+		args  []any
+	)
+
+	if lower != nil {
+		where = append(where, fmt.Sprintf("(%s) > (%s)", strings.Join(keys, ", "), strings.Join(params, ", ")))
+		args = append(args, t.localKey(lower)...)
+	}
+
+	if upper != nil {
+		where = append(where, fmt.Sprintf("(%s) <= (%s)", strings.Join(keys, ", "), strings.Join(params, ", ")))
+		args = append(args, t.localKey(upper)...)
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(cols, ", "), quote(t.table))
+
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+
+	query += fmt.Sprintf(" ORDER BY %s;", strings.Join(keys, ", "))
+
+	rows, err := t.read(ctx, t.local, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	t.report.LocalRows += int64(len(rows))
+
+	return rows, nil
+}
+
+// localKey is the key to query SQLite with, blobs
+// are compared as bytes and the rest as text.
+func (t *tableCompare) localKey(r *row) []any {
+	values := make([]any, len(t.pk))
+
+	for i, idx := range t.pk {
+		v := r.values[idx]
+
+		if sqlgen.SQLiteType(t.cols[idx]) == sqlgen.SQLiteColTypeBlob {
+			b, _ := hex.DecodeString(v)
+			values[i] = b
+
+			continue
+		}
+
+		values[i] = v
+	}
+
+	return values
+}
+
+func (t *tableCompare) read(ctx context.Context, db Querier, query string, args ...any) ([]*row, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*row
+
+	for rows.Next() {
+		raw := make([]any, len(t.cols))
+		dest := make([]any, len(t.cols))
+
+		for i := range raw {
+			dest[i] = &raw[i]
+		}
+
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+
+		r := &row{values: make([]string, len(t.cols))}
+
+		for i, col := range t.cols {
+			r.values[i] = Normalize(col, raw[i])
+		}
+
+		key := make([]string, len(t.pk))
+
+		for i, idx := range t.pk {
+			key[i] = r.values[idx]
+			r.raw = append(r.raw, raw[idx])
+		}
+
+		r.key = strings.Join(key, ", ")
+
+		out = append(out, r)
+	}
+
+	return out, rows.Err()
+}
+
+// diff compares the chunk's checksums, and only
+// compares the rows if they differ.
+func (t *tableCompare) diff(up, loc []*row) {
+	t.report.Chunks++
+
+	if checksum(up) == checksum(loc) {
+		return
+	}
+
+	t.report.ChunksDiffering++
+
+	local := make(map[string]*row, len(loc))
+	for _, r := range loc {
+		local[r.key] = r
+	}
+
+	for _, r := range up {
+		l, ok := local[r.key]
+		if !ok {
+			t.report.Missing++
+			t.report.MissingKeys = t.addKey(t.report.MissingKeys, r.key)
+
+			continue
+		}
+
+		delete(local, r.key)
+
+		if checksum([]*row{r}) != checksum([]*row{l}) {
+			t.report.Differing++
+			t.report.DifferingKeys = t.addKey(t.report.DifferingKeys, r.key)
+		}
+	}
+
+	for _, r := range loc {
+		if _, ok := local[r.key]; ok {
+			t.report.Extra++
+			t.report.ExtraKeys = t.addKey(t.report.ExtraKeys, r.key)
+		}
+	}
+}
+
+func (t *tableCompare) addKey(keys []string, key string) []string {
+	if t.opts.MaxKeys > 0 && len(keys) >= t.opts.MaxKeys {
+		return keys
+	}
+
+	return append(keys, strings.ReplaceAll(key, null, "null"))
+}
+
+func checksum(rows []*row) uint64 {
+	h := fnv.New64a()
+
+	for _, r := range rows {
+		for _, v := range r.values {
+			h.Write([]byte(v))
+			h.Write([]byte{0x1f})
+		}
+
+		h.Write([]byte{0x1e})
+	}
+
+	return h.Sum64()
+}
+
+func queryRow(ctx context.Context, db Querier, query string, dest any) error {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		return sql.ErrNoRows
+	}
+
+	return rows.Scan(dest)
+}
+
+func quote(ident string) string {
+	return `"` + strings.ReplaceAll(ident, `"`, `""`) + `"`
+}
+
+func sortedTables(defs map[string][]sqlgen.ColDef) []string {
+	tables := make([]string, 0, len(defs))
+	for table := range defs {
+		tables = append(tables, table)
+	}
+
+	sort.Strings(tables)
+
+	return tables
+}
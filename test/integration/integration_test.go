@@ -158,9 +158,15 @@ func TestWriteForwarding(t *testing.T) {
 		}
 	}()
 
-	if err := queryproxy.Run(ctx, cfg); err != nil && !errors.Is(err, context.Canceled) {
-		assert.NoError(t, err)
-	}
+	wg.Add(1)
+
+	// start the proxy
+	go func() {
+		defer wg.Done()
+		if err := queryproxy.Run(ctx, cfg); err != nil && !errors.Is(err, context.Canceled) {
+			assert.NoError(t, err)
+		}
+	}()
 
 	<-time.After(1 * time.Second)
 	t.Log("connecting to proxy")